@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/uc-cdis/ws-storage/storage"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
     "github.com/rs/zerolog/log"
@@ -46,8 +47,48 @@ func main() {
 		os.Exit(1)
 	}
 
+	authz, err := storage.NewAuthorizer(config)
+	if nil != err {
+		log.Error().Msgf("Failed to initialize authorizer - got %v", err)
+		os.Exit(1)
+	}
+
+	authn, err := storage.NewAuthenticator(config)
+	if nil != err {
+		log.Error().Msgf("Failed to initialize authenticator - got %v", err)
+		os.Exit(1)
+	}
+
+	storage.RegisterMetricWorkspaceTypes(config)
 	http.Handle("/metrics", promhttp.Handler())
-	storage.SetupHttpListeners(mgr)
+	if nil != config.UsageReport {
+		reporter, err := storage.NewUsageReporter(mgr, config, prometheus.DefaultRegisterer)
+		if nil != err {
+			log.Error().Msgf("Failed to initialize usage report - got %v", err)
+			os.Exit(1)
+		}
+		reporter.Start()
+		http.Handle(storage.UsageReportPath, reporter.Handler(authn))
+	}
+	var trash *storage.TrashBin
+	if nil != config.Trash {
+		trash, err = storage.NewTrashBin(mgr, config)
+		if nil != err {
+			log.Error().Msgf("Failed to initialize trash - got %v", err)
+			os.Exit(1)
+		}
+		trash.Start()
+	}
+	audit, err := storage.NewAuditSink(config.Audit)
+	if nil != err {
+		log.Error().Msgf("Failed to initialize audit log - got %v", err)
+		os.Exit(1)
+	}
+	var proxy *storage.ObjectProxy
+	if nil != config.Proxy {
+		proxy = storage.NewObjectProxy(mgr, config)
+	}
+	storage.SetupHttpListeners(mgr, authz, authn, storage.NewQuotaEnforcer(config.Quota), trash, audit, proxy)
 	log.Info().Msg("ws-storage launching on port 8000")
 	err = http.ListenAndServe("0.0.0.0:8000", nil)
 	if nil != err {
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ArboristAuthorizer asks a Gen3 arborist style policy service
+// whether a user may perform an action on a named workspace -
+//   POST $url/auth/request
+// Each user has full access to their own @user workspace.
+// Arborist identifies the user by the request's bearer token,
+// so the session must carry the token from the Authorization header.
+type ArboristAuthorizer struct {
+	url            string
+	service        string
+	resourcePrefix string
+	httpClient     *http.Client
+}
+
+type arboristAction struct {
+	Service string `json:"service"`
+	Method  string `json:"method"`
+}
+
+type arboristRequest struct {
+	Resource string         `json:"resource"`
+	Action   arboristAction `json:"action"`
+}
+
+type arboristAuthRequest struct {
+	User struct {
+		Token string `json:"token"`
+	} `json:"user"`
+	Request arboristRequest `json:"request"`
+}
+
+type arboristAuthResponse struct {
+	Auth bool `json:"auth"`
+}
+
+// NewArboristAuthorizer makes an authorizer for the given arborist configuration
+func NewArboristAuthorizer(config *ArboristConfig) (*ArboristAuthorizer, error) {
+	if nil == config || "" == config.Url {
+		return nil, fmt.Errorf("arborist authorizer requires arborist.url in config")
+	}
+	service := config.Service
+	if "" == service {
+		service = "ws-storage"
+	}
+	resourcePrefix := config.ResourcePrefix
+	if "" == resourcePrefix {
+		resourcePrefix = "/workspaces"
+	}
+	return &ArboristAuthorizer{
+		url:            strings.TrimSuffix(config.Url, "/"),
+		service:        service,
+		resourcePrefix: strings.TrimSuffix(resourcePrefix, "/"),
+		httpClient:     &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// ResourcePath maps a named workspace $type/$name to
+// its arborist resource $prefix/$type/$name without the @ -
+// ex: @group/lab1 is /workspaces/group/lab1
+func (self *ArboristAuthorizer) ResourcePath(workspaceIn string) string {
+	return self.resourcePrefix + "/" + strings.TrimPrefix(workspaceIn, "@")
+}
+
+// Authorize asks arborist whether the session's user may perform
+// the action (list, read, write, or delete) on the workspace resource
+func (self *ArboristAuthorizer) Authorize(cx *SessionContext, workspaceIn string, action string) (bool, error) {
+	if UserWorkspace == workspaceIn {
+		return true, nil
+	}
+	if "" == cx.Token {
+		return false, nil
+	}
+	authRequest := &arboristAuthRequest{
+		Request: arboristRequest{
+			Resource: self.ResourcePath(workspaceIn),
+			Action:   arboristAction{Service: self.service, Method: action},
+		},
+	}
+	authRequest.User.Token = cx.Token
+	body, err := json.Marshal(authRequest)
+	if nil != err {
+		return false, err
+	}
+	resp, err := self.httpClient.Post(self.url+"/auth/request", "application/json", bytes.NewReader(body))
+	if nil != err {
+		return false, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if nil != err {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("arborist auth request failed with status %v - %v", resp.StatusCode, string(respBody))
+	}
+	authResponse := &arboristAuthResponse{}
+	if err := json.Unmarshal(respBody, authResponse); nil != err {
+		return false, fmt.Errorf("failed to parse arborist response - %v", err)
+	}
+	return authResponse.Auth, nil
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestArboristAuthorizer(t *testing.T) {
+	requestCount := 0
+	failRequests := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount += 1
+		if failRequests {
+			http.Error(w, "arborist is down", http.StatusServiceUnavailable)
+			return
+		}
+		authRequest := &arboristAuthRequest{}
+		if r.URL.Path != "/auth/request" || nil != json.NewDecoder(r.Body).Decode(authRequest) {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		allowed := authRequest.User.Token == "goTestToken" &&
+			authRequest.Request.Resource == "/workspaces/group/lab1" &&
+			authRequest.Request.Action.Service == "ws-storage" &&
+			authRequest.Request.Action.Method == ActionRead
+		fmt.Fprintf(w, `{ "auth": %v }`, allowed)
+	}))
+	defer server.Close()
+
+	arborist, err := NewArboristAuthorizer(&ArboristConfig{Url: server.URL + "/"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize arborist authorizer, got: %v", err))
+		return
+	}
+	authz := NewCachingAuthorizer(arborist, time.Minute)
+	cx := &SessionContext{User: testUser, Token: "goTestToken"}
+	testCases := []struct {
+		cx        *SessionContext
+		workspace string
+		action    string
+		expected  bool
+	}{
+		{cx, "@user", ActionDelete, true},
+		{cx, "@group/lab1", ActionRead, true},
+		{cx, "@group/lab1", ActionWrite, false},
+		{cx, "@group/lab2", ActionRead, false},
+		{NewSessionContext(testUser), "@group/lab1", ActionRead, false},
+	}
+	for _, it := range testCases {
+		allowed, err := authz.Authorize(it.cx, it.workspace, it.action)
+		if nil != err || allowed != it.expected {
+			t.Error(fmt.Sprintf("unexpected decision for %v %v, got: %v, %v", it.action, it.workspace, allowed, err))
+			return
+		}
+	}
+	if requestCount != 3 {
+		t.Error(fmt.Sprintf("expected 3 arborist requests, got: %v", requestCount))
+		return
+	}
+
+	// cached decisions do not hit arborist
+	failRequests = true
+	if allowed, err := authz.Authorize(cx, "@group/lab1", ActionRead); nil != err || !allowed {
+		t.Error(fmt.Sprintf("expected cached decision, got: %v, %v", allowed, err))
+		return
+	}
+	if requestCount != 3 {
+		t.Error(fmt.Sprintf("cached decision should not request arborist, got: %v requests", requestCount))
+		return
+	}
+	// errors are not cached
+	for i := 0; i < 2; i += 1 {
+		if _, err := authz.Authorize(cx, "@group/lab3", ActionRead); nil == err {
+			t.Error("arborist failure should fail authorization")
+			return
+		}
+	}
+	if requestCount != 5 {
+		t.Error(fmt.Sprintf("failed decisions should not be cached, got: %v requests", requestCount))
+		return
+	}
+}
@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Audit sinks selectable with AuditConfig.Sink
+const (
+	AuditSinkFile   = "file"
+	AuditSinkStdout = "stdout"
+	AuditSinkHttp   = "http"
+)
+
+// Audit record outcomes
+const (
+	// AuditOutcomeAllowed - the request was authorized and succeeded
+	AuditOutcomeAllowed = "allowed"
+	// AuditOutcomeDenied - the request failed authentication or authorization
+	AuditOutcomeDenied  = "denied"
+	// AuditOutcomeFailed - the request was invalid, or failed after authorization
+	AuditOutcomeFailed  = "failed"
+)
+
+// Audit record phases - a mutating request has a decision record
+// written before it runs, and an outcome record once it completes
+const (
+	// AuditPhaseDecision - the request was authorized, and is about to run
+	AuditPhaseDecision = "decision"
+	// AuditPhaseOutcome - the request completed with the recorded status
+	AuditPhaseOutcome  = "outcome"
+)
+
+// requestIdHeader carries the request id assigned by the api gateway -
+// ws-storage assigns one if it is missing, and echoes it in the response
+const requestIdHeader = "X-Request-Id"
+
+// AuditRecord is one access decision on workspace data
+type AuditRecord struct {
+	Time        time.Time
+	RequestId   string
+	// User is empty if authentication failed
+	User        string
+	Workspace   string
+	Key         string
+	// Destination is the copy or move destination key
+	Destination string `json:",omitempty"`
+	// Action is the api verb - empty if the request did not parse
+	Action      string
+	// Phase is AuditPhaseDecision or AuditPhaseOutcome
+	Phase       string
+	Outcome     string
+	// StatusCode is the response status - 0 on a decision record
+	StatusCode  int `json:",omitempty"`
+	ClientIp    string
+	// Error is the ApiError code of denied and failed requests
+	Error       string `json:",omitempty"`
+}
+
+// AuditSink stores audit records - Write returns only
+// once the record is stored, or the sink has given up
+type AuditSink interface {
+	Write(record *AuditRecord) error
+	Close() error
+}
+
+// NewAuditSink makes the sink the config selects -
+// nil if auditing is not configured
+func NewAuditSink(config *AuditConfig) (AuditSink, error) {
+	if nil == config {
+		return nil, nil
+	}
+	switch config.Sink {
+	case AuditSinkFile:
+		if "" == config.Path {
+			return nil, fmt.Errorf("audit file sink requires a path")
+		}
+		file, err := os.OpenFile(config.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if nil != err {
+			return nil, fmt.Errorf("failed to open audit log %v - %v", config.Path, err)
+		}
+		return &writerAuditSink{out: file, sync: file.Sync, close: file.Close}, nil
+	case AuditSinkStdout:
+		return &writerAuditSink{out: os.Stdout}, nil
+	case AuditSinkHttp:
+		if "" == config.Url {
+			return nil, fmt.Errorf("audit http sink requires a url")
+		}
+		return newHttpAuditSink(config), nil
+	}
+	return nil, fmt.Errorf("invalid audit sink: %v", config.Sink)
+}
+
+// writerAuditSink appends each record as a json line -
+// a file sink syncs each record to disk before returning
+type writerAuditSink struct {
+	lock  sync.Mutex
+	out   io.Writer
+	sync  func() error
+	close func() error
+}
+
+func (self *writerAuditSink) Write(record *AuditRecord) error {
+	line, err := json.Marshal(record)
+	if nil != err {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, err := self.out.Write(append(line, '\n')); nil != err {
+		return unavailablef("failed to write audit record - %v", err)
+	}
+	if nil != self.sync {
+		if err := self.sync(); nil != err {
+			return unavailablef("failed to sync audit record - %v", err)
+		}
+	}
+	return nil
+}
+
+func (self *writerAuditSink) Close() error {
+	if nil != self.close {
+		return self.close()
+	}
+	return nil
+}
+
+// httpAuditSink posts each record to a collector, and retries
+// with backoff until the collector accepts it
+type httpAuditSink struct {
+	url     string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+func newHttpAuditSink(config *AuditConfig) *httpAuditSink {
+	timeout := 5 * time.Second
+	if config.TimeoutSeconds > 0 {
+		timeout = time.Duration(config.TimeoutSeconds) * time.Second
+	}
+	retries := 3
+	if config.Retries > 0 {
+		retries = config.Retries
+	}
+	return &httpAuditSink{
+		url:     config.Url,
+		client:  &http.Client{Timeout: timeout},
+		retries: retries,
+		backoff: 100 * time.Millisecond,
+	}
+}
+
+func (self *httpAuditSink) Write(record *AuditRecord) error {
+	body, err := json.Marshal(record)
+	if nil != err {
+		return err
+	}
+	backoff := self.backoff
+	for attempt := 0; ; attempt += 1 {
+		err = self.post(body)
+		if nil == err || attempt >= self.retries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if nil != err {
+		return unavailablef("failed to deliver audit record after %v attempts - %v", self.retries+1, err)
+	}
+	return nil
+}
+
+func (self *httpAuditSink) post(body []byte) error {
+	resp, err := self.client.Post(self.url, "application/json", bytes.NewReader(body))
+	if nil != err {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector responded %v", resp.StatusCode)
+	}
+	return nil
+}
+
+func (self *httpAuditSink) Close() error {
+	self.client.CloseIdleConnections()
+	return nil
+}
+
+// requestId is the api gateway's request id, or a new random id
+func requestId(r *http.Request) string {
+	if id := r.Header.Get(requestIdHeader); "" != id {
+		return id
+	}
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// clientIp is the last X-Forwarded-For address - appended by
+// the trusted api gateway hop, while earlier entries are
+// whatever the client sent - or the address of the connection
+func clientIp(r *http.Request) string {
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(forwarded[len(forwarded)-1], ",")
+		if last := strings.TrimSpace(hops[len(hops)-1]); "" != last {
+			return last
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if nil != err {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// newAuditRecord records the outcome of an api request -
+// apiReq is nil if the request failed authentication or did not parse
+func newAuditRecord(r *http.Request, reqId string, user string, apiReq *ApiRequest, result *ApiResult) *AuditRecord {
+	record := &AuditRecord{
+		Time:       time.Now().UTC(),
+		RequestId:  reqId,
+		User:       user,
+		Phase:      AuditPhaseOutcome,
+		Outcome:    AuditOutcomeAllowed,
+		StatusCode: result.StatusCode(),
+		ClientIp:   clientIp(r),
+	}
+	if nil != apiReq {
+		record.Workspace = apiReq.Workspace
+		record.Key = apiReq.Key
+		record.Destination = apiReq.Destination
+		record.Action = apiReq.Verb
+	}
+	if nil != result.Error {
+		record.Error = result.Error.Code
+		switch result.Error.Code {
+		case ErrorCodeUnauthorized, ErrorCodeForbidden:
+			record.Outcome = AuditOutcomeDenied
+		default:
+			record.Outcome = AuditOutcomeFailed
+		}
+	}
+	return record
+}
+
+// auditDecision stores the decision record of an authorized
+// mutating request before it runs - the request must not
+// run if the record fails to store
+func auditDecision(r *http.Request, reqId string, user string, apiReq *ApiRequest) error {
+	record := &AuditRecord{
+		Time:        time.Now().UTC(),
+		RequestId:   reqId,
+		User:        user,
+		Workspace:   apiReq.Workspace,
+		Key:         apiReq.Key,
+		Destination: apiReq.Destination,
+		Action:      apiReq.Verb,
+		Phase:       AuditPhaseDecision,
+		Outcome:     AuditOutcomeAllowed,
+		ClientIp:    clientIp(r),
+	}
+	if err := auditSingleton.Write(record); nil != err {
+		auditFailuresTotal.Inc()
+		line, _ := json.Marshal(record)
+		log.Error().Str("Func", "auditDecision").RawJSON("record", line).Msgf("failed to store audit record - %v", err)
+		return err
+	}
+	return nil
+}
+
+// auditApiRequest stores the outcome audit record of an api request -
+// if the sink fails the result of a read is withheld, so no access
+// goes unrecorded.  A mutating request has already run under its
+// stored decision record, so it keeps its result - a client that
+// saw an error would retry a change that was applied
+func auditApiRequest(r *http.Request, reqId string, user string, apiReq *ApiRequest, result *ApiResult) *ApiResult {
+	if nil == auditSingleton {
+		return result
+	}
+	record := newAuditRecord(r, reqId, user, apiReq, result)
+	if err := auditSingleton.Write(record); nil != err {
+		auditFailuresTotal.Inc()
+		line, _ := json.Marshal(record)
+		log.Error().Str("Func", "auditApiRequest").RawJSON("record", line).Msgf("failed to store audit record - %v", err)
+		if nil != apiReq && apiReq.isMutating() {
+			return result
+		}
+		return newErrorResult(record.Action, err)
+	}
+	return result
+}
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewAuditRecord(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/ws-storage/download/@user/a", nil)
+	r.Header.Set("X-Forwarded-For", "10.1.2.3, 10.0.0.1")
+	apiReq := &ApiRequest{Verb: "download", Workspace: UserWorkspace, Key: "a"}
+	testCases := []struct {
+		result  *ApiResult
+		outcome string
+	}{
+		{&ApiResult{Version: 1, Result: "ok"}, AuditOutcomeAllowed},
+		{newErrorResult("download", fmt.Errorf("%w - nope", ErrForbidden)), AuditOutcomeDenied},
+		{newUnauthorizedResult(), AuditOutcomeDenied},
+		{newErrorResult("download", fmt.Errorf("%w - a", ErrNotFound)), AuditOutcomeFailed},
+	}
+	for _, it := range testCases {
+		record := newAuditRecord(r, "req1", testUser, apiReq, it.result)
+		if it.outcome != record.Outcome || it.result.StatusCode() != record.StatusCode ||
+			"10.0.0.1" != record.ClientIp || "req1" != record.RequestId || AuditPhaseOutcome != record.Phase ||
+			testUser != record.User || "download" != record.Action || "a" != record.Key {
+			t.Error(fmt.Sprintf("unexpected audit record for %v, got: %v", it.result.Result, record))
+		}
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/ws-storage/list/@user", nil)
+	record := newAuditRecord(r, "req2", "", nil, newUnauthorizedResult())
+	if "192.0.2.1" != record.ClientIp || "" != record.Action || ErrorCodeUnauthorized != record.Error {
+		t.Error(fmt.Sprintf("unexpected unauthenticated audit record, got: %v", record))
+	}
+}
+
+func TestRequestId(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/ws-storage/list/@user", nil)
+	if first, second := requestId(r), requestId(r); 32 != len(first) || first == second {
+		t.Error(fmt.Sprintf("unexpected generated request ids, got: %v, %v", first, second))
+	}
+	r.Header.Set(requestIdHeader, "gateway-id")
+	if id := requestId(r); "gateway-id" != id {
+		t.Error(fmt.Sprintf("unexpected request id, got: %v", id))
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewAuditSink(&AuditConfig{Sink: AuditSinkFile, Path: path})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to create file sink, got: %v", err))
+		return
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := sink.Write(&AuditRecord{RequestId: key, Key: key, Outcome: AuditOutcomeAllowed}); nil != err {
+			t.Error(fmt.Sprintf("failed to write audit record, got: %v", err))
+			return
+		}
+	}
+	sink.Close()
+	file, err := os.Open(path)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to open audit log, got: %v", err))
+		return
+	}
+	defer file.Close()
+	keys := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		record := &AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); nil != err {
+			t.Error(fmt.Sprintf("failed to parse audit record, got: %v", err))
+			return
+		}
+		keys = append(keys, record.Key)
+	}
+	if 2 != len(keys) || "a" != keys[0] || "b" != keys[1] {
+		t.Error(fmt.Sprintf("unexpected audit log, got: %v", keys))
+	}
+}
+
+func TestHttpAuditSink(t *testing.T) {
+	posts := 0
+	failures := 2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts += 1
+		record := &AuditRecord{}
+		if err := json.NewDecoder(r.Body).Decode(record); nil != err || "req1" != record.RequestId {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if posts <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := newHttpAuditSink(&AuditConfig{Sink: AuditSinkHttp, Url: server.URL, Retries: 2})
+	sink.backoff = time.Millisecond
+	if err := sink.Write(&AuditRecord{RequestId: "req1"}); nil != err || 3 != posts {
+		t.Error(fmt.Sprintf("expected delivery on the third attempt, got: %v after %v posts", err, posts))
+		return
+	}
+
+	posts, failures = 0, 10
+	if err := sink.Write(&AuditRecord{RequestId: "req1"}); !errors.Is(err, ErrUnavailable) || 3 != posts {
+		t.Error(fmt.Sprintf("expected unavailable after 3 attempts, got: %v after %v posts", err, posts))
+	}
+}
+
+// testAuditSink keeps records in memory, or fails
+type testAuditSink struct {
+	records []*AuditRecord
+	err     error
+}
+
+func (self *testAuditSink) Write(record *AuditRecord) error {
+	if nil != self.err {
+		return self.err
+	}
+	self.records = append(self.records, record)
+	return nil
+}
+
+func (self *testAuditSink) Close() error {
+	return nil
+}
+
+func TestAuditApiRequest(t *testing.T) {
+	defer func() { auditSingleton = nil }()
+	r := httptest.NewRequest(http.MethodGet, "/ws-storage/upload/@user/a", nil)
+	apiReq := &ApiRequest{Verb: "upload", Workspace: UserWorkspace, Key: "a"}
+	ok := &ApiResult{Version: 1, Method: "upload", Result: "ok", Data: "https://signed"}
+
+	sink := &testAuditSink{}
+	auditSingleton = sink
+	if result := auditApiRequest(r, "req1", testUser, apiReq, ok); result != ok || 1 != len(sink.records) {
+		t.Error(fmt.Sprintf("expected the result and one record, got: %v, %v", result, sink.records))
+		return
+	}
+
+	// a failed sink withholds the signed url
+	auditSingleton = &testAuditSink{err: unavailablef("collector down")}
+	result := auditApiRequest(r, "req2", testUser, apiReq, ok)
+	if http.StatusServiceUnavailable != result.StatusCode() || nil != result.Data {
+		t.Error(fmt.Sprintf("expected unavailable result, got: %v", result))
+	}
+
+	if _, err := NewAuditSink(&AuditConfig{Sink: "syslog"}); nil == err {
+		t.Error("expected invalid sink to fail")
+	}
+	if sink, err := NewAuditSink(nil); nil != sink || nil != err {
+		t.Error(fmt.Sprintf("expected no sink without config, got: %v, %v", sink, err))
+	}
+}
+
+func TestAuditDecision(t *testing.T) {
+	defer func() { auditSingleton = nil }()
+	mgr, _ := NewMemoryManager(&Config{BucketPrefix: "ws-storage-testsuite"})
+	mgr.writeBlob("ws-storage-testsuite/goTestUser/a", strings.NewReader("abc"), nil)
+	authz := NewStaticAuthorizer(nil)
+	r := httptest.NewRequest(http.MethodDelete, "/ws-storage/list/@user/a", nil)
+	newDelete := func() *ApiRequest {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/list/@user/a")
+		apiReq, _ := NewApiRequest(testUrl, http.MethodDelete, testUser)
+		apiReq.recordDecision = func() error { return auditDecision(r, "req1", testUser, apiReq) }
+		return apiReq
+	}
+
+	// the delete does not run if its decision is not recorded
+	auditSingleton = &testAuditSink{err: unavailablef("collector down")}
+	if result := newDelete().HandleApiRequest(mgr, authz, nil, nil, nil); http.StatusServiceUnavailable != result.StatusCode() {
+		t.Error(fmt.Sprintf("expected unavailable result, got: %v", result))
+		return
+	}
+	if _, err := mgr.Stat(NewSessionContext(testUser), "@user", "a"); nil != err {
+		t.Error(fmt.Sprintf("object should survive an unrecorded delete, got: %v", err))
+		return
+	}
+
+	sink := &testAuditSink{}
+	auditSingleton = sink
+	apiReq := newDelete()
+	result := auditApiRequest(r, "req1", testUser, apiReq, apiReq.HandleApiRequest(mgr, authz, nil, nil, nil))
+	if "ok" != result.Result || 2 != len(sink.records) ||
+		AuditPhaseDecision != sink.records[0].Phase || 0 != sink.records[0].StatusCode ||
+		AuditPhaseOutcome != sink.records[1].Phase || http.StatusOK != sink.records[1].StatusCode {
+		t.Error(fmt.Sprintf("expected decision and outcome records, got: %v, %v", result, sink.records))
+		return
+	}
+
+	// a delete that ran keeps its result if only its outcome record fails
+	mgr.writeBlob("ws-storage-testsuite/goTestUser/a", strings.NewReader("abc"), nil)
+	failing := &testAuditSink{}
+	auditSingleton = failing
+	apiReq = newDelete()
+	deleted := apiReq.HandleApiRequest(mgr, authz, nil, nil, nil)
+	failing.err = unavailablef("collector down")
+	if result := auditApiRequest(r, "req1", testUser, apiReq, deleted); result != deleted || "ok" != result.Result {
+		t.Error(fmt.Sprintf("expected the delete result after its outcome record failed, got: %v", result))
+		return
+	}
+
+	// reads have only an outcome record
+	sink.records = nil
+	testUrl, _ := url.Parse("https://whatever/ws-storage/stat/@user/a")
+	apiReq, _ = NewApiRequest(testUrl, http.MethodGet, testUser)
+	apiReq.recordDecision = func() error { return auditDecision(r, "req2", testUser, apiReq) }
+	apiReq.HandleApiRequest(mgr, authz, nil, nil, nil)
+	if 0 != len(sink.records) {
+		t.Error(fmt.Sprintf("expected no decision record for a read, got: %v", sink.records))
+	}
+}
+
+func TestClientIp(t *testing.T) {
+	testCases := []struct {
+		forwarded []string
+		expected  string
+	}{
+		{nil, "192.0.2.1"},
+		{[]string{"10.1.2.3"}, "10.1.2.3"},
+		// a client may send its own X-Forwarded-For - the gateway appends the real address
+		{[]string{"6.6.6.6, 10.1.2.3"}, "10.1.2.3"},
+		{[]string{"6.6.6.6", "10.1.2.3"}, "10.1.2.3"},
+	}
+	for _, it := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/ws-storage/list/@user", nil)
+		for _, value := range it.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if ip := clientIp(r); it.expected != ip {
+			t.Error(fmt.Sprintf("unexpected client ip for %v, got: %v", it.forwarded, ip))
+		}
+	}
+}
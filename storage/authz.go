@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Workspace actions that an Authorizer decides
+const (
+	ActionList   = "list"
+	ActionRead   = "read"
+	ActionWrite  = "write"
+	ActionDelete = "delete"
+)
+
+// Authorizer decides whether the user in a session
+// may perform an action on a workspace
+type Authorizer interface {
+	// Authorize returns true if the user may perform the action -
+	// an error indicates that a decision could not be made
+	Authorize(cx *SessionContext, workspaceIn string, action string) (bool, error)
+}
+
+// NewAuthorizer makes the authorizer selected by the given configuration
+func NewAuthorizer(config *Config) (Authorizer, error) {
+	switch config.Authorizer {
+	case "", "static":
+		return NewStaticAuthorizer(config.WorkspaceMembers), nil
+	case "arborist":
+		authz, err := NewArboristAuthorizer(config.Arborist)
+		if nil != err {
+			return nil, err
+		}
+		ttl := 60 * time.Second
+		if config.Arborist.CacheSeconds < 0 {
+			return authz, nil
+		} else if config.Arborist.CacheSeconds > 0 {
+			ttl = time.Duration(config.Arborist.CacheSeconds) * time.Second
+		}
+		return NewCachingAuthorizer(authz, ttl), nil
+	default:
+		return nil, fmt.Errorf("unsupported authorizer: %v", config.Authorizer)
+	}
+}
+
+// maxCachedDecisions triggers a sweep of expired decisions from the cache
+const maxCachedDecisions = 10000
+
+type authzDecision struct {
+	allowed bool
+	expires time.Time
+}
+
+// CachingAuthorizer caches the decisions of another
+// authorizer for a time - errors are not cached
+type CachingAuthorizer struct {
+	authz     Authorizer
+	ttl       time.Duration
+	lock      sync.Mutex
+	decisions map[string]*authzDecision
+}
+
+// NewCachingAuthorizer caches the decisions of authz for ttl
+func NewCachingAuthorizer(authz Authorizer, ttl time.Duration) *CachingAuthorizer {
+	return &CachingAuthorizer{
+		authz:     authz,
+		ttl:       ttl,
+		decisions: map[string]*authzDecision{},
+	}
+}
+
+// Authorize returns the cached decision for the user, workspace,
+// and action if any, otherwise asks the underlying authorizer
+func (self *CachingAuthorizer) Authorize(cx *SessionContext, workspaceIn string, action string) (bool, error) {
+	cacheKey := cx.User + "\n" + cx.Token + "\n" + workspaceIn + "\n" + action
+	now := time.Now()
+	self.lock.Lock()
+	decision, ok := self.decisions[cacheKey]
+	self.lock.Unlock()
+	if ok && now.Before(decision.expires) {
+		return decision.allowed, nil
+	}
+	allowed, err := self.authz.Authorize(cx, workspaceIn, action)
+	if nil != err {
+		return false, err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	// drop expired decisions, so the cache does not grow without bound
+	if len(self.decisions) >= maxCachedDecisions {
+		for key, it := range self.decisions {
+			if now.After(it.expires) {
+				delete(self.decisions, key)
+			}
+		}
+	}
+	self.decisions[cacheKey] = &authzDecision{allowed: allowed, expires: now.Add(self.ttl)}
+	return allowed, nil
+}
+
+// StaticAuthorizer gives each user full access to their
+// @user workspace, and decides access to named workspaces
+// from configured membership lists
+type StaticAuthorizer struct {
+	members map[string]*WorkspaceMembers
+}
+
+// NewStaticAuthorizer makes an authorizer with the given
+// members of each named workspace
+func NewStaticAuthorizer(members map[string]*WorkspaceMembers) *StaticAuthorizer {
+	if nil == members {
+		members = map[string]*WorkspaceMembers{}
+	}
+	return &StaticAuthorizer{members: members}
+}
+
+func containsUser(users []string, user string) bool {
+	for _, it := range users {
+		if it == user {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize readers to list and read, and
+// writers to do anything in a named workspace
+func (self *StaticAuthorizer) Authorize(cx *SessionContext, workspaceIn string, action string) (bool, error) {
+	if UserWorkspace == workspaceIn {
+		return true, nil
+	}
+	members, ok := self.members[workspaceIn]
+	if !ok {
+		return false, nil
+	}
+	if containsUser(members.Writers, cx.User) {
+		return true, nil
+	}
+	if ActionList == action || ActionRead == action {
+		return containsUser(members.Readers, cx.User), nil
+	}
+	return false, nil
+}
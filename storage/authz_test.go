@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestStaticAuthorizer(t *testing.T) {
+	authz := NewStaticAuthorizer(map[string]*WorkspaceMembers{
+		"@group/lab1": {Readers: []string{"reader"}, Writers: []string{"writer"}},
+	})
+	testCases := []struct {
+		user      string
+		workspace string
+		action    string
+		expected  bool
+	}{
+		{"stranger", "@user", ActionDelete, true},
+		{"writer", "@group/lab1", ActionWrite, true},
+		{"writer", "@group/lab1", ActionDelete, true},
+		{"writer", "@group/lab1", ActionRead, true},
+		{"reader", "@group/lab1", ActionList, true},
+		{"reader", "@group/lab1", ActionRead, true},
+		{"reader", "@group/lab1", ActionWrite, false},
+		{"reader", "@group/lab1", ActionDelete, false},
+		{"stranger", "@group/lab1", ActionList, false},
+		{"writer", "@group/lab2", ActionList, false},
+	}
+	for _, it := range testCases {
+		allowed, err := authz.Authorize(NewSessionContext(it.user), it.workspace, it.action)
+		if nil != err || allowed != it.expected {
+			t.Error(fmt.Sprintf("unexpected decision for %v %v %v, got: %v, %v", it.user, it.action, it.workspace, allowed, err))
+			return
+		}
+	}
+}
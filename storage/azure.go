@@ -0,0 +1,516 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// azureSasVersion is the storage service version that signs our SAS tokens
+const azureSasVersion = "2020-02-10"
+
+// azureCopyTimeout bounds how long Copy waits for
+// an asynchronous blob copy to finish
+const azureCopyTimeout = 10 * time.Minute
+
+// AzureManager is a Manager backed by an Azure Blob Storage container -
+// the Config bucket is the container name.
+// Every request - including list and delete - is authorized
+// with a service SAS token signed with the storage account key.
+//   https://docs.microsoft.com/en-us/rest/api/storageservices/create-service-sas
+// Clients must set the x-ms-blob-type: BlockBlob header on upload.
+type AzureManager struct {
+	config     *Config
+	endpoint   string
+	account    string
+	key        []byte
+	httpClient *http.Client
+}
+
+// azureListResult is the list blobs response
+type azureListResult struct {
+	NextMarker string `xml:"NextMarker"`
+	Blobs      struct {
+		Blob []struct {
+			Name       string `xml:"Name"`
+			Properties struct {
+				LastModified  string `xml:"Last-Modified"`
+				ContentLength int64  `xml:"Content-Length"`
+			} `xml:"Properties"`
+		} `xml:"Blob"`
+		BlobPrefix []struct {
+			Name string `xml:"Name"`
+		} `xml:"BlobPrefix"`
+	} `xml:"Blobs"`
+}
+
+// NewAzureManager makes a new Azure blob manager with the given configuration.
+// The account key comes from the azurekey config, or the
+// AZURE_STORAGE_KEY environment variable.
+func NewAzureManager(config *Config) (*AzureManager, error) {
+	if "" == config.AzureAccount {
+		return nil, fmt.Errorf("azure backend requires azureaccount in config")
+	}
+	// a SAS url cannot bind an upload to its size or checksum,
+	// so the requirement would not be enforced
+	if nil != config.Urls && (config.Urls.RequireSize || config.Urls.RequireChecksum) {
+		return nil, fmt.Errorf("azure backend does not support urls requiresize or requirechecksum")
+	}
+	keyStr := config.AzureKey
+	if "" == keyStr {
+		keyStr = os.Getenv("AZURE_STORAGE_KEY")
+	}
+	if "" == keyStr {
+		return nil, fmt.Errorf("azure backend requires azurekey in config or AZURE_STORAGE_KEY")
+	}
+	key, err := base64.StdEncoding.DecodeString(keyStr)
+	if nil != err {
+		return nil, fmt.Errorf("failed to decode azure account key - %v", err)
+	}
+	endpoint := config.Endpoint
+	if "" == endpoint {
+		endpoint = "https://" + config.AzureAccount + ".blob.core.windows.net"
+	}
+	return &AzureManager{
+		config:     config,
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		account:    config.AzureAccount,
+		key:        key,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// azureStringToSign builds the service SAS string to sign
+// for the given permissions on the given canonical resource
+func azureStringToSign(permissions string, expiry string, canonicalResource string, resourceType string, query url.Values) string {
+	return strings.Join([]string{
+		permissions,
+		"", // start
+		expiry,
+		canonicalResource,
+		"", // identifier
+		"", // ip
+		"", // protocol
+		azureSasVersion,
+		resourceType,
+		"", // snapshot time
+		// response header overrides
+		query.Get("rscc"), // cache-control
+		query.Get("rscd"), // content-disposition
+		query.Get("rsce"), // content-encoding
+		query.Get("rscl"), // content-language
+		query.Get("rsct"), // content-type
+	}, "\n")
+}
+
+// signUrl generates a SAS url with the given permissions on the
+// given blob path, or on the container if the path is empty
+func (self *AzureManager) signUrl(permissions string, s3path string, extraQuery url.Values, ttl time.Duration) string {
+	expiry := time.Now().UTC().Add(ttl).Format("2006-01-02T15:04:05Z")
+	canonicalResource := "/blob/" + self.account + "/" + self.config.Bucket
+	resourceUrl := self.endpoint + "/" + uriEscape(self.config.Bucket, false)
+	resourceType := "c"
+	if "" != s3path {
+		canonicalResource += "/" + s3path
+		resourceUrl += "/" + uriEscape(s3path, true)
+		resourceType = "b"
+	}
+	query := url.Values{}
+	for key, values := range extraQuery {
+		query[key] = values
+	}
+	mac := hmac.New(sha256.New, self.key)
+	mac.Write([]byte(azureStringToSign(permissions, expiry, canonicalResource, resourceType, query)))
+
+	query.Set("sv", azureSasVersion)
+	query.Set("sr", resourceType)
+	query.Set("sp", permissions)
+	query.Set("se", expiry)
+	query.Set("sig", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return resourceUrl + "?" + query.Encode()
+}
+
+// listPage fetches a page of the list blobs response under
+// the given path - delimiter may be empty to list recursively
+func (self *AzureManager) listPage(s3path string, delimiter string, page string, limit int) (*azureListResult, error) {
+	query := url.Values{}
+	query.Set("restype", "container")
+	query.Set("comp", "list")
+	query.Set("prefix", s3path)
+	if "" != delimiter {
+		query.Set("delimiter", delimiter)
+	}
+	query.Set("maxresults", strconv.Itoa(limit))
+	if page != "" {
+		query.Set("marker", page)
+	}
+	resp, err := self.httpClient.Get(self.signUrl("l", "", query, 5*time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusErrorf(resp.StatusCode, "azure list failed with status %v - %v", resp.StatusCode, string(body))
+	}
+	listing := &azureListResult{}
+	if err := xml.Unmarshal(body, listing); err != nil {
+		return nil, fmt.Errorf("failed to parse azure list response - %v", err)
+	}
+	return listing, nil
+}
+
+// List the prefixes and objects under a given workspace and prefix
+func (self *AzureManager) List(cx *SessionContext, workspaceIn string, prefix string, page string, limit int) (*ListResult, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 0 || limit > MaxListLimit {
+		return nil, invalidInputf("invalid limit - must be between 0 and %v, got %v", MaxListLimit, limit)
+	}
+	if limit == 0 {
+		limit = MaxListLimit
+	}
+	s3path, err := workspace.Path(prefix)
+	if err != nil {
+		return nil, err
+	}
+	s3prefix, err := workspace.Path("")
+	if err != nil {
+		return nil, err
+	}
+	listing, err := self.listPage(s3path, "/", page, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ListResult{
+		Workspace: workspace.Name,
+		Prefix:    prefix,
+		Objects:   make([]ObjectInfo, len(listing.Blobs.Blob)),
+		Prefixes:  make([]string, len(listing.Blobs.BlobPrefix)),
+		NextPage:  listing.NextMarker,
+	}
+	for ix, item := range listing.Blobs.Blob {
+		lastModified, _ := time.Parse(time.RFC1123, item.Properties.LastModified)
+		result.Objects[ix] = ObjectInfo{
+			Workspace:    workspace.Name,
+			WorkspaceKey: strings.Replace(item.Name, s3prefix, "", 1),
+			SizeBytes:    item.Properties.ContentLength,
+			LastModified: lastModified.UTC(),
+		}
+	}
+	for ix, item := range listing.Blobs.BlobPrefix {
+		result.Prefixes[ix] = strings.Replace(item.Name, s3prefix, "", 1)
+	}
+	return result, nil
+}
+
+// UploadUrl generates a SAS upload url -
+// the client must set the x-ms-blob-type: BlockBlob header,
+// and should set x-ms-blob-content-type.
+// A SAS cannot constrain the content length, type, or md5, so the
+// declared size is only checked against quotas, and a declared
+// md5 is only checked by Azure if the client sends Content-MD5 -
+// Azure then records it as the blob's Content-MD5.
+// Azure does not verify sha256 or crc32c checksums.
+func (self *AzureManager) UploadUrl(cx *SessionContext, workspaceIn string, key string, options UploadOptions) (string, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return "", err
+	}
+	if err := validateUploadOptions(self.config, options); err != nil {
+		return "", err
+	}
+	if nil != options.Checksum && "md5" != options.Checksum.Algorithm {
+		return "", invalidInputf("invalid checksumAlgorithm - the azure backend only supports md5 checksums")
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return "", err
+	}
+	log.Debug().Str("Func", "UploadUrl").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Send()
+	return self.signUrl("cw", s3path, nil, urlTtl(self.config, options.Expires)), nil
+}
+
+// uploadHeaders are the headers an upload with a SAS upload url
+// sends - a proxied upload sends a declared md5, so Azure verifies it
+func (self *AzureManager) uploadHeaders(key string, options UploadOptions) http.Header {
+	header := http.Header{}
+	header.Set("x-ms-blob-type", "BlockBlob")
+	header.Set("x-ms-blob-content-type", options.ContentTypeOrDefault(key))
+	if nil != options.Checksum && "md5" == options.Checksum.Algorithm {
+		header.Set("Content-MD5", options.Checksum.Value)
+	}
+	return header
+}
+
+// DownloadUrl generates a SAS download url -
+// supports the range HTTP header
+func (self *AzureManager) DownloadUrl(cx *SessionContext, workspaceIn string, key string, options DownloadOptions) (string, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return "", err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return "", err
+	}
+	log.Debug().Str("Func", "DownloadUrl").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Send()
+	var query url.Values
+	if "" != options.ContentDisposition {
+		query = url.Values{"rscd": []string{options.ContentDisposition}}
+	}
+	return self.signUrl("r", s3path, query, urlTtl(self.config, options.Expires)), nil
+}
+
+// DeleteObject removes the given blob - deleting
+// a blob that does not exist is not an error
+func (self *AzureManager) DeleteObject(cx *SessionContext, workspaceIn string, key string) error {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return err
+	}
+	log.Debug().Str("Func", "DeleteObject").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Send()
+	return self.deletePath(s3path)
+}
+
+// deletePath deletes the blob at the given path -
+// deleting a blob that does not exist is not an error
+func (self *AzureManager) deletePath(s3path string) error {
+	req, err := http.NewRequest(http.MethodDelete, self.signUrl("d", s3path, nil, 5*time.Minute), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := self.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return statusErrorf(resp.StatusCode, "azure delete failed with status %v - %v", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// Stat returns the metadata of the given blob from
+// a get blob properties (HEAD) request -
+// the storage class is the blob's access tier
+func (self *AzureManager) Stat(cx *SessionContext, workspaceIn string, key string) (*ObjectStat, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := self.httpClient.Head(self.signUrl("r", s3path, nil, 5*time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w - %v", ErrNotFound, key)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusErrorf(resp.StatusCode, "azure stat failed with status %v", resp.StatusCode)
+	}
+	result := headerObjectStat(workspace.Name, key, resp.Header, "x-ms-meta-", "x-ms-access-tier")
+	if contentMD5 := resp.Header.Get("Content-MD5"); nil == result.Checksum && "" != contentMD5 {
+		result.Checksum = &Checksum{Algorithm: "md5", Value: contentMD5}
+	}
+	return result, nil
+}
+
+// Copy copies the source blob to the destination key with
+// a copy blob request authorized by a read SAS on the source,
+// and waits for the copy to finish - so Move does not delete
+// the source of a pending copy
+func (self *AzureManager) Copy(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	return self.copyBlob(cx, workspaceIn, srcKey, dstKey, false)
+}
+
+// moveExclusive moves the blob if the destination does not exist -
+// the copy is conditional on If-None-Match: *
+func (self *AzureManager) moveExclusive(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	if err := self.copyBlob(cx, workspaceIn, srcKey, dstKey, true); nil != err {
+		return err
+	}
+	return self.DeleteObject(cx, workspaceIn, srcKey)
+}
+
+func (self *AzureManager) copyBlob(cx *SessionContext, workspaceIn string, srcKey string, dstKey string, exclusive bool) error {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
+	}
+	if err := validateCopyKeys(srcKey, dstKey); nil != err {
+		return err
+	}
+	srcPath, err := workspace.Path(srcKey)
+	if err != nil {
+		return err
+	}
+	dstPath, err := workspace.Path(dstKey)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, self.signUrl("cw", dstPath, nil, 5*time.Minute), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-ms-copy-source", self.signUrl("r", srcPath, nil, azureCopyTimeout))
+	if exclusive {
+		req.Header.Set("If-None-Match", "*")
+	}
+	resp, err := self.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w - %v", ErrNotFound, srcKey)
+	}
+	if (resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusPreconditionFailed) && exclusive {
+		return fmt.Errorf("%w - %v exists", ErrConflict, dstKey)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return statusErrorf(resp.StatusCode, "azure copy failed with status %v - %v", resp.StatusCode, string(body))
+	}
+	status := resp.Header.Get("x-ms-copy-status")
+	for deadline := time.Now().Add(azureCopyTimeout); "pending" == status && time.Now().Before(deadline); {
+		time.Sleep(time.Second)
+		resp, err := self.httpClient.Head(self.signUrl("r", dstPath, nil, 5*time.Minute))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		status = resp.Header.Get("x-ms-copy-status")
+	}
+	if "success" != status {
+		return fmt.Errorf("azure copy of %v did not succeed - status %v", srcKey, status)
+	}
+	log.Debug().Str("Func", "Copy").
+		Str("Workspace", workspace.Name).
+		Str("Key", srcKey).
+		Str("Destination", dstKey).
+		Send()
+	return nil
+}
+
+// Move copies the source blob to the destination key,
+// then deletes the source
+func (self *AzureManager) Move(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	return moveObject(self, cx, workspaceIn, srcKey, dstKey)
+}
+
+// Usage sums the size and count of the blobs in the workspace
+func (self *AzureManager) Usage(cx *SessionContext, workspaceIn string) (*WorkspaceUsage, error) {
+	return workspaceUsage(self, self.config, cx, workspaceIn)
+}
+
+// DeletePrefix deletes every blob under the given folder prefix one by one
+func (self *AzureManager) DeletePrefix(cx *SessionContext, workspaceIn string, prefix string) (*DeletePrefixResult, error) {
+	return deletePrefix(self, self.config, cx, workspaceIn, prefix)
+}
+
+func (self *AzureManager) listTree(s3path string, page string) ([]storedObject, string, error) {
+	listing, err := self.listPage(s3path, "", page, MaxListLimit)
+	if err != nil {
+		return nil, "", err
+	}
+	result := make([]storedObject, len(listing.Blobs.Blob))
+	for ix, item := range listing.Blobs.Blob {
+		lastModified, _ := time.Parse(time.RFC1123, item.Properties.LastModified)
+		result[ix] = storedObject{Path: item.Name, SizeBytes: item.Properties.ContentLength, LastModified: lastModified.UTC()}
+	}
+	return result, listing.NextMarker, nil
+}
+
+func (self *AzureManager) listFolders(s3path string, page string) ([]string, string, error) {
+	listing, err := self.listPage(s3path, "/", page, MaxListLimit)
+	if err != nil {
+		return nil, "", err
+	}
+	result := make([]string, len(listing.Blobs.BlobPrefix))
+	for ix, item := range listing.Blobs.BlobPrefix {
+		result[ix] = item.Name
+	}
+	return result, listing.NextMarker, nil
+}
+
+func (self *AzureManager) putObject(s3path string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, self.signUrl("cw", s3path, nil, 5*time.Minute), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.Header.Set("x-ms-blob-content-type", contentTypeByKey(s3path))
+	resp, err := self.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return statusErrorf(resp.StatusCode, "azure upload failed with status %v - %v", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func (self *AzureManager) getObject(s3path string) ([]byte, error) {
+	resp, err := self.httpClient.Get(self.signUrl("r", s3path, nil, 5*time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w - %v", ErrNotFound, s3path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusErrorf(resp.StatusCode, "azure download failed with status %v", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (self *AzureManager) deletePaths(s3paths []string) (map[string]error, error) {
+	failures := map[string]error{}
+	for _, path := range s3paths {
+		if err := self.deletePath(path); nil != err {
+			failures[path] = err
+		}
+	}
+	return failures, nil
+}
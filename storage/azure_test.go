@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAzure is a minimal blob service that verifies service SAS tokens
+type fakeAzure struct {
+	account   string
+	container string
+	key       []byte
+	objects   map[string][]byte
+}
+
+func (self *fakeAzure) verify(r *http.Request, resource string) error {
+	query := r.URL.Query()
+	mac := hmac.New(sha256.New, self.key)
+	mac.Write([]byte(azureStringToSign(query.Get("sp"), query.Get("se"), "/blob/"+self.account+resource, query.Get("sr"), query)))
+	if query.Get("sig") != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+func (self *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	containerPath := "/" + self.account + "/" + self.container
+	if err := self.verify(r, strings.TrimPrefix(r.URL.Path, "/"+self.account)); nil != err {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if r.URL.Path == containerPath && query.Get("comp") == "list" {
+		if query.Get("sp") != "l" {
+			http.Error(w, "permission denied", http.StatusForbidden)
+			return
+		}
+		keys := []string{}
+		for key := range self.objects {
+			keys = append(keys, key)
+		}
+		objects, prefixes, _ := listEntries(keys, query.Get("prefix"), "", MaxListLimit)
+		if "" == query.Get("delimiter") {
+			objects, prefixes = []string{}, nil
+			for _, key := range keys {
+				if strings.HasPrefix(key, query.Get("prefix")) {
+					objects = append(objects, key)
+				}
+			}
+		}
+		body := "<EnumerationResults><Blobs>"
+		for _, key := range objects {
+			body += fmt.Sprintf("<Blob><Name>%v</Name><Properties><Last-Modified>%v</Last-Modified><Content-Length>%v</Content-Length></Properties></Blob>",
+				key, time.Now().UTC().Format(time.RFC1123), len(self.objects[key]))
+		}
+		for _, prefix := range prefixes {
+			body += fmt.Sprintf("<BlobPrefix><Name>%v</Name></BlobPrefix>", prefix)
+		}
+		w.Write([]byte(body + "</Blobs><NextMarker /></EnumerationResults>"))
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, containerPath+"/")
+	switch r.Method {
+	case http.MethodPut:
+		if source := r.Header.Get("x-ms-copy-source"); "" != source {
+			sourceUrl, err := url.Parse(source)
+			if nil != err || nil != self.verify(&http.Request{URL: sourceUrl}, strings.TrimPrefix(sourceUrl.Path, "/"+self.account)) {
+				http.Error(w, "invalid copy source", http.StatusForbidden)
+				return
+			}
+			data, ok := self.objects[strings.TrimPrefix(sourceUrl.Path, containerPath+"/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			self.objects[key] = data
+			w.Header().Set("x-ms-copy-status", "success")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if r.Header.Get("x-ms-blob-type") != "BlockBlob" || !strings.Contains(query.Get("sp"), "w") {
+			http.Error(w, "invalid upload", http.StatusBadRequest)
+			return
+		}
+		self.objects[key], _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		data, ok := self.objects[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprintf("%v", len(data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"fakeETag"`)
+		w.Header().Set("x-ms-access-tier", "Cool")
+		w.Header().Set("x-ms-meta-owner", testUser)
+		if disposition := query.Get("rscd"); "" != disposition {
+			w.Header().Set("Content-Disposition", disposition)
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(self.objects, key)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func TestAzureMgr(t *testing.T) {
+	key := []byte("frickjack-azure-account-key")
+	fake := &fakeAzure{account: "devstoreaccount1", container: "ws-storage-test", key: key, objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	mgr, err := NewAzureManager(&Config{
+		Backend:      BackendAzure,
+		Bucket:       "ws-storage-test",
+		BucketPrefix: "ws-storage-testsuite",
+		Endpoint:     server.URL + "/devstoreaccount1",
+		AzureAccount: "devstoreaccount1",
+		AzureKey:     base64.StdEncoding.EncodeToString(key),
+	})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize azure manager, got: %v", err))
+		return
+	}
+	for _, urls := range []*UrlConfig{{RequireSize: true}, {RequireChecksum: true}} {
+		if _, err := NewAzureManager(&Config{Backend: BackendAzure, AzureAccount: "devstoreaccount1", AzureKey: "a2V5", Urls: urls}); nil == err {
+			t.Error(fmt.Sprintf("azure manager should reject url requirements it cannot enforce: %v", urls))
+			return
+		}
+	}
+	cx := NewSessionContext(testUser)
+	objKey := testFolder + "/testObject.txt"
+	testMessage := "this is a test"
+
+	uploadUrl, err := mgr.UploadUrl(cx, "@user", objKey, UploadOptions{})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to generate upload url, got: %v", err))
+		return
+	}
+	req, _ := http.NewRequest(http.MethodPut, uploadUrl, bytes.NewBufferString(testMessage))
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	resp, err := getTestHttpClient().Do(req)
+	if nil != err || resp.StatusCode != http.StatusCreated {
+		t.Error(fmt.Sprintf("failed to upload test content, got: %v, %v", resp, err))
+		return
+	}
+	resp.Body.Close()
+
+	disposition := `attachment; filename="x.txt"`
+	downloadUrl, err := mgr.DownloadUrl(cx, "@user", objKey, DownloadOptions{ContentDisposition: disposition})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to generate download url, got: %v", err))
+		return
+	}
+	// the response overrides are signed
+	if resp, err := getTestHttpClient().Get(strings.Replace(downloadUrl, "x.txt", "y.txt", 1)); nil != err || resp.StatusCode != http.StatusForbidden {
+		t.Error(fmt.Sprintf("download with a modified disposition should fail, got: %v, %v", resp, err))
+		return
+	}
+	resp, err = getTestHttpClient().Get(downloadUrl)
+	if nil != err || resp.StatusCode != 200 || resp.Header.Get("Content-Disposition") != disposition {
+		t.Error(fmt.Sprintf("failed to download test content, got: %v, %v", resp, err))
+		return
+	}
+	bodyBytes, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(bodyBytes) != testMessage {
+		t.Error(fmt.Sprintf("download does not match upload: %v ?= %v", string(bodyBytes), testMessage))
+		return
+	}
+
+	info, err := mgr.List(cx, "@user", "", "", 0)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to list workspace, got: %v", err))
+		return
+	}
+	if len(info.Prefixes) != 1 || info.Prefixes[0] != testFolder+"/" || "" != info.NextPage {
+		t.Error(fmt.Sprintf("unexpected workspace listing, got: %v", info))
+		return
+	}
+	info, err = mgr.List(cx, "@user", testFolder+"/", "", 0)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to list folder, got: %v", err))
+		return
+	}
+	if len(info.Objects) != 1 || info.Objects[0].WorkspaceKey != objKey ||
+		info.Objects[0].SizeBytes != int64(len(testMessage)) || info.Objects[0].LastModified.IsZero() {
+		t.Error(fmt.Sprintf("unexpected folder listing, got: %v", info))
+		return
+	}
+
+	if err := mgr.Copy(cx, "@user", objKey, objKey+".copy"); nil != err || string(fake.objects["ws-storage-testsuite/goTestUser/"+objKey+".copy"]) != testMessage {
+		t.Error(fmt.Sprintf("failed to copy test object, got: %v", err))
+		return
+	}
+	if err := mgr.Move(cx, "@user", objKey+".copy", objKey+".moved"); nil != err || len(fake.objects) != 2 {
+		t.Error(fmt.Sprintf("failed to move test object, got: %v, %v", err, fake.objects))
+		return
+	}
+	if err := mgr.Copy(cx, "@user", objKey+".copy", objKey+".again"); !errors.Is(err, ErrNotFound) {
+		t.Error(fmt.Sprintf("expected not found copying a moved object, got: %v", err))
+		return
+	}
+	mgr.DeleteObject(cx, "@user", objKey+".moved")
+
+	stat, err := mgr.Stat(cx, "@user", objKey)
+	if nil != err || stat.SizeBytes != int64(len(testMessage)) || stat.ETag != "fakeETag" ||
+		stat.StorageClass != "Cool" || stat.Metadata["owner"] != testUser || stat.LastModified.IsZero() {
+		t.Error(fmt.Sprintf("unexpected stat, got: %v, %v", stat, err))
+		return
+	}
+
+	if err := mgr.DeleteObject(cx, "@user", objKey); nil != err {
+		t.Error(fmt.Sprintf("failed to delete test object, got: %v", err))
+		return
+	}
+	if len(fake.objects) != 0 {
+		t.Error(fmt.Sprintf("object not deleted, got: %v", fake.objects))
+		return
+	}
+	if _, err := mgr.Stat(cx, "@user", objKey); !errors.Is(err, ErrNotFound) {
+		t.Error(fmt.Sprintf("expected not found for deleted object, got: %v", err))
+		return
+	}
+
+	for _, key := range []string{"a", "sub/b", "sub/deeper/c"} {
+		fake.objects["ws-storage-testsuite/goTestUser/"+testFolder+"/"+key] = []byte(testMessage)
+	}
+	fake.objects["ws-storage-testsuite/goTestUser/"+testFolder+"Sibling"] = []byte(testMessage)
+	if result, err := mgr.DeletePrefix(cx, "@user", testFolder+"/"); nil != err || result.DeletedCount != 3 || len(fake.objects) != 1 {
+		t.Error(fmt.Sprintf("unexpected prefix delete, got: %v, %v", result, err))
+		return
+	}
+
+	recordPath := "ws-storage-testsuite/goTestUser/" + testFolder + "/record.json"
+	if err := mgr.putObject(recordPath, []byte(testMessage)); nil != err || string(fake.objects[recordPath]) != testMessage {
+		t.Error(fmt.Sprintf("failed to put object, got: %v", err))
+		return
+	}
+	if data, err := mgr.getObject(recordPath); nil != err || string(data) != testMessage {
+		t.Error(fmt.Sprintf("failed to get object, got: %v, %v", string(data), err))
+		return
+	}
+	if _, err := mgr.getObject(recordPath + ".missing"); !errors.Is(err, ErrNotFound) {
+		t.Error(fmt.Sprintf("expected not found getting a missing object, got: %v", err))
+	}
+}
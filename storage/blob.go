@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// BlobPathPrefix is the url path under which ws-storage itself
+// serves object data for backends that do not have their own
+// object server (memory, filesystem)
+const BlobPathPrefix = "/ws-storage/blob/"
+
+// blobSigner generates and verifies time-limited HMAC signed
+// urls for objects served under BlobPathPrefix
+type blobSigner struct {
+	secret  []byte
+	baseUrl string
+}
+
+// newBlobSigner signs urls with the given secret, and
+// generates urls relative to baseUrl - the public url
+// of the ws-storage service.
+// A random secret is generated if secret is empty.
+func newBlobSigner(secret []byte, baseUrl string) (*blobSigner, error) {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); nil != err {
+			return nil, fmt.Errorf("failed to generate blob signing secret - %v", err)
+		}
+	}
+	if "" == baseUrl {
+		baseUrl = "http://localhost:8000"
+	}
+	return &blobSigner{
+		secret:  secret,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+	}, nil
+}
+
+func (self *blobSigner) signature(method string, s3path string, expires string, constraints url.Values) string {
+	mac := hmac.New(sha256.New, self.secret)
+	mac.Write([]byte(method + "\n" + s3path + "\n" + expires + "\n" + constraints.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignUrl returns a url that authorizes the given http method
+// on the given object path until ttl expires.
+// constraints are signed query parameters that serveBlob
+// enforces - ex: size, the required upload content length
+func (self *blobSigner) SignUrl(method string, s3path string, ttl time.Duration, constraints url.Values) string {
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	query := url.Values{}
+	for key, values := range constraints {
+		query[key] = values
+	}
+	query.Set("method", method)
+	query.Set("expires", expires)
+	query.Set("signature", self.signature(method, s3path, expires, constraints))
+	path := &url.URL{Path: BlobPathPrefix + s3path}
+	return self.baseUrl + path.EscapedPath() + "?" + query.Encode()
+}
+
+// Verify checks the signature and expiration on a request
+// for a url generated by SignUrl, and returns the object path
+// and signed constraints
+func (self *blobSigner) Verify(r *http.Request) (string, url.Values, error) {
+	if !strings.HasPrefix(r.URL.Path, BlobPathPrefix) {
+		return "", nil, fmt.Errorf("invalid blob path: %v", r.URL.Path)
+	}
+	s3path := strings.TrimPrefix(r.URL.Path, BlobPathPrefix)
+	query := r.URL.Query()
+	method := query.Get("method")
+	expires := query.Get("expires")
+	signature := query.Get("signature")
+	if method != r.Method {
+		return "", nil, fmt.Errorf("signed method %v does not match request method %v", method, r.Method)
+	}
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if nil != err {
+		return "", nil, fmt.Errorf("invalid expires: %v", expires)
+	}
+	if time.Now().Unix() > expiresUnix {
+		return "", nil, fmt.Errorf("signed url expired")
+	}
+	constraints := query
+	constraints.Del("method")
+	constraints.Del("expires")
+	constraints.Del("signature")
+	expected := self.signature(method, s3path, expires, constraints)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return "", nil, fmt.Errorf("invalid signature")
+	}
+	return s3path, constraints, nil
+}
+
+// sizeConstraint returns the size constraint for an upload
+// of the given declared size - none if the size is unknown
+func sizeConstraint(sizeBytes int64) url.Values {
+	if sizeBytes <= 0 {
+		return nil
+	}
+	return url.Values{"size": []string{strconv.FormatInt(sizeBytes, 10)}}
+}
+
+// uploadConstraints returns the constraints for an upload of
+// the given key - its content type, and size and checksum if declared -
+// the checksum constraint is named by its algorithm
+func uploadConstraints(key string, options UploadOptions) url.Values {
+	constraints := sizeConstraint(options.SizeBytes)
+	if nil == constraints {
+		constraints = url.Values{}
+	}
+	constraints.Set("type", options.ContentTypeOrDefault(key))
+	if nil != options.Checksum {
+		constraints.Set(options.Checksum.Algorithm, options.Checksum.Value)
+	}
+	return constraints
+}
+
+// downloadConstraints returns the constraints for a download -
+// the response content disposition if set
+func downloadConstraints(options DownloadOptions) url.Values {
+	if "" == options.ContentDisposition {
+		return nil
+	}
+	return url.Values{"disposition": []string{options.ContentDisposition}}
+}
+
+// blobContentTypeKey is the stored metadata entry that
+// holds a blob's content type - it is not user metadata
+const blobContentTypeKey = "content-type"
+
+// splitBlobMetadata splits the stored metadata of the blob
+// with the given key into its content type - by the key's
+// extension if the upload sent none - and its user metadata
+func splitBlobMetadata(key string, stored map[string]string) (string, map[string]string) {
+	contentType := contentTypeByKey(key)
+	metadata := map[string]string{}
+	for name, value := range stored {
+		if blobContentTypeKey == name {
+			contentType = value
+		} else {
+			metadata[name] = value
+		}
+	}
+	return contentType, metadata
+}
+
+// readBlob reads the whole blob at the given path -
+// fails with ErrNotFound if it does not exist
+func readBlob(store blobStore, s3path string) ([]byte, error) {
+	content, _, _, err := store.openBlob(s3path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w - %v", ErrNotFound, s3path)
+	}
+	if nil != err {
+		return nil, err
+	}
+	defer content.Close()
+	return io.ReadAll(content)
+}
+
+// blobStore is implemented by backends whose object data
+// is served by ws-storage itself under BlobPathPrefix
+type blobStore interface {
+	// openBlob opens the object at the given path for reading
+	// with its stored metadata - returns an error satisfying
+	// errors.Is(err, os.ErrNotExist) if the object does not exist
+	openBlob(s3path string) (io.ReadSeekCloser, time.Time, map[string]string, error)
+	// writeBlob creates or replaces the object at the given path
+	// with the given stored metadata
+	writeBlob(s3path string, body io.Reader, metadata map[string]string) error
+}
+
+// serveBlob verifies a signed url request, and serves it from the given store
+func serveBlob(w http.ResponseWriter, r *http.Request, signer *blobSigner, store blobStore) {
+	s3path, constraints, err := signer.Verify(r)
+	if nil != err {
+		log.Debug().Str("Func", "serveBlob").Msgf("rejected blob request - %v", err)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		content, modTime, stored, err := store.openBlob(s3path)
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		if nil != err {
+			log.Error().Str("Func", "serveBlob").Msgf("failed to open %v - %v", s3path, err)
+			http.Error(w, "failed to open object", http.StatusInternalServerError)
+			return
+		}
+		defer content.Close()
+		contentType, _ := splitBlobMetadata(s3path, stored)
+		w.Header().Set("Content-Type", contentType)
+		if disposition := constraints.Get("disposition"); "" != disposition {
+			w.Header().Set("Content-Disposition", disposition)
+		}
+		http.ServeContent(w, r, "", modTime, content)
+	case http.MethodPut:
+		body := io.Reader(r.Body)
+		if contentType, ok := constraints["type"]; ok && contentType[0] != r.Header.Get("Content-Type") {
+			http.Error(w, "content type does not match the signed type", http.StatusBadRequest)
+			return
+		}
+		if sizeStr := constraints.Get("size"); "" != sizeStr {
+			if sizeStr != strconv.FormatInt(r.ContentLength, 10) {
+				http.Error(w, "content length does not match the signed size", http.StatusBadRequest)
+				return
+			}
+			body = io.LimitReader(r.Body, r.ContentLength)
+		}
+		var checksum *Checksum
+		for _, algorithm := range checksumAlgorithms {
+			if value := constraints.Get(algorithm); "" != value {
+				checksum = &Checksum{Algorithm: algorithm, Value: value}
+				// the store discards the data if the digest does not match
+				body = newChecksumReader(body, checksum)
+			}
+		}
+		metadata := map[string]string{}
+		for name, value := range checksum.metadata() {
+			metadata[name] = value
+		}
+		if contentType := r.Header.Get("Content-Type"); "" != contentType {
+			metadata[blobContentTypeKey] = contentType
+		}
+		if err := store.writeBlob(s3path, body, metadata); errors.Is(err, errChecksumMismatch) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if nil != err {
+			log.Error().Str("Func", "serveBlob").Msgf("failed to write %v - %v", s3path, err)
+			http.Error(w, "failed to write object", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlobSigner(t *testing.T) {
+	signer, err := newBlobSigner([]byte("frickjack"), "https://whatever/")
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to create signer, got: %v", err))
+		return
+	}
+	s3path := "prefix/goTestUser/some folder/x"
+	signedUrl := signer.SignUrl(http.MethodPut, s3path, time.Minute, nil)
+	if !strings.HasPrefix(signedUrl, "https://whatever" + BlobPathPrefix) {
+		t.Error(fmt.Sprintf("unexpected signed url: %v", signedUrl))
+		return
+	}
+	req := httptest.NewRequest(http.MethodPut, signedUrl, nil)
+	path, _, err := signer.Verify(req)
+	if nil != err || path != s3path {
+		t.Error(fmt.Sprintf("signed url failed verification, got: %v, %v", path, err))
+		return
+	}
+	sizedUrl := signer.SignUrl(http.MethodPut, s3path, time.Minute, sizeConstraint(3))
+	_, constraints, err := signer.Verify(httptest.NewRequest(http.MethodPut, sizedUrl, nil))
+	if nil != err || "3" != constraints.Get("size") {
+		t.Error(fmt.Sprintf("sized url failed verification, got: %v, %v", constraints, err))
+		return
+	}
+
+	invalidRequests := []*http.Request{
+		httptest.NewRequest(http.MethodGet, signedUrl, nil),
+		httptest.NewRequest(http.MethodPut, strings.Replace(signedUrl, "/x?", "/y?", 1), nil),
+		httptest.NewRequest(http.MethodPut, signer.SignUrl(http.MethodPut, s3path, -time.Minute, nil), nil),
+		httptest.NewRequest(http.MethodPut, strings.Replace(signedUrl, "signature=", "signature=0", 1), nil),
+		httptest.NewRequest(http.MethodPut, strings.Replace(sizedUrl, "size=3", "size=30", 1), nil),
+		httptest.NewRequest(http.MethodPut, strings.Replace(sizedUrl, "size=3", "", 1), nil),
+	}
+	for ix, it := range invalidRequests {
+		if _, _, err := signer.Verify(it); nil == err {
+			t.Error(fmt.Sprintf("request %v should have failed verification: %v", ix, it.URL))
+			return
+		}
+	}
+}
+
+func TestServeBlobContentHeaders(t *testing.T) {
+	mgr, err := NewMemoryManager(&Config{BucketPrefix: "ws-storage-testsuite"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	cx := NewSessionContext(testUser)
+	uploadUrl, _ := mgr.UploadUrl(cx, "@user", "data.csv", UploadOptions{ContentType: "text/csv"})
+	testCases := []struct {
+		contentType string
+		expected    int
+	}{
+		{"", http.StatusBadRequest},
+		{"text/plain", http.StatusBadRequest},
+		{"text/csv", http.StatusOK},
+	}
+	for _, it := range testCases {
+		req := httptest.NewRequest(http.MethodPut, uploadUrl, strings.NewReader("a,b,c"))
+		if "" != it.contentType {
+			req.Header.Set("Content-Type", it.contentType)
+		}
+		rec := httptest.NewRecorder()
+		mgr.ServeHTTP(rec, req)
+		if rec.Code != it.expected {
+			t.Error(fmt.Sprintf("unexpected status for content type %v, got: %v", it.contentType, rec.Code))
+			return
+		}
+	}
+
+	// without a declared type the upload must send the
+	// type of the key's extension, and the object keeps it
+	defaultUrl, _ := mgr.UploadUrl(cx, "@user", "data.json", UploadOptions{})
+	req := httptest.NewRequest(http.MethodPut, defaultUrl, strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "text/plain")
+	rec := httptest.NewRecorder()
+	mgr.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Error(fmt.Sprintf("expected an upload with another type to be rejected, got: %v", rec.Code))
+		return
+	}
+	req = httptest.NewRequest(http.MethodPut, defaultUrl, strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	mgr.ServeHTTP(rec, req)
+	if stat, err := mgr.Stat(cx, "@user", "data.json"); rec.Code != http.StatusOK || nil != err ||
+		"application/json" != stat.ContentType || 0 != len(stat.Metadata) {
+		t.Error(fmt.Sprintf("unexpected default type upload %v, got: %v, %v", rec.Code, stat, err))
+		return
+	}
+
+	disposition := `attachment; filename="data.csv"`
+	downloadUrl, _ := mgr.DownloadUrl(cx, "@user", "data.csv", DownloadOptions{ContentDisposition: disposition})
+	rec = httptest.NewRecorder()
+	mgr.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, downloadUrl, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv" ||
+		rec.Header().Get("Content-Disposition") != disposition {
+		t.Error(fmt.Sprintf("unexpected download response %v, got: %v", rec.Code, rec.Header()))
+		return
+	}
+	rec = httptest.NewRecorder()
+	mgr.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, strings.Replace(downloadUrl, "attachment", "inline", 1), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Error(fmt.Sprintf("download with a modified disposition should fail, got: %v", rec.Code))
+	}
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Checksum is the digest of an object's data declared by its upload -
+// the upload url is bound to it, and it is recorded as
+// the object's checksum-$algorithm user metadata
+type Checksum struct {
+	// Algorithm is md5, sha256, or crc32c
+	Algorithm string
+	// Value is the base64 encoded digest - crc32c is big-endian
+	Value     string
+}
+
+// checksumAlgorithms lists the supported algorithms
+var checksumAlgorithms = []string{"md5", "sha256", "crc32c"}
+
+// checksumMetadataPrefix names the user metadata
+// that records an object's checksum
+const checksumMetadataPrefix = "checksum-"
+
+// newChecksumHash returns a hash for the given algorithm -
+// nil if the algorithm is not supported
+func newChecksumHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "md5":
+		return md5.New()
+	case "sha256":
+		return sha256.New()
+	case "crc32c":
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	}
+	return nil
+}
+
+// validate checks that the algorithm is supported, and
+// the value is a base64 digest of the algorithm's size
+func (self *Checksum) validate() error {
+	hash := newChecksumHash(self.Algorithm)
+	if nil == hash {
+		return invalidInputf("invalid checksumAlgorithm - expected one of %v, got %v", strings.Join(checksumAlgorithms, ", "), self.Algorithm)
+	}
+	digest, err := base64.StdEncoding.DecodeString(self.Value)
+	if nil != err || hash.Size() != len(digest) {
+		return invalidInputf("invalid checksum - expected a base64 %v digest, got %v", self.Algorithm, self.Value)
+	}
+	return nil
+}
+
+// digest is the decoded value
+func (self *Checksum) digest() []byte {
+	digest, _ := base64.StdEncoding.DecodeString(self.Value)
+	return digest
+}
+
+// metadataKey is the user metadata key that records the checksum
+func (self *Checksum) metadataKey() string {
+	return checksumMetadataPrefix + self.Algorithm
+}
+
+// metadata is the user metadata that records the checksum
+func (self *Checksum) metadata() map[string]string {
+	if nil == self {
+		return nil
+	}
+	return map[string]string{self.metadataKey(): self.Value}
+}
+
+// checksumFromMetadata finds the checksum recorded in an
+// object's user metadata - nil if none is recorded
+func checksumFromMetadata(metadata map[string]string) *Checksum {
+	for _, algorithm := range checksumAlgorithms {
+		if value, ok := metadata[checksumMetadataPrefix+algorithm]; ok {
+			return &Checksum{Algorithm: algorithm, Value: value}
+		}
+	}
+	return nil
+}
+
+// listChecksumWorkers limits the concurrent stats of a list
+// request that includes checksums
+const listChecksumWorkers = 8
+
+// listChecksums sets the Checksum of each listed object from
+// its stat - listings do not include user metadata.
+// An object deleted since the listing keeps a nil Checksum.
+func listChecksums(mgr Manager, cx *SessionContext, workspaceIn string, listing *ListResult) error {
+	indexes := make(chan int)
+	errs := make(chan error, len(listing.Objects))
+	var wg sync.WaitGroup
+	for worker := 0; worker < listChecksumWorkers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ix := range indexes {
+				stat, err := mgr.Stat(cx, workspaceIn, listing.Objects[ix].WorkspaceKey)
+				if nil == err {
+					listing.Objects[ix].Checksum = stat.Checksum
+				} else if !errors.Is(err, ErrNotFound) {
+					errs <- err
+				}
+			}
+		}()
+	}
+	for ix := range listing.Objects {
+		indexes <- ix
+	}
+	close(indexes)
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// errChecksumMismatch - uploaded data does not match its signed checksum
+var errChecksumMismatch = errors.New("content does not match the signed checksum")
+
+// checksumReader hashes the data read through it, and fails
+// the final read if the digest does not match the expected digest -
+// so a blob store discards the data
+type checksumReader struct {
+	body     io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+func newChecksumReader(body io.Reader, checksum *Checksum) *checksumReader {
+	return &checksumReader{body: body, hash: newChecksumHash(checksum.Algorithm), expected: checksum.Value}
+}
+
+func (self *checksumReader) Read(buf []byte) (int, error) {
+	count, err := self.body.Read(buf)
+	self.hash.Write(buf[:count])
+	if io.EOF == err && base64.StdEncoding.EncodeToString(self.hash.Sum(nil)) != self.expected {
+		return count, errChecksumMismatch
+	}
+	return count, err
+}
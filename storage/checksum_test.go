@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func testChecksum(algorithm string, data string) *Checksum {
+	var digest []byte
+	switch algorithm {
+	case "sha256":
+		sum := sha256.Sum256([]byte(data))
+		digest = sum[:]
+	case "crc32c":
+		digest = make([]byte, 4)
+		binary.BigEndian.PutUint32(digest, crc32.Checksum([]byte(data), crc32.MakeTable(crc32.Castagnoli)))
+	default:
+		hash := newChecksumHash(algorithm)
+		hash.Write([]byte(data))
+		digest = hash.Sum(nil)
+	}
+	return &Checksum{Algorithm: algorithm, Value: base64.StdEncoding.EncodeToString(digest)}
+}
+
+func TestChecksumValidate(t *testing.T) {
+	for _, algorithm := range checksumAlgorithms {
+		checksum := testChecksum(algorithm, "a,b,c")
+		if err := checksum.validate(); nil != err {
+			t.Error(fmt.Sprintf("unexpected %v checksum failed validation, got: %v", algorithm, err))
+		}
+		if checksumFromMetadata(checksum.metadata()).Value != checksum.Value {
+			t.Error(fmt.Sprintf("unexpected %v checksum metadata, got: %v", algorithm, checksum.metadata()))
+		}
+	}
+	invalidTests := []*Checksum{
+		{Algorithm: "sha1", Value: testChecksum("md5", "a,b,c").Value},
+		{Algorithm: "sha256", Value: testChecksum("md5", "a,b,c").Value},
+		{Algorithm: "crc32c", Value: "frickjack"},
+		{Algorithm: "md5", Value: ""},
+	}
+	for _, it := range invalidTests {
+		if err := it.validate(); !errors.Is(err, ErrInvalidInput) {
+			t.Error(fmt.Sprintf("checksum %v should have failed validation, got: %v", it, err))
+		}
+	}
+}
+
+func TestNewApiRequestChecksum(t *testing.T) {
+	sha := url.QueryEscape(testChecksum("sha256", "a,b,c").Value)
+	md := url.QueryEscape(testChecksum("md5", "a,b,c").Value)
+	testCases := []struct {
+		path      string
+		algorithm string
+	}{
+		{"upload/@user/x?contentMD5=" + md, "md5"},
+		{"upload/@user/x?checksumAlgorithm=sha256&checksum=" + sha, "sha256"},
+		{"upload/@user/x", ""},
+	}
+	for _, it := range testCases {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it.path)
+		req, err := NewApiRequest(testUrl, http.MethodGet, testUser)
+		if nil != err {
+			t.Error(fmt.Sprintf("unexpected %v failed validation, got: %v", it.path, err))
+			continue
+		}
+		if (nil == req.Checksum && "" != it.algorithm) || (nil != req.Checksum && req.Checksum.Algorithm != it.algorithm) {
+			t.Error(fmt.Sprintf("unexpected checksum for %v, got: %v", it.path, req.Checksum))
+		}
+	}
+	invalidTests := []string{
+		"upload/@user/x?checksumAlgorithm=sha256&checksum=" + md,
+		"upload/@user/x?checksumAlgorithm=sha256",
+		"upload/@user/x?checksum=" + sha,
+		"upload/@user/x?contentMD5=" + md + "&checksumAlgorithm=sha256&checksum=" + sha,
+		"download/@user/x?checksums=true",
+	}
+	for _, it := range invalidTests {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it)
+		if _, err := NewApiRequest(testUrl, http.MethodGet, testUser); nil == err {
+			t.Error(fmt.Sprintf("%v should have failed validation", it))
+		}
+	}
+}
+
+func TestMgrChecksum(t *testing.T) {
+	checksum := testChecksum("sha256", "a,b,c")
+	mgr, server, err := newStubS3Mgr(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Length", "5")
+		w.Header().Set("X-Amz-Meta-Checksum-Sha256", checksum.Value)
+	}))
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	cx := NewSessionContext(testUser)
+
+	uploadUrl, err := mgr.UploadUrl(cx, "@user", "x", UploadOptions{Checksum: checksum})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to generate upload url, got: %v", err))
+		return
+	}
+	// the payload hash and checksum metadata are signed
+	if !strings.Contains(uploadUrl, "X-Amz-SignedHeaders=content-type%3Bhost%3Bx-amz-content-sha256%3Bx-amz-meta-checksum-sha256&") {
+		t.Error(fmt.Sprintf("upload url does not sign the checksum: %v", uploadUrl))
+		return
+	}
+	stat, err := mgr.Stat(cx, "@user", "x")
+	if nil != err || nil == stat.Checksum || *stat.Checksum != *checksum {
+		t.Error(fmt.Sprintf("unexpected stat checksum, got: %v, %v", stat, err))
+	}
+}
+
+func TestBlobChecksums(t *testing.T) {
+	memMgr, err := NewMemoryManager(&Config{BucketPrefix: "ws-storage-testsuite"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	fsMgr, err := NewFilesystemManager(&Config{Backend: BackendFilesystem, BucketPrefix: "ws-storage-testsuite", RootDir: t.TempDir()})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize filesystem manager, got: %v", err))
+		return
+	}
+	cx := NewSessionContext(testUser)
+	authz := NewStaticAuthorizer(nil)
+	for _, mgr := range []interface {
+		Manager
+		http.Handler
+	}{memMgr, fsMgr} {
+		upload := func(key string, checksum *Checksum, body string) int {
+			uploadUrl, _ := mgr.UploadUrl(cx, "@user", key, UploadOptions{Checksum: checksum})
+			req := httptest.NewRequest(http.MethodPut, uploadUrl, strings.NewReader(body))
+			req.Header.Set("Content-Type", contentTypeByKey(key))
+			rec := httptest.NewRecorder()
+			mgr.ServeHTTP(rec, req)
+			return rec.Code
+		}
+		crc := testChecksum("crc32c", "a,b,c")
+		if code := upload("a.csv", crc, "x,y,z"); http.StatusBadRequest != code {
+			t.Error(fmt.Sprintf("%T: expected a mismatched upload to fail, got: %v", mgr, code))
+			return
+		}
+		if code := upload("a.csv", crc, "a,b,c"); http.StatusOK != code {
+			t.Error(fmt.Sprintf("%T: failed to upload a.csv, got: %v", mgr, code))
+			return
+		}
+		if err := mgr.Copy(cx, "@user", "a.csv", "b.csv"); nil != err {
+			t.Error(fmt.Sprintf("%T: failed to copy, got: %v", mgr, err))
+			return
+		}
+		if err := mgr.Move(cx, "@user", "b.csv", "c.csv"); nil != err {
+			t.Error(fmt.Sprintf("%T: failed to move, got: %v", mgr, err))
+			return
+		}
+		upload("d.csv", nil, "d,e,f")
+		testUrl, _ := url.Parse("https://whatever/ws-storage/list/@user?checksums=true")
+		req, _ := NewApiRequest(testUrl, http.MethodGet, testUser)
+		result := req.HandleApiRequest(mgr, authz, nil, nil, nil)
+		if nil != result.Error {
+			t.Error(fmt.Sprintf("%T: failed to list checksums, got: %v", mgr, result.Result))
+			return
+		}
+		checksums := map[string]*Checksum{}
+		for _, it := range result.Data.(*ListResult).Objects {
+			checksums[it.WorkspaceKey] = it.Checksum
+		}
+		if 3 != len(checksums) || nil == checksums["a.csv"] || *crc != *checksums["a.csv"] ||
+			nil == checksums["c.csv"] || *crc != *checksums["c.csv"] || nil != checksums["d.csv"] {
+			t.Error(fmt.Sprintf("%T: unexpected listing checksums, got: %v", mgr, checksums))
+			return
+		}
+		// an upload without a checksum replaces the old checksum
+		upload("a.csv", nil, "x,y,z")
+		if stat, err := mgr.Stat(cx, "@user", "a.csv"); nil != err || nil != stat.Checksum {
+			t.Error(fmt.Sprintf("%T: unexpected checksum after overwrite, got: %v, %v", mgr, stat, err))
+			return
+		}
+	}
+}
@@ -4,15 +4,196 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"strings"
+)
+
+// Storage backends selectable with Config.Backend
+const (
+	BackendS3         = "s3"
+	BackendMemory     = "memory"
+	BackendFilesystem = "filesystem"
+	BackendGCS        = "gcs"
+	BackendAzure      = "azure"
 )
 
 // Config for constructing an AppContext
 type Config struct {
+	// Backend selects the storage implementation - s3 (default), memory, filesystem, gcs, or azure
+	Backend            string            `json:"backend"`
 	Bucket             string            `json:"bucket"`
 	BucketPrefix       string            `json:"bucketprefix"`
 	LogLevel           string            `json:"loglevel"`
+	// BaseUrl is the public url of this service used to
+	// build upload and download urls for backends that
+	// ws-storage serves itself - default http://localhost:8000
+	BaseUrl            string            `json:"baseurl"`
+	// RootDir is the directory under which the filesystem backend stores objects
+	RootDir            string            `json:"rootdir"`
+	// BlobSecret is the HMAC key for signing urls that ws-storage serves
+	// itself - replicas must share the secret, a random secret is
+	// generated at startup if not set
+	BlobSecret         string            `json:"blobsecret"`
+	// Endpoint overrides the storage service url - ex: minio, ceph rgw,
+	// a local fake gcs server, or azurite
+	Endpoint           string            `json:"endpoint"`
+	// Region is the s3 region - defaults to the AWS SDK environment
+	Region             string            `json:"region"`
+	// PathStyle addresses s3 buckets as $endpoint/$bucket rather
+	// than $bucket.$endpoint - most s3 stand-ins require path style
+	PathStyle          bool              `json:"pathstyle"`
+	// TLSSkipVerify disables TLS certificate verification of the
+	// s3 endpoint - only for development with self-signed certificates
+	TLSSkipVerify      bool              `json:"tlsskipverify"`
+	// GCSCredentials is the path to a service account key file for the gcs backend
+	GCSCredentials     string            `json:"gcscredentials"`
+	// AzureAccount is the storage account for the azure backend -
+	// the bucket is the blob container
+	AzureAccount       string            `json:"azureaccount"`
+	// AzureKey is the base64 storage account key for the azure backend
+	AzureKey           string            `json:"azurekey"`
+	// Authentication selects how requests are authenticated -
+	// remoteuser (default) trusts the REMOTE_USER header set by the
+	// API gateway, jwt validates a bearer token
+	Authentication     string            `json:"authentication"`
+	// Jwt configures jwt authentication
+	Jwt                *JwtConfig        `json:"jwt"`
+	// WorkspacePrefixes maps each named workspace type (ex: @group)
+	// to the bucket prefix under which its workspaces live
+	WorkspacePrefixes  map[string]string `json:"workspaceprefixes"`
+	// Authorizer selects how access to named workspaces is decided - static (default) or arborist
+	Authorizer         string            `json:"authorizer"`
+	// Arborist configures the arborist authorizer
+	Arborist           *ArboristConfig   `json:"arborist"`
+	// WorkspaceMembers lists the readers and writers of each
+	// named workspace (ex: @group/lab1) for the static authorizer
+	WorkspaceMembers   map[string]*WorkspaceMembers `json:"workspacemembers"`
+	// Quota limits the bytes and objects in each workspace - no limit if not set
+	Quota              *QuotaConfig      `json:"quota"`
+	// UsageReport enables the background per-user usage report - disabled if not set
+	UsageReport        *UsageReportConfig `json:"usagereport"`
+	// Trash makes deletes move objects to the .trash/ folder of
+	// their workspace - deletes are immediate if not set
+	Trash              *TrashConfig      `json:"trash"`
+	// Audit enables the audit log of workspace data access - disabled if not set
+	Audit              *AuditConfig      `json:"audit"`
+	// Urls sets the lifetime and required constraints of presigned urls
+	Urls               *UrlConfig        `json:"urls"`
+	// Proxy enables uploads and downloads streamed through
+	// ws-storage - disabled if not set
+	Proxy              *ProxyConfig      `json:"proxy"`
+}
+
+// ProxyConfig limits the uploads and downloads
+// streamed through ws-storage
+type ProxyConfig struct {
+	// MaxTransfers limits the concurrent proxied transfers - default 64
+	MaxTransfers       int               `json:"maxtransfers"`
+	// IdleTimeoutSeconds is how long a transfer waits for the backend's
+	// response headers, or for its next data, before failing - default 60
+	IdleTimeoutSeconds int               `json:"idletimeoutseconds"`
 }
 
+// UrlConfig sets the lifetime of presigned urls, and which
+// constraints an upload url must be bound to
+type UrlConfig struct {
+	// TtlSeconds is the lifetime of a url if the client does not request one - default 3600
+	TtlSeconds         int               `json:"ttlseconds"`
+	// MaxTtlSeconds caps the lifetime a client may request - default TtlSeconds, at most 7 days
+	MaxTtlSeconds      int               `json:"maxttlseconds"`
+	// RequireSize rejects upload urls that do not declare their size -
+	// not supported by the azure backend
+	RequireSize        bool              `json:"requiresize"`
+	// RequireChecksum rejects upload urls that do not declare a checksum
+	// with any supported algorithm - not supported by the azure backend
+	RequireChecksum    bool              `json:"requirechecksum"`
+}
+
+// TrashConfig sets how long deleted objects stay in the trash
+type TrashConfig struct {
+	// RetentionDays is how long an object stays in the trash - default 30
+	RetentionDays        int             `json:"retentiondays"`
+	// SweepIntervalMinutes is the time between sweeps of expired trash - default 60
+	SweepIntervalMinutes int             `json:"sweepintervalminutes"`
+}
+
+// AuditConfig selects where audit records are stored
+type AuditConfig struct {
+	// Sink is file, stdout, or http
+	Sink               string            `json:"sink"`
+	// Path is the file the file sink appends records to
+	Path               string            `json:"path"`
+	// Url is the collector the http sink posts records to
+	Url                string            `json:"url"`
+	// Retries is how many times the http sink retries a record - default 3
+	Retries            int               `json:"retries"`
+	// TimeoutSeconds limits each http sink request - default 5
+	TimeoutSeconds     int               `json:"timeoutseconds"`
+}
+
+// UsageReportConfig configures the background job that sums
+// the bytes and objects each user stores under the bucket prefix
+type UsageReportConfig struct {
+	// IntervalMinutes is the time between walks of the bucket prefix - default 60
+	IntervalMinutes    int               `json:"intervalminutes"`
+	// Admins lists the users that may fetch the JSON report
+	Admins             []string          `json:"admins"`
+}
+
+// QuotaConfig sets the default limits on each workspace,
+// and overrides for particular workspaces
+type QuotaConfig struct {
+	// MaxBytes is the default byte limit - 0 is no limit
+	MaxBytes           int64             `json:"maxbytes"`
+	// MaxObjects is the default object count limit - 0 is no limit
+	MaxObjects         int64             `json:"maxobjects"`
+	// CacheSeconds is how long to cache a workspace's usage - default 60
+	CacheSeconds       int               `json:"cacheseconds"`
+	// Workspaces overrides the limits of the workspaces with
+	// the given names - a user name for a user's @user workspace,
+	// or $type/$name (ex: @group/lab1) for a named workspace
+	Workspaces         map[string]*WorkspaceQuota `json:"workspaces"`
+}
+
+// WorkspaceQuota is the byte and object limit of a workspace - 0 is no limit
+type WorkspaceQuota struct {
+	MaxBytes           int64             `json:"maxbytes"`
+	MaxObjects         int64             `json:"maxobjects"`
+}
+
+// JwtConfig configures validation of bearer JWTs
+type JwtConfig struct {
+	// Jwks is the path or http(s) url of the JSON web key set
+	// with the RSA keys that sign tokens
+	Jwks               string            `json:"jwks"`
+	// UserClaim is the (dotted path of the) claim that names
+	// the user - default sub, ex: context.user.name for gen3 fence tokens
+	UserClaim          string            `json:"userclaim"`
+	// Issuer is the required iss claim if set
+	Issuer             string            `json:"issuer"`
+	// Audience is a required aud claim value if set
+	Audience           string            `json:"audience"`
+}
+
+// ArboristConfig locates the arborist policy service, and
+// maps workspaces to arborist resources
+type ArboristConfig struct {
+	// Url of the arborist service - ex: http://arborist-service
+	Url                string            `json:"url"`
+	// Service is the service in arborist actions - default ws-storage
+	Service            string            `json:"service"`
+	// ResourcePrefix is prepended to a named workspace's resource
+	// path - default /workspaces, so @group/lab1 is /workspaces/group/lab1
+	ResourcePrefix     string            `json:"resourceprefix"`
+	// CacheSeconds is how long to cache decisions - default 60, negative disables the cache
+	CacheSeconds       int               `json:"cacheseconds"`
+}
+
+// WorkspaceMembers lists the users with access to a named workspace -
+// writers may also read
+type WorkspaceMembers struct {
+	Readers            []string          `json:"readers"`
+	Writers            []string          `json:"writers"`
+}
 
 
 // LoadConfig from a json file
@@ -25,11 +206,47 @@ func LoadConfig(configFilePath string) (config *Config, err error) {
 	config = &Config{}
 	
 	_ = json.Unmarshal(configBytes, config)
-	if "" == config.Bucket {
+	if "" == config.Backend {
+		config.Backend = BackendS3
+	}
+	if "" == config.Bucket && BackendMemory != config.Backend && BackendFilesystem != config.Backend {
 		return nil, fmt.Errorf("no bucket in config file: %v", configFilePath)
 	}
 	if "" == config.LogLevel {
 		config.LogLevel = "info"
 	}
+	if err := validateWorkspacePrefixes(config); nil != err {
+		return nil, fmt.Errorf("invalid workspaceprefixes in config file %v - %v", configFilePath, err)
+	}
 	return config, nil
 }
+
+// validateWorkspacePrefixes verifies that each named workspace
+// type has its own bucket prefix, so workspaces of different
+// types never share objects - a prefix may not equal or nest
+// inside another type's prefix
+func validateWorkspacePrefixes(config *Config) error {
+	prefixes := map[string]string{
+		strings.TrimSuffix(config.BucketPrefix, "/"): UserWorkspace,
+	}
+	for wsType, prefix := range config.WorkspacePrefixes {
+		if !strings.HasPrefix(wsType, "@") || UserWorkspace == wsType || strings.Contains(wsType, "/") {
+			return fmt.Errorf("invalid workspace type: %v", wsType)
+		}
+		normalized := strings.TrimSuffix(prefix, "/")
+		for other, otherType := range prefixes {
+			if prefixOverlaps(normalized, other) {
+				return fmt.Errorf("workspace type %v bucket prefix %v overlaps the %v prefix %v", wsType, prefix, otherType, other)
+			}
+		}
+		prefixes[normalized] = wsType
+	}
+	return nil
+}
+
+// prefixOverlaps checks if one bucket prefix equals the other,
+// or sits inside it - the empty prefix is the bucket root
+func prefixOverlaps(a string, b string) bool {
+	return a == b || "" == a || "" == b ||
+		strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
@@ -4,6 +4,10 @@ package storage
 // AppContext runtime context
 type SessionContext struct {
 	User    string
+	// Token is the bearer token from the request's Authorization
+	// header if any - policy services like arborist identify
+	// the user by token
+	Token   string
 }
 
 func NewSessionContext(user string) (cx *SessionContext) {
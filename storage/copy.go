@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/rs/zerolog/log"
+)
+
+// maxCopyObjectBytes is the largest object a single
+// S3 CopyObject request copies - larger objects
+// are copied part by part with UploadPartCopy
+const maxCopyObjectBytes = 5 * 1024 * 1024 * 1024
+
+// copyPartBytes is the part size of a multipart copy
+const copyPartBytes = 1024 * 1024 * 1024
+
+// validateCopyKeys checks that a copy or move has
+// distinct source and destination objects
+func validateCopyKeys(srcKey string, dstKey string) error {
+	if "" == srcKey || "" == dstKey {
+		return invalidInputf("invalid copy - source and destination keys are required")
+	}
+	if srcKey == dstKey {
+		return invalidInputf("invalid copy - source and destination are both %v", srcKey)
+	}
+	return nil
+}
+
+// moveObject moves an object by copying it to the
+// destination, then deleting the source - for backends
+// without a native rename
+func moveObject(mgr Manager, cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	if err := mgr.Copy(cx, workspaceIn, srcKey, dstKey); nil != err {
+		return err
+	}
+	return mgr.DeleteObject(cx, workspaceIn, srcKey)
+}
+
+// exclusiveMover is implemented by managers that can move an
+// object only if nothing exists at the destination key - the
+// move fails with ErrConflict if the destination exists
+type exclusiveMover interface {
+	moveExclusive(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error
+}
+
+// s3NotFound checks for an S3 not found error - NoSuchKey,
+// NoSuchUpload, or a 404
+func s3NotFound(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	awsErr, ok := err.(awserr.Error)
+	return ok && (awsErr.Code() == s3.ErrCodeNoSuchKey || awsErr.Code() == s3.ErrCodeNoSuchUpload || awsErr.Code() == "NotFound")
+}
+
+// Copy copies the source object to the destination key in
+// the same workspace with CopyObject - or a multipart copy
+// for objects over 5 GB
+func (self *SimpleManager) Copy(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	return self.copyObject(cx, workspaceIn, srcKey, dstKey, false)
+}
+
+// moveExclusive moves the object if the destination does not exist -
+// S3 cannot make a copy conditional on its destination, so the
+// destination is checked just before the copy
+func (self *SimpleManager) moveExclusive(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	if err := self.copyObject(cx, workspaceIn, srcKey, dstKey, true); nil != err {
+		return err
+	}
+	return self.DeleteObject(cx, workspaceIn, srcKey)
+}
+
+func (self *SimpleManager) copyObject(cx *SessionContext, workspaceIn string, srcKey string, dstKey string, exclusive bool) error {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
+	}
+	if err := validateCopyKeys(srcKey, dstKey); nil != err {
+		return err
+	}
+	srcPath, err := workspace.Path(srcKey)
+	if err != nil {
+		return err
+	}
+	dstPath, err := workspace.Path(dstKey)
+	if err != nil {
+		return err
+	}
+	head, err := self.s3client.HeadObject(&s3.HeadObjectInput{
+		Bucket: &self.config.Bucket,
+		Key:    &srcPath,
+	})
+	if s3NotFound(err) {
+		return fmt.Errorf("%w - %v", ErrNotFound, srcKey)
+	}
+	if err != nil {
+		return err
+	}
+	if exclusive {
+		_, err := self.s3client.HeadObject(&s3.HeadObjectInput{
+			Bucket: &self.config.Bucket,
+			Key:    &dstPath,
+		})
+		if nil == err {
+			return fmt.Errorf("%w - %v exists", ErrConflict, dstKey)
+		}
+		if !s3NotFound(err) {
+			return err
+		}
+	}
+	copySource := (&url.URL{Path: self.config.Bucket + "/" + srcPath}).EscapedPath()
+	size := aws.Int64Value(head.ContentLength)
+	if size > maxCopyObjectBytes {
+		err = self.multipartCopy(copySource, dstPath, head)
+	} else {
+		_, err = self.s3client.CopyObject(&s3.CopyObjectInput{
+			Bucket:     &self.config.Bucket,
+			Key:        &dstPath,
+			CopySource: &copySource,
+		})
+	}
+	if s3NotFound(err) {
+		return fmt.Errorf("%w - %v", ErrNotFound, srcKey)
+	}
+	if err != nil {
+		return err
+	}
+	log.Debug().Str("Func", "Copy").
+		Str("Workspace", workspace.Name).
+		Str("Key", srcKey).
+		Str("Destination", dstKey).
+		Int64("SizeBytes", size).
+		Send()
+	return nil
+}
+
+// multipartCopy copies a large object part by part,
+// and aborts the upload if a part fails - unlike CopyObject,
+// a multipart upload does not carry over the source's
+// content type and metadata, so copy them from its head
+func (self *SimpleManager) multipartCopy(copySource string, dstPath string, head *s3.HeadObjectOutput) error {
+	size := aws.Int64Value(head.ContentLength)
+	upload, err := self.s3client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:      &self.config.Bucket,
+		Key:         &dstPath,
+		ContentType: head.ContentType,
+		Metadata:    head.Metadata,
+	})
+	if err != nil {
+		return err
+	}
+	parts := []*s3.CompletedPart{}
+	for offset, partNumber := int64(0), int64(1); offset < size; offset, partNumber = offset+copyPartBytes, partNumber+1 {
+		last := offset + copyPartBytes - 1
+		if last >= size {
+			last = size - 1
+		}
+		var resp *s3.UploadPartCopyOutput
+		resp, err = self.s3client.UploadPartCopy(&s3.UploadPartCopyInput{
+			Bucket:          &self.config.Bucket,
+			Key:             &dstPath,
+			CopySource:      &copySource,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%v-%v", offset, last)),
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int64(partNumber),
+		})
+		if err != nil {
+			break
+		}
+		parts = append(parts, &s3.CompletedPart{
+			PartNumber: aws.Int64(partNumber),
+			ETag:       resp.CopyPartResult.ETag,
+		})
+	}
+	if err == nil {
+		_, err = self.s3client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          &self.config.Bucket,
+			Key:             &dstPath,
+			UploadId:        upload.UploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	if err != nil {
+		self.s3client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   &self.config.Bucket,
+			Key:      &dstPath,
+			UploadId: upload.UploadId,
+		})
+	}
+	return err
+}
+
+// Move copies the source object to the destination key,
+// then deletes the source
+func (self *SimpleManager) Move(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	return moveObject(self, cx, workspaceIn, srcKey, dstKey)
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCopyApiRequest(t *testing.T) {
+	testUrl, _ := url.Parse("https://whatever/ws-storage/move/@group/lab1/a/b.txt?destination=c/d.txt")
+	req, err := NewApiRequest(testUrl, http.MethodPost, testUser)
+	if nil != err {
+		t.Error(fmt.Sprintf("unexpected path %v failed validation, got: %v", testUrl.Path, err))
+		return
+	}
+	if "move" != req.Verb || "@group/lab1" != req.Workspace || "a/b.txt" != req.Key || "c/d.txt" != req.Destination {
+		t.Error(fmt.Sprintf("unexpected move request, got: %v", req))
+		return
+	}
+	invalidTests := [][]string{
+		{http.MethodGet, "copy/@user/a.txt?destination=b.txt"},
+		{http.MethodPost, "copy/@user/a.txt"},
+		{http.MethodPost, "move/@user/?destination=b.txt"},
+	}
+	for _, it := range invalidTests {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it[1])
+		if _, err := NewApiRequest(testUrl, it[0], testUser); nil == err {
+			t.Error(fmt.Sprintf("%v %v should have failed validation", it[0], it[1]))
+			return
+		}
+	}
+}
+
+func TestHandleApiRequestCopyMove(t *testing.T) {
+	mgr, err := getTestMgr(t)
+	if nil != err {
+		return
+	}
+	authz, err := getTestAuthz(t)
+	if nil != err {
+		return
+	}
+	cx := NewSessionContext(testUser)
+	if err := mgr.Copy(cx, "@user", testFolder+"/x", "copyTest/x"); nil != err {
+		t.Error(fmt.Sprintf("failed to copy seed object, got: %v", err))
+		return
+	}
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"copy/@user/copyTest/x?destination=copyTest/y", "ok"},
+		{"move/@user/copyTest/y?destination=copyTest/sub/z", "ok"},
+		{"move/@user/copyTest/y?destination=copyTest/w", resultNotFound},
+		{"copy/@user/copyTest/z?destination=copyTest/v", resultNotFound},
+		{"copy/@user/copyTest/x?destination=copyTest/x", "error"},
+		{"copy/@user/copyTest/x?destination=../x", "error"},
+	}
+	for _, it := range testCases {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it.path)
+		req, err := NewApiRequest(testUrl, http.MethodPost, testUser)
+		if nil != err {
+			t.Error(fmt.Sprintf("unexpected path %v failed validation, got: %v", it.path, err))
+			return
+		}
+		result := req.HandleApiRequest(mgr, authz, nil, nil, nil)
+		if !strings.HasPrefix(result.Result, it.expected) {
+			t.Error(fmt.Sprintf("unexpected result for %v, got: %v", it.path, result.Result))
+			return
+		}
+	}
+	// the move removed y
+	info, err := mgr.List(cx, "@user", "copyTest/", "", 0)
+	if nil != err || len(info.Objects) != 1 || info.Objects[0].WorkspaceKey != "copyTest/x" ||
+		len(info.Prefixes) != 1 || info.Prefixes[0] != "copyTest/sub/" {
+		t.Error(fmt.Sprintf("unexpected listing after copy and move, got: %v, %v", info, err))
+		return
+	}
+	stat, err := mgr.Stat(cx, "@user", "copyTest/sub/z")
+	if nil != err || stat.SizeBytes != int64(len("some random stuff")) {
+		t.Error(fmt.Sprintf("unexpected moved object, got: %v, %v", stat, err))
+		return
+	}
+	for _, key := range []string{"copyTest/x", "copyTest/sub/z"} {
+		mgr.DeleteObject(cx, "@user", key)
+	}
+}
+
+func TestMgrCopy(t *testing.T) {
+	srcPath := "/ws-storage-test/ws-storage-testsuite/goTestUser/big.bam"
+	dstPath := "/ws-storage-test/ws-storage-testsuite/goTestUser/copy%20of%20big.bam"
+	size := int64(maxCopyObjectBytes + copyPartBytes/2)
+	copyRanges := []string{}
+	completeBody := ""
+	copySource := ""
+	uploadHeader := http.Header{}
+	mgr, server, err := newStubS3Mgr(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		_, isUploads := query["uploads"]
+		switch {
+		case r.Method == http.MethodHead && r.URL.Path == srcPath:
+			w.Header().Set("Content-Length", fmt.Sprintf("%v", size))
+			w.Header().Set("Content-Type", "application/x-bam")
+			w.Header().Set("X-Amz-Meta-Sha256", "abc123")
+		case r.Method == http.MethodHead:
+			http.NotFound(w, r)
+		case r.Method == http.MethodPost && isUploads && r.URL.EscapedPath() == dstPath:
+			uploadHeader = r.Header.Clone()
+			fmt.Fprintf(w, `<InitiateMultipartUploadResult><UploadId>abc</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPut && query.Get("uploadId") == "abc" && r.URL.EscapedPath() == dstPath:
+			copySource = r.Header.Get("x-amz-copy-source")
+			copyRanges = append(copyRanges, r.Header.Get("x-amz-copy-source-range"))
+			fmt.Fprintf(w, `<CopyPartResult><ETag>"part%v"</ETag></CopyPartResult>`, query.Get("partNumber"))
+		case r.Method == http.MethodPost && query.Get("uploadId") == "abc" && r.URL.EscapedPath() == dstPath:
+			buf := &bytes.Buffer{}
+			buf.ReadFrom(r.Body)
+			completeBody = buf.String()
+			fmt.Fprintf(w, `<CompleteMultipartUploadResult><ETag>"xyz"</ETag></CompleteMultipartUploadResult>`)
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	cx := NewSessionContext(testUser)
+	if err := mgr.Copy(cx, "@user", "big.bam", "copy of big.bam"); nil != err {
+		t.Error(fmt.Sprintf("failed to copy large object, got: %v", err))
+		return
+	}
+	if len(copyRanges) != 6 || copyRanges[0] != fmt.Sprintf("bytes=0-%v", copyPartBytes-1) ||
+		copyRanges[5] != fmt.Sprintf("bytes=%v-%v", maxCopyObjectBytes, size-1) {
+		t.Error(fmt.Sprintf("unexpected copy part ranges, got: %v", copyRanges))
+		return
+	}
+	if copySource != "ws-storage-test/ws-storage-testsuite/goTestUser/big.bam" || !strings.Contains(completeBody, "part6") {
+		t.Error(fmt.Sprintf("unexpected multipart copy, got: %v, %v", copySource, completeBody))
+		return
+	}
+	if uploadHeader.Get("Content-Type") != "application/x-bam" || uploadHeader.Get("X-Amz-Meta-Sha256") != "abc123" {
+		t.Error(fmt.Sprintf("multipart copy should keep the content type and metadata, got: %v", uploadHeader))
+		return
+	}
+	if err := mgr.Copy(cx, "@user", "missing.bam", "x.bam"); !errors.Is(err, ErrNotFound) {
+		t.Error(fmt.Sprintf("expected not found copying a missing object, got: %v", err))
+	}
+}
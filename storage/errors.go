@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Managers, authorizers, and the quota enforcer return errors
+// that wrap one of these kinds - check with errors.Is.
+// HandleApiRequest maps each kind to an http status code
+// and a machine readable ApiError code.
+var (
+	// ErrNotFound - the object or upload does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrForbidden - the user may not access the workspace
+	ErrForbidden = errors.New("forbidden")
+	// ErrInvalidInput - an invalid key, path, or request parameter
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrConflict - the request conflicts with an object
+	// that already exists
+	ErrConflict = errors.New("conflict")
+	// ErrQuotaExceeded - the request would exceed the workspace's quota
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrUnavailable - the storage backend or authorization
+	// service could not be reached, or failed - the request may be retried
+	ErrUnavailable = errors.New("backend unavailable")
+)
+
+// ApiError codes
+const (
+	ErrorCodeNotFound       = "NotFound"
+	ErrorCodeForbidden      = "Forbidden"
+	ErrorCodeInvalidInput   = "InvalidInput"
+	ErrorCodeConflict       = "Conflict"
+	ErrorCodeQuotaExceeded  = "QuotaExceeded"
+	ErrorCodeUnavailable    = "Unavailable"
+	ErrorCodeUnauthorized   = "Unauthorized"
+	ErrorCodePartialFailure = "PartialFailure"
+	ErrorCodeInternal       = "InternalError"
+)
+
+// errorKinds maps each error kind to its code and status
+var errorKinds = []struct {
+	kind       error
+	code       string
+	statusCode int
+}{
+	{ErrNotFound, ErrorCodeNotFound, http.StatusNotFound},
+	{ErrForbidden, ErrorCodeForbidden, http.StatusForbidden},
+	{ErrInvalidInput, ErrorCodeInvalidInput, http.StatusBadRequest},
+	{ErrConflict, ErrorCodeConflict, http.StatusConflict},
+	{ErrQuotaExceeded, ErrorCodeQuotaExceeded, http.StatusRequestEntityTooLarge},
+	{ErrUnavailable, ErrorCodeUnavailable, http.StatusServiceUnavailable},
+}
+
+// errorCodeStatus maps the codes that are not error kinds
+var errorCodeStatus = map[string]int{
+	ErrorCodeUnauthorized:   http.StatusUnauthorized,
+	ErrorCodePartialFailure: http.StatusInternalServerError,
+	ErrorCodeInternal:       http.StatusInternalServerError,
+}
+
+// ApiError is the machine readable error in an ApiResult
+type ApiError struct {
+	Code    string
+	Message string
+}
+
+// StatusCode is the http status for the error's code
+func (self *ApiError) StatusCode() int {
+	for _, it := range errorKinds {
+		if it.code == self.Code {
+			return it.statusCode
+		}
+	}
+	if statusCode, ok := errorCodeStatus[self.Code]; ok {
+		return statusCode
+	}
+	return http.StatusInternalServerError
+}
+
+// newApiError classifies err - backend errors that
+// do not wrap an error kind are internal errors
+// unless the backend is unreachable or failing
+func newApiError(err error) *ApiError {
+	for _, it := range errorKinds {
+		if errors.Is(err, it.kind) {
+			return &ApiError{Code: it.code, Message: err.Error()}
+		}
+	}
+	if isUnavailable(err) {
+		return &ApiError{Code: ErrorCodeUnavailable, Message: err.Error()}
+	}
+	return &ApiError{Code: ErrorCodeInternal, Message: err.Error()}
+}
+
+// isUnavailable detects S3 errors and network errors
+// that mean the backend is down, overloaded, or unreachable
+func isUnavailable(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		return reqErr.StatusCode() >= 500 || http.StatusTooManyRequests == reqErr.StatusCode()
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return request.ErrCodeRequestError == awsErr.Code() || request.ErrCodeResponseTimeout == awsErr.Code()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// kindError is an error of the given kind with its own message
+type kindError struct {
+	kind    error
+	message string
+}
+
+func (self *kindError) Error() string {
+	return self.message
+}
+
+func (self *kindError) Unwrap() error {
+	return self.kind
+}
+
+// invalidInputf formats an ErrInvalidInput error
+func invalidInputf(format string, args ...interface{}) error {
+	return &kindError{kind: ErrInvalidInput, message: fmt.Sprintf(format, args...)}
+}
+
+// unavailablef formats an ErrUnavailable error
+func unavailablef(format string, args ...interface{}) error {
+	return &kindError{kind: ErrUnavailable, message: fmt.Sprintf(format, args...)}
+}
+
+// statusErrorf formats the error for a failed storage service
+// response - ErrUnavailable if the service is failing or throttling
+func statusErrorf(statusCode int, format string, args ...interface{}) error {
+	if statusCode >= 500 || http.StatusTooManyRequests == statusCode {
+		return unavailablef(format, args...)
+	}
+	return fmt.Errorf(format, args...)
+}
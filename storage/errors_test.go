@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+func TestNewApiError(t *testing.T) {
+	testCases := []struct {
+		err        error
+		code       string
+		statusCode int
+	}{
+		{fmt.Errorf("%w - x", ErrNotFound), ErrorCodeNotFound, 404},
+		{fmt.Errorf("%w - goTestUser may not write workspace @group/lab1", ErrForbidden), ErrorCodeForbidden, 403},
+		{invalidInputf("invalid path - // or .. or /./: %v", "a//b"), ErrorCodeInvalidInput, 400},
+		{fmt.Errorf("%w - workspace goTestUser has 3 of 3 objects", ErrQuotaExceeded), ErrorCodeQuotaExceeded, 413},
+		{fmt.Errorf("%w - x exists", ErrConflict), ErrorCodeConflict, 409},
+		{fmt.Errorf("failed to calculate workspace usage - %w", unavailablef("down")), ErrorCodeUnavailable, 503},
+		{statusErrorf(503, "gcs list failed with status %v", 503), ErrorCodeUnavailable, 503},
+		{statusErrorf(403, "gcs list failed with status %v", 403), ErrorCodeInternal, 500},
+		{awserr.NewRequestFailure(awserr.New("SlowDown", "reduce your request rate", nil), 503, "id"), ErrorCodeUnavailable, 503},
+		{awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), 403, "id"), ErrorCodeInternal, 500},
+		{awserr.New(request.ErrCodeRequestError, "send request failed", nil), ErrorCodeUnavailable, 503},
+		{&url.Error{Op: "Get", URL: "https://storage.example", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, ErrorCodeUnavailable, 503},
+		{errors.New("something else"), ErrorCodeInternal, 500},
+	}
+	for ix, it := range testCases {
+		apiErr := newApiError(it.err)
+		if apiErr.Code != it.code || apiErr.StatusCode() != it.statusCode || apiErr.Message != it.err.Error() {
+			t.Error(fmt.Sprintf("unexpected api error for case %v, got: %v", ix, apiErr))
+		}
+	}
+	if err := invalidInputf("invalid limit - got %v", 0); "invalid limit - got 0" != err.Error() || !errors.Is(err, ErrInvalidInput) {
+		t.Error(fmt.Sprintf("unexpected invalid input error, got: %v", err))
+	}
+}
+
+func TestHandleApiRequestErrors(t *testing.T) {
+	mgr, err := NewMemoryManager(&Config{BucketPrefix: "ws-storage-testsuite"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	authz := NewStaticAuthorizer(map[string]*WorkspaceMembers{"@group/lab1": {Readers: []string{testUser}}})
+	quota := NewQuotaEnforcer(&QuotaConfig{MaxObjects: 1})
+	testCases := []struct {
+		method string
+		path   string
+		code   string
+	}{
+		{http.MethodGet, "stat/@user/missing", ErrorCodeNotFound},
+		{http.MethodGet, "upload/@group/lab1/x", ErrorCodeForbidden},
+		{http.MethodGet, "stat/@user/a/../b", ErrorCodeInvalidInput},
+		{http.MethodGet, "upload/@user/x", ErrorCodeInvalidInput},
+		{http.MethodGet, "upload/@user/x?size=1", ""},
+		{http.MethodGet, "upload/@user/y?size=1", ErrorCodeQuotaExceeded},
+	}
+	for _, it := range testCases {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it.path)
+		req, err := NewApiRequest(testUrl, it.method, testUser)
+		if nil != err {
+			t.Error(fmt.Sprintf("unexpected path %v failed validation, got: %v", it.path, err))
+			return
+		}
+		result := req.HandleApiRequest(mgr, authz, quota, nil, nil)
+		if "" == it.code {
+			if nil != result.Error || 200 != result.StatusCode() || "ok" != result.Result {
+				t.Error(fmt.Sprintf("unexpected error for %v, got: %v", it.path, result.Error))
+			}
+			continue
+		}
+		if nil == result.Error || it.code != result.Error.Code || nil != result.Data {
+			t.Error(fmt.Sprintf("expected %v for %v, got: %v", it.code, it.path, result.Error))
+		}
+	}
+}
+
+func TestWriteApiResult(t *testing.T) {
+	rec := httptest.NewRecorder()
+	statusCode := writeApiResult(rec, newErrorResult("stat", fmt.Errorf("%w - x", ErrNotFound)))
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); nil != err || 404 != statusCode || 404 != rec.Code || "application/json" != rec.Header().Get("Content-Type") {
+		t.Error(fmt.Sprintf("unexpected response %v, got: %v, %v", rec.Code, rec.Body.String(), err))
+		return
+	}
+	apiErr, _ := body["Error"].(map[string]interface{})
+	if resultNotFound != body["Result"] || ErrorCodeNotFound != apiErr["Code"] || "not found - x" != apiErr["Message"] {
+		t.Error(fmt.Sprintf("unexpected error body, got: %v", rec.Body.String()))
+	}
+	rec = httptest.NewRecorder()
+	body = map[string]interface{}{}
+	writeApiResult(rec, &ApiResult{Version: 1, Method: "list", Result: "ok"})
+	if 200 != rec.Code || json.Unmarshal(rec.Body.Bytes(), &body) != nil || nil != body["Error"] {
+		t.Error(fmt.Sprintf("unexpected ok response %v, got: %v", rec.Code, rec.Body.String()))
+	}
+}
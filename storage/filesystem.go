@@ -0,0 +1,564 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tempFilePrefix names in-progress uploads - List skips these files
+const tempFilePrefix = ".ws-storage-upload-"
+
+// metaFilePrefix names the files that hold the user metadata of
+// the object file named by the rest of the name - List skips these files
+const metaFilePrefix = ".ws-storage-meta-"
+
+// isInternalFile checks if the file name is an
+// in-progress upload or a metadata file
+func isInternalFile(name string) bool {
+	return strings.HasPrefix(name, tempFilePrefix) || strings.HasPrefix(name, metaFilePrefix)
+}
+
+// validateFileKey rejects keys with a folder or file name
+// reserved for internal files - an object with such a name
+// could overwrite another object's metadata, and List hides it
+func validateFileKey(key string) error {
+	for _, name := range strings.Split(key, "/") {
+		if isInternalFile(name) {
+			return invalidInputf("invalid key - names starting with %v or %v are reserved, got %v", tempFilePrefix, metaFilePrefix, key)
+		}
+	}
+	return nil
+}
+
+// metaFilePath is the path of the metadata file of the object file at target
+func metaFilePath(target string) string {
+	return filepath.Join(filepath.Dir(target), metaFilePrefix+filepath.Base(target))
+}
+
+// readMetadata reads the user metadata of the object file at target -
+// empty if the object has none
+func readMetadata(target string) map[string]string {
+	metadata := map[string]string{}
+	if data, err := ioutil.ReadFile(metaFilePath(target)); nil == err {
+		if err := json.Unmarshal(data, &metadata); nil != err {
+			log.Error().Str("Func", "readMetadata").Msgf("invalid metadata file for %v - %v", target, err)
+		}
+	}
+	return metadata
+}
+
+// writeMetadata replaces the user metadata of the object file at target
+func writeMetadata(target string, metadata map[string]string) error {
+	if 0 == len(metadata) {
+		if err := os.Remove(metaFilePath(target)); nil != err && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.Marshal(metadata)
+	if nil != err {
+		return err
+	}
+	return ioutil.WriteFile(metaFilePath(target), data, 0644)
+}
+
+// FilesystemManager is a Manager that stores objects as
+// files under a root directory for commons without S3.
+// An object's file path is the root directory joined with
+// the object's MakeS3Path path.
+// Upload and download urls are HMAC signed urls served by
+// the FilesystemManager's own http handler under BlobPathPrefix.
+type FilesystemManager struct {
+	config *Config
+	signer *blobSigner
+}
+
+// NewFilesystemManager makes a new filesystem manager with the given configuration
+func NewFilesystemManager(config *Config) (*FilesystemManager, error) {
+	if "" == config.RootDir {
+		return nil, fmt.Errorf("filesystem backend requires rootdir in config")
+	}
+	if err := os.MkdirAll(config.RootDir, 0755); nil != err {
+		return nil, fmt.Errorf("failed to create root directory %v - %v", config.RootDir, err)
+	}
+	signer, err := newBlobSigner([]byte(config.BlobSecret), config.BaseUrl)
+	if nil != err {
+		return nil, err
+	}
+	return &FilesystemManager{
+		config: config,
+		signer: signer,
+	}, nil
+}
+
+// filePath maps an object path from MakeS3Path to a file path
+func (self *FilesystemManager) filePath(s3path string) string {
+	return filepath.Join(self.config.RootDir, filepath.FromSlash(s3path))
+}
+
+// List the prefixes and objects under a given workspace and prefix
+func (self *FilesystemManager) List(cx *SessionContext, workspaceIn string, prefix string, page string, limit int) (*ListResult, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 0 || limit > MaxListLimit {
+		return nil, invalidInputf("invalid limit - must be between 0 and %v, got %v", MaxListLimit, limit)
+	}
+	if limit == 0 {
+		limit = MaxListLimit
+	}
+	s3path, err := workspace.Path(prefix)
+	if err != nil {
+		return nil, err
+	}
+	s3prefix, err := workspace.Path("")
+	if err != nil {
+		return nil, err
+	}
+	result := &ListResult{
+		Workspace: workspace.Name,
+		Prefix:    prefix,
+		Objects:   []ObjectInfo{},
+		Prefixes:  []string{},
+	}
+
+	// the prefix may end part way through a file name
+	dir := s3path[:strings.LastIndex(s3path, "/")+1]
+	namePrefix := s3path[len(dir):]
+	entries, err := ioutil.ReadDir(self.filePath(dir))
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	infos := map[string]os.FileInfo{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, namePrefix) || isInternalFile(name) {
+			continue
+		}
+		if entry.IsDir() {
+			keys = append(keys, dir+name+"/")
+		} else if entry.Mode().IsRegular() {
+			keys = append(keys, dir+name)
+			infos[dir+name] = entry
+		}
+	}
+	objects, prefixes, nextPage := listEntries(keys, s3path, page, limit)
+
+	result.NextPage = nextPage
+	for _, key := range objects {
+		info := infos[key]
+		result.Objects = append(result.Objects, ObjectInfo{
+			Workspace:    workspace.Name,
+			WorkspaceKey: strings.Replace(key, s3prefix, "", 1),
+			SizeBytes:    info.Size(),
+			LastModified: info.ModTime().UTC(),
+		})
+	}
+	for _, item := range prefixes {
+		result.Prefixes = append(result.Prefixes, strings.Replace(item, s3prefix, "", 1))
+	}
+	return result, nil
+}
+
+// UploadUrl generates a signed upload url served by ServeHTTP
+func (self *FilesystemManager) UploadUrl(cx *SessionContext, workspaceIn string, key string, options UploadOptions) (string, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return "", err
+	}
+	if err := validateFileKey(key); err != nil {
+		return "", err
+	}
+	if err := validateUploadOptions(self.config, options); err != nil {
+		return "", err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return "", err
+	}
+	log.Debug().Str("Func", "UploadUrl").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Send()
+	return self.signer.SignUrl(http.MethodPut, s3path, urlTtl(self.config, options.Expires), uploadConstraints(key, options)), nil
+}
+
+// DownloadUrl generates a signed download url served by ServeHTTP -
+// supports the range HTTP header
+func (self *FilesystemManager) DownloadUrl(cx *SessionContext, workspaceIn string, key string, options DownloadOptions) (string, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return "", err
+	}
+	if err := validateFileKey(key); err != nil {
+		return "", err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return "", err
+	}
+	log.Debug().Str("Func", "DownloadUrl").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Send()
+	return self.signer.SignUrl(http.MethodGet, s3path, urlTtl(self.config, options.Expires), downloadConstraints(options)), nil
+}
+
+// DeleteObject removes the given object file, and any
+// parent folders under the workspace left empty.
+// Deleting an object that does not exist is not an error.
+func (self *FilesystemManager) DeleteObject(cx *SessionContext, workspaceIn string, key string) error {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
+	}
+	if err := validateFileKey(key); err != nil {
+		return err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return err
+	}
+	s3prefix, err := workspace.Path("")
+	if err != nil {
+		return err
+	}
+	target := self.filePath(s3path)
+	if info, err := os.Lstat(target); nil == err && info.IsDir() {
+		return invalidInputf("invalid key - %v is a folder", key)
+	}
+	if err := os.Remove(target); nil != err && !os.IsNotExist(err) {
+		return err
+	}
+	if err := writeMetadata(target, nil); nil != err {
+		return err
+	}
+	self.pruneEmptyDirs(s3prefix, target)
+	log.Debug().Str("Func", "DeleteObject").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Send()
+	return nil
+}
+
+// Stat returns the metadata of the given object file -
+// the ETag is derived from the file's modification time and size
+func (self *FilesystemManager) Stat(cx *SessionContext, workspaceIn string, key string) (*ObjectStat, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateFileKey(key); err != nil {
+		return nil, err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return nil, err
+	}
+	target := self.filePath(s3path)
+	info, err := os.Lstat(target)
+	if (nil == err && !info.Mode().IsRegular()) || os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w - %v", ErrNotFound, key)
+	}
+	if nil != err {
+		return nil, err
+	}
+	contentType, metadata := splitBlobMetadata(key, readMetadata(target))
+	return &ObjectStat{
+		ObjectInfo: ObjectInfo{
+			Workspace:    workspace.Name,
+			WorkspaceKey: key,
+			SizeBytes:    info.Size(),
+			LastModified: info.ModTime().UTC(),
+			Checksum:     checksumFromMetadata(metadata),
+		},
+		ETag:         fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+		ContentType:  contentType,
+		StorageClass: "STANDARD",
+		Metadata:     metadata,
+	}, nil
+}
+
+// pruneEmptyDirs removes the parent folders of a removed file
+// under the workspace with path s3prefix that are left empty -
+// like S3, folders only exist while they have objects
+func (self *FilesystemManager) pruneEmptyDirs(s3prefix string, target string) {
+	workspaceDir := self.filePath(s3prefix)
+	for dir := filepath.Dir(target); strings.HasPrefix(dir, workspaceDir) && dir != workspaceDir; dir = filepath.Dir(dir) {
+		if nil != os.Remove(dir) {
+			break
+		}
+	}
+}
+
+// Copy copies the source object file to the destination key
+func (self *FilesystemManager) Copy(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	return self.copyOrMove(cx, workspaceIn, srcKey, dstKey, false, false)
+}
+
+// Move renames the source object file to the destination key
+func (self *FilesystemManager) Move(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	return self.copyOrMove(cx, workspaceIn, srcKey, dstKey, true, false)
+}
+
+// moveExclusive renames the source object file if the destination does
+// not exist - it links the file into place, which fails if the
+// destination exists, then removes the source
+func (self *FilesystemManager) moveExclusive(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	return self.copyOrMove(cx, workspaceIn, srcKey, dstKey, true, true)
+}
+
+func (self *FilesystemManager) copyOrMove(cx *SessionContext, workspaceIn string, srcKey string, dstKey string, move bool, exclusive bool) error {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
+	}
+	if err := validateCopyKeys(srcKey, dstKey); nil != err {
+		return err
+	}
+	if err := validateFileKey(srcKey); nil != err {
+		return err
+	}
+	if err := validateFileKey(dstKey); nil != err {
+		return err
+	}
+	srcPath, err := workspace.Path(srcKey)
+	if err != nil {
+		return err
+	}
+	dstPath, err := workspace.Path(dstKey)
+	if err != nil {
+		return err
+	}
+	s3prefix, err := workspace.Path("")
+	if err != nil {
+		return err
+	}
+	src, _, metadata, err := self.openBlob(srcPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w - %v", ErrNotFound, srcKey)
+	}
+	if err != nil {
+		return err
+	}
+	if move {
+		src.Close()
+		target := self.filePath(dstPath)
+		if info, err := os.Lstat(target); nil == err && info.IsDir() {
+			return invalidInputf("invalid key - %v is a folder", dstKey)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); nil != err {
+			return err
+		}
+		if exclusive {
+			if err := os.Link(self.filePath(srcPath), target); os.IsExist(err) {
+				return fmt.Errorf("%w - %v exists", ErrConflict, dstKey)
+			} else if nil != err {
+				return err
+			}
+			if err := os.Remove(self.filePath(srcPath)); nil != err {
+				return err
+			}
+		} else if err := os.Rename(self.filePath(srcPath), target); nil != err {
+			return err
+		}
+		if err := writeMetadata(target, metadata); nil != err {
+			return err
+		}
+		if err := writeMetadata(self.filePath(srcPath), nil); nil != err {
+			return err
+		}
+		self.pruneEmptyDirs(s3prefix, self.filePath(srcPath))
+	} else {
+		err = self.writeBlob(dstPath, src, metadata)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	log.Debug().Str("Func", "Copy").
+		Str("Workspace", workspace.Name).
+		Str("Key", srcKey).
+		Str("Destination", dstKey).
+		Bool("Move", move).
+		Send()
+	return nil
+}
+
+// Usage sums the size and count of the objects in the workspace
+func (self *FilesystemManager) Usage(cx *SessionContext, workspaceIn string) (*WorkspaceUsage, error) {
+	return workspaceUsage(self, self.config, cx, workspaceIn)
+}
+
+// DeletePrefix deletes every object file under the given folder
+// prefix, and the folders left empty
+func (self *FilesystemManager) DeletePrefix(cx *SessionContext, workspaceIn string, prefix string) (*DeletePrefixResult, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	result, err := deletePrefix(self, self.config, cx, workspaceIn, prefix)
+	if err != nil {
+		return nil, err
+	}
+	// remove the folders emptied by the delete
+	s3path, _ := workspace.Path(prefix)
+	root := self.filePath(s3path)
+	dirs := []string{}
+	filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if nil == err && entry.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	for ix := len(dirs) - 1; ix >= 0; ix-- {
+		os.Remove(dirs[ix])
+	}
+	if s3prefix, err := workspace.Path(""); nil == err {
+		self.pruneEmptyDirs(s3prefix, root)
+	}
+	return result, nil
+}
+
+// listFolders returns every folder under the folder path in one page
+func (self *FilesystemManager) listFolders(s3path string, page string) ([]string, string, error) {
+	entries, err := os.ReadDir(self.filePath(s3path))
+	if os.IsNotExist(err) {
+		return []string{}, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	result := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			result = append(result, s3path+entry.Name()+"/")
+		}
+	}
+	return result, "", nil
+}
+
+// listTree returns every object file under the path in one page
+func (self *FilesystemManager) listTree(s3path string, page string) ([]storedObject, string, error) {
+	result := []storedObject{}
+	// the path may end part way through a file name
+	dir := s3path[:strings.LastIndex(s3path, "/")+1]
+	err := filepath.WalkDir(self.filePath(dir), func(path string, entry fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || isInternalFile(entry.Name()) {
+			return nil
+		}
+		relPath, err := filepath.Rel(self.config.RootDir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relPath)
+		if !strings.HasPrefix(key, s3path) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		result = append(result, storedObject{Path: key, SizeBytes: info.Size(), LastModified: info.ModTime().UTC()})
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, "", nil
+}
+
+func (self *FilesystemManager) putObject(s3path string, data []byte) error {
+	return self.writeBlob(s3path, bytes.NewReader(data), nil)
+}
+
+func (self *FilesystemManager) getObject(s3path string) ([]byte, error) {
+	return readBlob(self, s3path)
+}
+
+func (self *FilesystemManager) deletePaths(s3paths []string) (map[string]error, error) {
+	failures := map[string]error{}
+	for _, path := range s3paths {
+		if err := os.Remove(self.filePath(path)); nil != err && !os.IsNotExist(err) {
+			failures[path] = err
+		} else if err := writeMetadata(self.filePath(path), nil); nil != err {
+			failures[path] = err
+		}
+	}
+	return failures, nil
+}
+
+func (self *FilesystemManager) openBlob(s3path string) (io.ReadSeekCloser, time.Time, map[string]string, error) {
+	target := self.filePath(s3path)
+	// do not follow links or serve folders
+	info, err := os.Lstat(target)
+	if nil != err {
+		return nil, time.Time{}, nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, time.Time{}, nil, os.ErrNotExist
+	}
+	file, err := os.Open(target)
+	if nil != err {
+		return nil, time.Time{}, nil, err
+	}
+	return file, info.ModTime(), readMetadata(target), nil
+}
+
+// writeBlob writes to a temp file, and renames it into place,
+// so readers never see a partial upload - the old metadata
+// is removed first, so is never paired with the new data
+func (self *FilesystemManager) writeBlob(s3path string, body io.Reader, metadata map[string]string) error {
+	target := self.filePath(s3path)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); nil != err {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(target), tempFilePrefix)
+	if nil != err {
+		return err
+	}
+	_, err = io.Copy(temp, body)
+	if closeErr := temp.Close(); nil == err {
+		err = closeErr
+	}
+	if nil == err {
+		err = writeMetadata(target, nil)
+	}
+	if nil == err {
+		err = os.Rename(temp.Name(), target)
+	}
+	if nil != err {
+		os.Remove(temp.Name())
+		return err
+	}
+	return writeMetadata(target, metadata)
+}
+
+// ServeHTTP serves the signed urls generated by UploadUrl and DownloadUrl
+func (self *FilesystemManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveBlob(w, r, self.signer, self)
+}
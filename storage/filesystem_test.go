@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilesystemMgr(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	config := &Config{
+		Backend:      BackendFilesystem,
+		BucketPrefix: "ws-storage-testsuite",
+		BaseUrl:      server.URL,
+		RootDir:      t.TempDir(),
+		BlobSecret:   "frickjack",
+	}
+	mgr, err := NewFilesystemManager(config)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize filesystem manager, got: %v", err))
+		return
+	}
+	mux.Handle(BlobPathPrefix, mgr)
+	cx := NewSessionContext(testUser)
+	key := testFolder + "/sub/testObject.txt"
+	testMessage := "this is a test"
+
+	uploadUrl, err := mgr.UploadUrl(cx, "@user", key, UploadOptions{})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to generate upload url, got: %v", err))
+		return
+	}
+	req, err := http.NewRequest(http.MethodPut, uploadUrl, bytes.NewBufferString(testMessage))
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to setup upload request, got: %v", err))
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := getTestHttpClient().Do(req)
+	if nil != err || resp.StatusCode != 200 {
+		t.Error(fmt.Sprintf("failed to upload test content, got: %v, %v", resp, err))
+		return
+	}
+	resp.Body.Close()
+	filePath := filepath.Join(config.RootDir, "ws-storage-testsuite", testUser, testFolder, "sub", "testObject.txt")
+	if fileBytes, err := ioutil.ReadFile(filePath); nil != err || string(fileBytes) != testMessage {
+		t.Error(fmt.Sprintf("upload did not write %v, got: %v", filePath, err))
+		return
+	}
+
+	downloadUrl, err := mgr.DownloadUrl(cx, "@user", key, DownloadOptions{})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to generate download url, got: %v", err))
+		return
+	}
+	resp, err = getTestHttpClient().Get(downloadUrl)
+	if nil != err || resp.StatusCode != 200 {
+		t.Error(fmt.Sprintf("failed to download test content, got: %v, %v", resp, err))
+		return
+	}
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(bodyBytes) != testMessage {
+		t.Error(fmt.Sprintf("download does not match upload: %v ?= %v", string(bodyBytes), testMessage))
+		return
+	}
+
+	info, err := mgr.List(cx, "@user", testFolder + "/", "", 0)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to list folder, got: %v", err))
+		return
+	}
+	if len(info.Objects) != 0 || len(info.Prefixes) != 1 || info.Prefixes[0] != testFolder + "/sub/" {
+		t.Error(fmt.Sprintf("unexpected folder listing, got: %v", info))
+		return
+	}
+	info, err = mgr.List(cx, "@user", testFolder + "/sub/test", "", 0)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to list object, got: %v", err))
+		return
+	}
+	if len(info.Objects) != 1 || info.Objects[0].WorkspaceKey != key || info.Objects[0].SizeBytes != int64(len(testMessage)) {
+		t.Error(fmt.Sprintf("unexpected object listing, got: %v", info))
+		return
+	}
+
+	if err := mgr.Copy(cx, "@user", key, testFolder + "/copy/a/testObject.txt"); nil != err {
+		t.Error(fmt.Sprintf("failed to copy test object, got: %v", err))
+		return
+	}
+	if err := mgr.Move(cx, "@user", testFolder + "/copy/a/testObject.txt", testFolder + "/moved.txt"); nil != err {
+		t.Error(fmt.Sprintf("failed to move test object, got: %v", err))
+		return
+	}
+	if _, err := os.Stat(filepath.Join(config.RootDir, "ws-storage-testsuite", testUser, testFolder, "copy")); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("move should remove empty folders, got: %v", err))
+		return
+	}
+	if stat, err := mgr.Stat(cx, "@user", testFolder + "/moved.txt"); nil != err || stat.SizeBytes != int64(len(testMessage)) {
+		t.Error(fmt.Sprintf("unexpected moved object, got: %v, %v", stat, err))
+		return
+	}
+	if err := mgr.Copy(cx, "@user", testFolder + "/moved.txt", testFolder + "/copy/a/b/c.txt"); nil != err {
+		t.Error(fmt.Sprintf("failed to copy test object, got: %v", err))
+		return
+	}
+	if result, err := mgr.DeletePrefix(cx, "@user", testFolder + "/copy/"); nil != err || result.DeletedCount != 1 {
+		t.Error(fmt.Sprintf("unexpected prefix delete, got: %v, %v", result, err))
+		return
+	}
+	if _, err := os.Stat(filepath.Join(config.RootDir, "ws-storage-testsuite", testUser, testFolder, "copy")); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("prefix delete should remove empty folders, got: %v", err))
+		return
+	}
+	mgr.DeleteObject(cx, "@user", testFolder + "/moved.txt")
+
+	stat, err := mgr.Stat(cx, "@user", key)
+	if nil != err || stat.SizeBytes != int64(len(testMessage)) || "" == stat.ETag || "text/plain; charset=utf-8" != stat.ContentType {
+		t.Error(fmt.Sprintf("unexpected stat, got: %v, %v", stat, err))
+		return
+	}
+	if _, err := mgr.Stat(cx, "@user", testFolder + "/sub"); !errors.Is(err, ErrNotFound) {
+		t.Error(fmt.Sprintf("stat of a folder should be not found, got: %v", err))
+		return
+	}
+
+	if err := mgr.DeleteObject(cx, "@user", key); nil != err {
+		t.Error(fmt.Sprintf("failed to delete test object, got: %v", err))
+		return
+	}
+	if _, err := os.Stat(filepath.Join(config.RootDir, "ws-storage-testsuite", testUser, testFolder)); !os.IsNotExist(err) {
+		t.Error(fmt.Sprintf("delete should remove empty folders, got: %v", err))
+		return
+	}
+	if _, err := os.Stat(filepath.Join(config.RootDir, "ws-storage-testsuite", testUser)); nil != err {
+		t.Error(fmt.Sprintf("delete should not remove the workspace folder, got: %v", err))
+		return
+	}
+	resp, err = getTestHttpClient().Get(downloadUrl)
+	if nil != err || resp.StatusCode != 404 {
+		t.Error(fmt.Sprintf("deleted object should not download, got: %v, %v", resp, err))
+		return
+	}
+	resp.Body.Close()
+}
+
+func TestFilesystemReservedKeys(t *testing.T) {
+	mgr, err := NewFilesystemManager(&Config{Backend: BackendFilesystem, BucketPrefix: "ws-storage-testsuite", RootDir: t.TempDir()})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize filesystem manager, got: %v", err))
+		return
+	}
+	cx := NewSessionContext(testUser)
+	if err := mgr.writeBlob("ws-storage-testsuite/"+testUser+"/dir/x", bytes.NewBufferString("a,b,c"), testChecksum("md5", "a,b,c").metadata()); nil != err {
+		t.Error(fmt.Sprintf("failed to write dir/x, got: %v", err))
+		return
+	}
+	for _, key := range []string{"dir/" + metaFilePrefix + "x", tempFilePrefix + "x/y"} {
+		if _, err := mgr.UploadUrl(cx, "@user", key, UploadOptions{}); !errors.Is(err, ErrInvalidInput) {
+			t.Error(fmt.Sprintf("expected upload of reserved key %v to fail, got: %v", key, err))
+		}
+		if err := mgr.Copy(cx, "@user", "dir/x", key); !errors.Is(err, ErrInvalidInput) {
+			t.Error(fmt.Sprintf("expected copy to reserved key %v to fail, got: %v", key, err))
+		}
+		if err := mgr.Move(cx, "@user", key, "dir/y"); !errors.Is(err, ErrInvalidInput) {
+			t.Error(fmt.Sprintf("expected move from reserved key %v to fail, got: %v", key, err))
+		}
+	}
+	if stat, err := mgr.Stat(cx, "@user", "dir/x"); nil != err || nil == stat.Checksum || "md5" != stat.Checksum.Algorithm {
+		t.Error(fmt.Sprintf("unexpected dir/x checksum, got: %v, %v", stat, err))
+	}
+}
+
+func TestFilesystemMoveExclusive(t *testing.T) {
+	mgr, err := NewFilesystemManager(&Config{Backend: BackendFilesystem, BucketPrefix: "ws-storage-testsuite", RootDir: t.TempDir()})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize filesystem manager, got: %v", err))
+		return
+	}
+	cx := NewSessionContext(testUser)
+	mgr.writeBlob("ws-storage-testsuite/"+testUser+"/x", bytes.NewBufferString("a,b,c"), testChecksum("md5", "a,b,c").metadata())
+	mgr.writeBlob("ws-storage-testsuite/"+testUser+"/y", bytes.NewBufferString("new"), nil)
+	if err := mgr.moveExclusive(cx, "@user", "x", "y"); !errors.Is(err, ErrConflict) {
+		t.Error(fmt.Sprintf("expected move onto an existing object to conflict, got: %v", err))
+		return
+	}
+	if stat, err := mgr.Stat(cx, "@user", "y"); nil != err || 3 != stat.SizeBytes {
+		t.Error(fmt.Sprintf("a conflicting move should keep the destination, got: %v, %v", stat, err))
+		return
+	}
+	if err := mgr.moveExclusive(cx, "@user", "x", "sub/z"); nil != err {
+		t.Error(fmt.Sprintf("failed to move x, got: %v", err))
+		return
+	}
+	if _, err := mgr.Stat(cx, "@user", "x"); !errors.Is(err, ErrNotFound) {
+		t.Error(fmt.Sprintf("expected the moved source to be gone, got: %v", err))
+	}
+	if stat, err := mgr.Stat(cx, "@user", "sub/z"); nil != err || nil == stat.Checksum {
+		t.Error(fmt.Sprintf("unexpected moved object, got: %v, %v", stat, err))
+	}
+}
@@ -0,0 +1,633 @@
+package storage
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultGCSEndpoint is the Google Cloud Storage XML API endpoint
+const DefaultGCSEndpoint = "https://storage.googleapis.com"
+
+// GCSManager is a Manager backed by a Google Cloud Storage bucket.
+// Every request - including list and delete - is a V4 signed
+// XML API request signed with a service account key, so the
+// manager needs no other Google client libraries.
+//   https://cloud.google.com/storage/docs/access-control/signing-urls-manually
+type GCSManager struct {
+	config     *Config
+	endpoint   *url.URL
+	email      string
+	key        *rsa.PrivateKey
+	httpClient *http.Client
+}
+
+// gcsServiceAccount is the subset of a service account key file we use
+type gcsServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+}
+
+// gcsListResult is the XML API list objects response
+type gcsListResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+}
+
+// NewGCSManager makes a new GCS manager with the given configuration.
+// The service account key file comes from the gcscredentials config,
+// or the GOOGLE_APPLICATION_CREDENTIALS environment variable.
+func NewGCSManager(config *Config) (*GCSManager, error) {
+	keyPath := config.GCSCredentials
+	if "" == keyPath {
+		keyPath = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if "" == keyPath {
+		return nil, fmt.Errorf("gcs backend requires gcscredentials in config or GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	keyBytes, err := ioutil.ReadFile(keyPath)
+	if nil != err {
+		return nil, err
+	}
+	account := &gcsServiceAccount{}
+	if err := json.Unmarshal(keyBytes, account); nil != err {
+		return nil, fmt.Errorf("failed to parse service account key %v - %v", keyPath, err)
+	}
+	key, err := parseRSAPrivateKey(account.PrivateKey)
+	if nil != err {
+		return nil, fmt.Errorf("failed to parse service account key %v - %v", keyPath, err)
+	}
+	if "" == account.ClientEmail {
+		return nil, fmt.Errorf("no client_email in service account key %v", keyPath)
+	}
+	endpointStr := config.Endpoint
+	if "" == endpointStr {
+		endpointStr = DefaultGCSEndpoint
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(endpointStr, "/"))
+	if nil != err {
+		return nil, fmt.Errorf("invalid endpoint %v - %v", endpointStr, err)
+	}
+	return &GCSManager{
+		config:     config,
+		endpoint:   endpoint,
+		email:      account.ClientEmail,
+		key:        key,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// parseRSAPrivateKey decodes a PEM encoded PKCS8 or PKCS1 RSA key
+func parseRSAPrivateKey(pemStr string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if nil == block {
+		return nil, fmt.Errorf("no PEM private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); nil == err {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if nil != err {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// uriEscape percent encodes everything but the RFC 3986
+// unreserved characters - and optionally slash -
+// as cloud storage request signing requires
+func uriEscape(value string, keepSlash bool) string {
+	var builder strings.Builder
+	for _, b := range []byte(value) {
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') ||
+			b == '-' || b == '.' || b == '_' || b == '~' || (keepSlash && b == '/') {
+			builder.WriteByte(b)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
+
+// gcsCanonicalQuery sorts and encodes query parameters
+func gcsCanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := []string{}
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, uriEscape(key, false)+"="+uriEscape(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// gcsSignedHeaders lists the (lower case) names of the given
+// signed headers in canonical order
+func gcsSignedHeaders(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// gcsStringToSign builds the V4 string to sign for a request
+// that signs the given headers - host and any x-goog-* headers
+// keyed by lower case name
+func gcsStringToSign(method string, escapedPath string, query url.Values, headers map[string]string, timestamp string, scope string) string {
+	names := gcsSignedHeaders(headers)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + strings.TrimSpace(headers[name]) + "\n"
+	}
+	canonicalRequest := strings.Join([]string{
+		method,
+		escapedPath,
+		gcsCanonicalQuery(query),
+		canonicalHeaders,
+		strings.Join(names, ";"),
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{
+		"GOOG4-RSA-SHA256",
+		timestamp,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+}
+
+// signUrl generates a V4 signed url for the given method on the
+// given object path (empty for the bucket) with extra query parameters
+func (self *GCSManager) signUrl(method string, s3path string, extraQuery url.Values, ttl time.Duration) (string, error) {
+	return self.signHeadersUrl(method, s3path, extraQuery, nil, ttl)
+}
+
+// signHeadersUrl is signUrl for a request that also carries
+// the given headers, which must be signed - x-goog-* headers,
+// or headers like content-length that constrain the request
+func (self *GCSManager) signHeadersUrl(method string, s3path string, extraQuery url.Values, extraHeaders map[string]string, ttl time.Duration) (string, error) {
+	headers := map[string]string{"host": self.endpoint.Host}
+	for name, value := range extraHeaders {
+		headers[strings.ToLower(name)] = value
+	}
+	now := time.Now().UTC()
+	timestamp := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/auto/storage/goog4_request"
+	query := url.Values{}
+	for key, values := range extraQuery {
+		query[key] = values
+	}
+	query.Set("X-Goog-Algorithm", "GOOG4-RSA-SHA256")
+	query.Set("X-Goog-Credential", self.email+"/"+scope)
+	query.Set("X-Goog-Date", timestamp)
+	query.Set("X-Goog-Expires", strconv.Itoa(int(ttl.Seconds())))
+	query.Set("X-Goog-SignedHeaders", strings.Join(gcsSignedHeaders(headers), ";"))
+
+	escapedPath := strings.TrimSuffix(self.endpoint.EscapedPath(), "/") + "/" + uriEscape(self.config.Bucket, false)
+	if "" != s3path {
+		escapedPath += "/" + uriEscape(s3path, true)
+	}
+	stringToSign := gcsStringToSign(method, escapedPath, query, headers, timestamp, scope)
+	hash := sha256.Sum256([]byte(stringToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, self.key, crypto.SHA256, hash[:])
+	if nil != err {
+		return "", err
+	}
+	return self.endpoint.Scheme + "://" + self.endpoint.Host + escapedPath + "?" +
+		gcsCanonicalQuery(query) + "&X-Goog-Signature=" + hex.EncodeToString(signature), nil
+}
+
+// listPage fetches a page of the XML API object listing
+// under the given path - delimiter may be empty to list recursively
+func (self *GCSManager) listPage(s3path string, delimiter string, page string, limit int) (*gcsListResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", s3path)
+	if "" != delimiter {
+		query.Set("delimiter", delimiter)
+	}
+	query.Set("max-keys", strconv.Itoa(limit))
+	if page != "" {
+		query.Set("continuation-token", page)
+	}
+	listUrl, err := self.signUrl(http.MethodGet, "", query, 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := self.httpClient.Get(listUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusErrorf(resp.StatusCode, "gcs list failed with status %v - %v", resp.StatusCode, string(body))
+	}
+	listing := &gcsListResult{}
+	if err := xml.Unmarshal(body, listing); err != nil {
+		return nil, fmt.Errorf("failed to parse gcs list response - %v", err)
+	}
+	return listing, nil
+}
+
+// List the prefixes and objects under a given workspace and prefix
+func (self *GCSManager) List(cx *SessionContext, workspaceIn string, prefix string, page string, limit int) (*ListResult, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 0 || limit > MaxListLimit {
+		return nil, invalidInputf("invalid limit - must be between 0 and %v, got %v", MaxListLimit, limit)
+	}
+	if limit == 0 {
+		limit = MaxListLimit
+	}
+	s3path, err := workspace.Path(prefix)
+	if err != nil {
+		return nil, err
+	}
+	s3prefix, err := workspace.Path("")
+	if err != nil {
+		return nil, err
+	}
+	listing, err := self.listPage(s3path, "/", page, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ListResult{
+		Workspace: workspace.Name,
+		Prefix:    prefix,
+		Objects:   make([]ObjectInfo, len(listing.Contents)),
+		Prefixes:  make([]string, len(listing.CommonPrefixes)),
+	}
+	for ix, item := range listing.Contents {
+		result.Objects[ix] = ObjectInfo{
+			Workspace:    workspace.Name,
+			WorkspaceKey: strings.Replace(item.Key, s3prefix, "", 1),
+			SizeBytes:    item.Size,
+			LastModified: item.LastModified,
+		}
+	}
+	for ix, item := range listing.CommonPrefixes {
+		result.Prefixes[ix] = strings.Replace(item.Prefix, s3prefix, "", 1)
+	}
+	if listing.IsTruncated {
+		result.NextPage = listing.NextContinuationToken
+	}
+	return result, nil
+}
+
+// UploadUrl generates a V4 signed upload url
+func (self *GCSManager) UploadUrl(cx *SessionContext, workspaceIn string, key string, options UploadOptions) (string, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return "", err
+	}
+	if err := validateUploadOptions(self.config, options); err != nil {
+		return "", err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return "", err
+	}
+	log.Debug().Str("Func", "UploadUrl").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Send()
+	headers := gcsUploadHeaders(key, options)
+	if options.SizeBytes > 0 {
+		headers["content-length"] = strconv.FormatInt(options.SizeBytes, 10)
+	}
+	return self.signHeadersUrl(http.MethodPut, s3path, nil, headers, urlTtl(self.config, options.Expires))
+}
+
+// gcsUploadHeaders are the headers other than content-length
+// that UploadUrl signs
+func gcsUploadHeaders(key string, options UploadOptions) map[string]string {
+	headers := map[string]string{"content-type": options.ContentTypeOrDefault(key)}
+	if nil != options.Checksum {
+		headers["x-goog-meta-"+options.Checksum.metadataKey()] = options.Checksum.Value
+		// GCS rejects data that does not match a signed md5 or crc32c -
+		// it does not verify sha256
+		switch options.Checksum.Algorithm {
+		case "md5":
+			headers["content-md5"] = options.Checksum.Value
+		case "crc32c":
+			headers["x-goog-hash"] = "crc32c=" + options.Checksum.Value
+		}
+	}
+	return headers
+}
+
+// uploadHeaders are the headers UploadUrl signs - a proxied
+// upload sends them with the upload url
+func (self *GCSManager) uploadHeaders(key string, options UploadOptions) http.Header {
+	header := http.Header{}
+	for name, value := range gcsUploadHeaders(key, options) {
+		header.Set(name, value)
+	}
+	return header
+}
+
+// DownloadUrl generates a V4 signed download url -
+// supports the range HTTP header
+func (self *GCSManager) DownloadUrl(cx *SessionContext, workspaceIn string, key string, options DownloadOptions) (string, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return "", err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return "", err
+	}
+	log.Debug().Str("Func", "DownloadUrl").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Send()
+	var query url.Values
+	if "" != options.ContentDisposition {
+		query = url.Values{"response-content-disposition": []string{options.ContentDisposition}}
+	}
+	return self.signUrl(http.MethodGet, s3path, query, urlTtl(self.config, options.Expires))
+}
+
+// DeleteObject removes the given object - deleting
+// an object that does not exist is not an error
+func (self *GCSManager) DeleteObject(cx *SessionContext, workspaceIn string, key string) error {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return err
+	}
+	log.Debug().Str("Func", "DeleteObject").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Send()
+	return self.deletePath(s3path)
+}
+
+// deletePath deletes the object at the given path -
+// deleting an object that does not exist is not an error
+func (self *GCSManager) deletePath(s3path string) error {
+	deleteUrl, err := self.signUrl(http.MethodDelete, s3path, nil, 5*time.Minute)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodDelete, deleteUrl, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := self.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return statusErrorf(resp.StatusCode, "gcs delete failed with status %v - %v", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// Stat returns the metadata of the given object from a signed HEAD request
+func (self *GCSManager) Stat(cx *SessionContext, workspaceIn string, key string) (*ObjectStat, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return nil, err
+	}
+	headUrl, err := self.signUrl(http.MethodHead, s3path, nil, 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := self.httpClient.Head(headUrl)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w - %v", ErrNotFound, key)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusErrorf(resp.StatusCode, "gcs stat failed with status %v", resp.StatusCode)
+	}
+	return headerObjectStat(workspace.Name, key, resp.Header, "x-goog-meta-", "x-goog-storage-class"), nil
+}
+
+// Copy copies the source object to the destination key
+// with an XML API copy request
+func (self *GCSManager) Copy(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	return self.copyObject(cx, workspaceIn, srcKey, dstKey, false)
+}
+
+// moveExclusive moves the object if the destination does not exist -
+// the copy is conditional on the destination's generation being 0
+func (self *GCSManager) moveExclusive(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	if err := self.copyObject(cx, workspaceIn, srcKey, dstKey, true); nil != err {
+		return err
+	}
+	return self.DeleteObject(cx, workspaceIn, srcKey)
+}
+
+func (self *GCSManager) copyObject(cx *SessionContext, workspaceIn string, srcKey string, dstKey string, exclusive bool) error {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
+	}
+	if err := validateCopyKeys(srcKey, dstKey); nil != err {
+		return err
+	}
+	srcPath, err := workspace.Path(srcKey)
+	if err != nil {
+		return err
+	}
+	dstPath, err := workspace.Path(dstKey)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		"x-goog-copy-source": "/" + uriEscape(self.config.Bucket, false) + "/" + uriEscape(srcPath, true),
+	}
+	if exclusive {
+		headers["x-goog-if-generation-match"] = "0"
+	}
+	copyUrl, err := self.signHeadersUrl(http.MethodPut, dstPath, nil, headers, 5*time.Minute)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, copyUrl, nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := self.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w - %v", ErrNotFound, srcKey)
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed && exclusive {
+		return fmt.Errorf("%w - %v exists", ErrConflict, dstKey)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return statusErrorf(resp.StatusCode, "gcs copy failed with status %v - %v", resp.StatusCode, string(body))
+	}
+	log.Debug().Str("Func", "Copy").
+		Str("Workspace", workspace.Name).
+		Str("Key", srcKey).
+		Str("Destination", dstKey).
+		Send()
+	return nil
+}
+
+// Move copies the source object to the destination key,
+// then deletes the source
+func (self *GCSManager) Move(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	return moveObject(self, cx, workspaceIn, srcKey, dstKey)
+}
+
+// Usage sums the size and count of the objects in the workspace
+func (self *GCSManager) Usage(cx *SessionContext, workspaceIn string) (*WorkspaceUsage, error) {
+	return workspaceUsage(self, self.config, cx, workspaceIn)
+}
+
+// DeletePrefix deletes every object under the given folder prefix -
+// the XML API has no batch delete, so objects are deleted one by one
+func (self *GCSManager) DeletePrefix(cx *SessionContext, workspaceIn string, prefix string) (*DeletePrefixResult, error) {
+	return deletePrefix(self, self.config, cx, workspaceIn, prefix)
+}
+
+func (self *GCSManager) listTree(s3path string, page string) ([]storedObject, string, error) {
+	listing, err := self.listPage(s3path, "", page, MaxListLimit)
+	if err != nil {
+		return nil, "", err
+	}
+	result := make([]storedObject, len(listing.Contents))
+	for ix, item := range listing.Contents {
+		result[ix] = storedObject{Path: item.Key, SizeBytes: item.Size, LastModified: item.LastModified}
+	}
+	if listing.IsTruncated {
+		return result, listing.NextContinuationToken, nil
+	}
+	return result, "", nil
+}
+
+func (self *GCSManager) listFolders(s3path string, page string) ([]string, string, error) {
+	listing, err := self.listPage(s3path, "/", page, MaxListLimit)
+	if err != nil {
+		return nil, "", err
+	}
+	result := make([]string, len(listing.CommonPrefixes))
+	for ix, item := range listing.CommonPrefixes {
+		result[ix] = item.Prefix
+	}
+	if listing.IsTruncated {
+		return result, listing.NextContinuationToken, nil
+	}
+	return result, "", nil
+}
+
+func (self *GCSManager) putObject(s3path string, data []byte) error {
+	headers := map[string]string{"content-type": contentTypeByKey(s3path)}
+	putUrl, err := self.signHeadersUrl(http.MethodPut, s3path, nil, headers, 5*time.Minute)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, putUrl, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := self.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return statusErrorf(resp.StatusCode, "gcs upload failed with status %v - %v", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+func (self *GCSManager) getObject(s3path string) ([]byte, error) {
+	getUrl, err := self.signUrl(http.MethodGet, s3path, nil, 5*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := self.httpClient.Get(getUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w - %v", ErrNotFound, s3path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusErrorf(resp.StatusCode, "gcs download failed with status %v", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (self *GCSManager) deletePaths(s3paths []string) (map[string]error, error) {
+	failures := map[string]error{}
+	for _, path := range s3paths {
+		if err := self.deletePath(path); nil != err {
+			failures[path] = err
+		}
+	}
+	return failures, nil
+}
@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGCS is a minimal XML API server that verifies V4 signatures
+type fakeGCS struct {
+	bucket  string
+	key     *rsa.PublicKey
+	objects map[string][]byte
+}
+
+func (self *fakeGCS) verify(r *http.Request) error {
+	query := r.URL.Query()
+	signature, err := hex.DecodeString(query.Get("X-Goog-Signature"))
+	if nil != err {
+		return err
+	}
+	query.Del("X-Goog-Signature")
+	credential := query.Get("X-Goog-Credential")
+	scope := credential[strings.Index(credential, "/")+1:]
+	headers := map[string]string{}
+	for _, name := range strings.Split(query.Get("X-Goog-SignedHeaders"), ";") {
+		headers[name] = r.Header.Get(name)
+	}
+	headers["host"] = r.Host
+	stringToSign := gcsStringToSign(r.Method, r.URL.EscapedPath(), query, headers, query.Get("X-Goog-Date"), scope)
+	hash := sha256.Sum256([]byte(stringToSign))
+	return rsa.VerifyPKCS1v15(self.key, crypto.SHA256, hash[:], signature)
+}
+
+func (self *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := self.verify(r); nil != err {
+		http.Error(w, "bad signature: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	bucketPath := "/" + self.bucket
+	if r.URL.Path == bucketPath && r.Method == http.MethodGet {
+		prefix := r.URL.Query().Get("prefix")
+		keys := []string{}
+		for key := range self.objects {
+			keys = append(keys, key)
+		}
+		objects, prefixes, _ := listEntries(keys, prefix, "", MaxListLimit)
+		if "" == r.URL.Query().Get("delimiter") {
+			objects, prefixes = []string{}, nil
+			for _, key := range keys {
+				if strings.HasPrefix(key, prefix) {
+					objects = append(objects, key)
+				}
+			}
+		}
+		listing := gcsListResult{}
+		for _, key := range objects {
+			listing.Contents = append(listing.Contents, struct {
+				Key          string    `xml:"Key"`
+				LastModified time.Time `xml:"LastModified"`
+				Size         int64     `xml:"Size"`
+			}{key, time.Now().UTC(), int64(len(self.objects[key]))})
+		}
+		for _, prefix := range prefixes {
+			listing.CommonPrefixes = append(listing.CommonPrefixes, struct {
+				Prefix string `xml:"Prefix"`
+			}{prefix})
+		}
+		body, _ := xml.Marshal(listing)
+		w.Write(body)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, bucketPath+"/")
+	switch r.Method {
+	case http.MethodPut:
+		if source := r.Header.Get("x-goog-copy-source"); "" != source {
+			srcKey, _ := url.PathUnescape(strings.TrimPrefix(source, bucketPath+"/"))
+			data, ok := self.objects[srcKey]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			self.objects[key] = data
+			return
+		}
+		self.objects[key], _ = ioutil.ReadAll(r.Body)
+	case http.MethodGet, http.MethodHead:
+		data, ok := self.objects[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprintf("%v", len(data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"fakeETag"`)
+		w.Header().Set("x-goog-storage-class", "NEARLINE")
+		w.Header().Set("x-goog-meta-owner", testUser)
+		if disposition := r.URL.Query().Get("response-content-disposition"); "" != disposition {
+			w.Header().Set("Content-Disposition", disposition)
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(self.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestUriEscape(t *testing.T) {
+	testCases := [][]string{
+		{"abc/def ghi~+", "abc/def%20ghi~%2B"},
+		{"a/b", "a/b"},
+	}
+	for _, it := range testCases {
+		if escaped := uriEscape(it[0], true); escaped != it[1] {
+			t.Error(fmt.Sprintf("unexpected escape of %v: %v != %v", it[0], escaped, it[1]))
+			return
+		}
+	}
+	if escaped := uriEscape("a/b", false); escaped != "a%2Fb" {
+		t.Error(fmt.Sprintf("unexpected escape of a/b: %v", escaped))
+		return
+	}
+}
+
+func TestGCSMgr(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to generate key, got: %v", err))
+		return
+	}
+	keyBytes, _ := x509.MarshalPKCS8PrivateKey(key)
+	accountBytes, _ := json.Marshal(gcsServiceAccount{
+		ClientEmail: "ws-storage@frickjack.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})),
+	})
+	keyPath := filepath.Join(t.TempDir(), "key.json")
+	if err := ioutil.WriteFile(keyPath, accountBytes, 0600); nil != err {
+		t.Error(fmt.Sprintf("failed to write key file, got: %v", err))
+		return
+	}
+	fake := &fakeGCS{bucket: "ws-storage-test", key: &key.PublicKey, objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	mgr, err := NewGCSManager(&Config{
+		Backend:        BackendGCS,
+		Bucket:         "ws-storage-test",
+		BucketPrefix:   "ws-storage-testsuite",
+		Endpoint:       server.URL,
+		GCSCredentials: keyPath,
+	})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize gcs manager, got: %v", err))
+		return
+	}
+	cx := NewSessionContext(testUser)
+	objKey := testFolder + "/test Object.txt"
+	testMessage := "this is a test"
+
+	uploadUrl, err := mgr.UploadUrl(cx, "@user", objKey, UploadOptions{ContentType: contentTypeByKey(objKey)})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to generate upload url, got: %v", err))
+		return
+	}
+	// the content type is signed
+	req, _ := http.NewRequest(http.MethodPut, uploadUrl, bytes.NewBufferString(testMessage))
+	req.Header.Set("Content-Type", "text/html")
+	resp, err := getTestHttpClient().Do(req)
+	if nil != err || resp.StatusCode != http.StatusForbidden {
+		t.Error(fmt.Sprintf("upload with an unsigned content type should fail, got: %v, %v", resp, err))
+		return
+	}
+	resp.Body.Close()
+	req, _ = http.NewRequest(http.MethodPut, uploadUrl, bytes.NewBufferString(testMessage))
+	req.Header.Set("Content-Type", contentTypeByKey(objKey))
+	resp, err = getTestHttpClient().Do(req)
+	if nil != err || resp.StatusCode != 200 {
+		t.Error(fmt.Sprintf("failed to upload test content, got: %v, %v", resp, err))
+		return
+	}
+	resp.Body.Close()
+
+	disposition := `attachment; filename="x.txt"`
+	downloadUrl, err := mgr.DownloadUrl(cx, "@user", objKey, DownloadOptions{ContentDisposition: disposition})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to generate download url, got: %v", err))
+		return
+	}
+	resp, err = getTestHttpClient().Get(downloadUrl)
+	if nil != err || resp.StatusCode != 200 || resp.Header.Get("Content-Disposition") != disposition {
+		t.Error(fmt.Sprintf("failed to download test content, got: %v, %v", resp, err))
+		return
+	}
+	bodyBytes, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(bodyBytes) != testMessage {
+		t.Error(fmt.Sprintf("download does not match upload: %v ?= %v", string(bodyBytes), testMessage))
+		return
+	}
+
+	info, err := mgr.List(cx, "@user", "", "", 0)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to list workspace, got: %v", err))
+		return
+	}
+	if len(info.Prefixes) != 1 || info.Prefixes[0] != testFolder+"/" {
+		t.Error(fmt.Sprintf("unexpected workspace listing, got: %v", info))
+		return
+	}
+	info, err = mgr.List(cx, "@user", testFolder+"/", "", 0)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to list folder, got: %v", err))
+		return
+	}
+	if len(info.Objects) != 1 || info.Objects[0].WorkspaceKey != objKey || info.Objects[0].SizeBytes != int64(len(testMessage)) {
+		t.Error(fmt.Sprintf("unexpected folder listing, got: %v", info))
+		return
+	}
+
+	if err := mgr.Copy(cx, "@user", objKey, objKey+".copy"); nil != err || string(fake.objects["ws-storage-testsuite/goTestUser/"+objKey+".copy"]) != testMessage {
+		t.Error(fmt.Sprintf("failed to copy test object, got: %v", err))
+		return
+	}
+	if err := mgr.Move(cx, "@user", objKey+".copy", objKey+".moved"); nil != err || len(fake.objects) != 2 {
+		t.Error(fmt.Sprintf("failed to move test object, got: %v, %v", err, fake.objects))
+		return
+	}
+	if err := mgr.Copy(cx, "@user", objKey+".copy", objKey+".again"); !errors.Is(err, ErrNotFound) {
+		t.Error(fmt.Sprintf("expected not found copying a moved object, got: %v", err))
+		return
+	}
+	mgr.DeleteObject(cx, "@user", objKey+".moved")
+
+	stat, err := mgr.Stat(cx, "@user", objKey)
+	if nil != err || stat.SizeBytes != int64(len(testMessage)) || stat.ETag != "fakeETag" ||
+		stat.StorageClass != "NEARLINE" || stat.Metadata["owner"] != testUser || stat.LastModified.IsZero() {
+		t.Error(fmt.Sprintf("unexpected stat, got: %v, %v", stat, err))
+		return
+	}
+
+	if err := mgr.DeleteObject(cx, "@user", objKey); nil != err {
+		t.Error(fmt.Sprintf("failed to delete test object, got: %v", err))
+		return
+	}
+	if len(fake.objects) != 0 {
+		t.Error(fmt.Sprintf("object not deleted, got: %v", fake.objects))
+		return
+	}
+	if _, err := mgr.Stat(cx, "@user", objKey); !errors.Is(err, ErrNotFound) {
+		t.Error(fmt.Sprintf("expected not found for deleted object, got: %v", err))
+		return
+	}
+
+	for _, key := range []string{"a", "sub/b", "sub/deeper/c"} {
+		fake.objects["ws-storage-testsuite/goTestUser/"+testFolder+"/"+key] = []byte(testMessage)
+	}
+	fake.objects["ws-storage-testsuite/goTestUser/"+testFolder+"Sibling"] = []byte(testMessage)
+	if result, err := mgr.DeletePrefix(cx, "@user", testFolder+"/"); nil != err || result.DeletedCount != 3 || len(fake.objects) != 1 {
+		t.Error(fmt.Sprintf("unexpected prefix delete, got: %v, %v", result, err))
+		return
+	}
+
+	recordPath := "ws-storage-testsuite/goTestUser/" + testFolder + "/record.json"
+	if err := mgr.putObject(recordPath, []byte(testMessage)); nil != err || string(fake.objects[recordPath]) != testMessage {
+		t.Error(fmt.Sprintf("failed to put object, got: %v", err))
+		return
+	}
+	if data, err := mgr.getObject(recordPath); nil != err || string(data) != testMessage {
+		t.Error(fmt.Sprintf("failed to get object, got: %v, %v", string(data), err))
+		return
+	}
+	if _, err := mgr.getObject(recordPath + ".missing"); !errors.Is(err, ErrNotFound) {
+		t.Error(fmt.Sprintf("expected not found getting a missing object, got: %v", err))
+	}
+}
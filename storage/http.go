@@ -3,8 +3,11 @@ package storage
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,23 +18,113 @@ import (
 // Config package-global shared storage config
 var mgrSingleton Manager = nil;
 
+// authzSingleton decides access to workspaces
+var authzSingleton Authorizer = nil;
+
+// authnSingleton identifies the user making a request
+var authnSingleton Authenticator = nil;
+
+// quotaSingleton enforces workspace quotas
+var quotaSingleton *QuotaEnforcer = nil;
+
+// trashSingleton turns deletes into moves to the trash - nil if the trash is not enabled
+var trashSingleton *TrashBin = nil;
+
+// auditSingleton stores the audit record of each api request - nil if auditing is not configured
+var auditSingleton AuditSink = nil;
+
+// proxySingleton streams proxied uploads and downloads - nil if proxy mode is not enabled
+var proxySingleton *ObjectProxy = nil;
+
+// resultNotFound is the api Result when the requested object does not exist
+const resultNotFound = "not found"
+
+// maxRequestBodyBytes limits the size of json request bodies
+const maxRequestBodyBytes = 1 << 20
+
 // SetupHttpListeners setup endpoints with the http engine
-func SetupHttpListeners(mgr Manager) (error) {
+func SetupHttpListeners(mgr Manager, authz Authorizer, authn Authenticator, quota *QuotaEnforcer, trash *TrashBin, audit AuditSink, proxy *ObjectProxy) (error) {
 	if nil != mgrSingleton {
 		return fmt.Errorf("http listeners already configured")
 	}
 	mgrSingleton = mgr;
+	authzSingleton = authz;
+	authnSingleton = authn;
+	quotaSingleton = quota;
+	trashSingleton = trash;
+	auditSingleton = audit;
+	proxySingleton = proxy;
 
-	http.HandleFunc("/ws-storage/", apiHandler)
-	http.HandleFunc("/ws-storage/healthy", healthyHandler)
-	http.HandleFunc("/ws-storage/info", infoHandler)
+	for pattern, handler := range httpRoutes(mgr) {
+		http.Handle(pattern, handler)
+	}
 	return nil
 }
 
+// httpRoutes maps each endpoint pattern to its handler -
+// openapi.json must describe each
+func httpRoutes(mgr Manager) map[string]http.Handler {
+	result := map[string]http.Handler{
+		"/ws-storage/":        http.HandlerFunc(apiHandler),
+		"/ws-storage/healthy": http.HandlerFunc(healthyHandler),
+		"/ws-storage/info":    http.HandlerFunc(infoHandler),
+		OpenApiPath:           http.HandlerFunc(openApiHandler),
+	}
+	// backends without their own object server serve signed urls here
+	if handler, ok := mgr.(http.Handler); ok {
+		result[BlobPathPrefix] = handler
+	}
+	return result
+}
+
 type ApiRequest struct {
 	Verb       string
 	Workspace  string
 	Key        string
+	// Page is the list continuation token from the page query parameter
+	Page       string
+	// Limit is the list page size from the limit query parameter
+	Limit      int
+	// UploadId identifies a multipart upload
+	UploadId   string
+	// PartNumber is the multipart upload part to presign
+	PartNumber int64
+	// Parts lists the uploaded parts to complete a multipart upload
+	Parts      []CompletedPart
+	// SizeBytes is the declared size of an upload from the size query parameter
+	SizeBytes  int64
+	// Destination is the copy or move destination key
+	// from the destination query parameter
+	Destination string
+	// ContentType is the upload content type from the contentType query parameter
+	ContentType string
+	// ContentDisposition is the download response content disposition
+	// from the contentDisposition query parameter
+	ContentDisposition string
+	// VersionId is the object version to download or restore
+	// from the versionId query parameter
+	VersionId  string
+	// Checksum is the declared digest of an upload from the
+	// checksumAlgorithm and checksum query parameters -
+	// or the contentMD5 query parameter
+	Checksum   *Checksum
+	// Checksums asks a list request to include the
+	// checksum of each object (checksums=true)
+	Checksums  bool
+	// Expires is the requested presigned url lifetime
+	// from the expires query parameter (seconds)
+	Expires    time.Duration
+	// Proxy streams the object data through ws-storage rather
+	// than returning a presigned url - an upload PUT, or a
+	// download with proxy=true
+	Proxy      bool
+	// Body is the data of a proxied upload
+	Body       io.Reader
+	// Header carries the range and conditional headers of a proxied download
+	Header     http.Header
+	// recordDecision stores the audit record of the decision to
+	// run a mutating request before it runs - nil if not audited
+	recordDecision func() error
 	Cx         *SessionContext
 }
 
@@ -42,13 +135,53 @@ type ApiResult struct {
 	Method     string
 	Result     string
 	Data       ApiResultData
+	// Error classifies a failed request - nil on success
+	Error      *ApiError `json:",omitempty"`
+}
+
+// StatusCode is the http status of the result -
+// a proxied download has the status of its stream
+func (self *ApiResult) StatusCode() int {
+	if nil != self.Error {
+		return self.Error.StatusCode()
+	}
+	if stream, ok := self.Data.(*ObjectStream); ok {
+		return stream.StatusCode
+	}
+	return http.StatusOK
+}
+
+// newUnauthorizedResult is the result of a request that failed authentication
+func newUnauthorizedResult() *ApiResult {
+	return &ApiResult{
+		Version: 1,
+		Result: "unauthorized",
+		Error: &ApiError{Code: ErrorCodeUnauthorized, Message: "authentication failed"},
+	}
+}
+
+// newErrorResult is the result of a request that failed with err
+func newErrorResult(verb string, err error) *ApiResult {
+	result := &ApiResult{
+		Version: 1,
+		Method: verb,
+		Result: fmt.Sprintf("error - %v", err.Error()),
+		Error: newApiError(err),
+	}
+	if ErrorCodeNotFound == result.Error.Code {
+		result.Result = resultNotFound
+	}
+	return result
 }
 
 // NewApiRequest extracts the api request parameters from
 // the URL path and the remote user header.
-// urlPath should be $verb/$workspace/$key,
-// remoteUser should be set by the API gateway after verfying
-// authentication and authorization
+// urlPath should be $verb/$workspace/$key, where $workspace
+// is either @user or a named workspace $type/$name (ex: @group/lab1),
+// list and trash list requests may also carry page and limit query parameters,
+// remoteUser is the user identified by the Authenticator -
+// ex: from the REMOTE_USER header set by the API gateway after verfying
+// authentication, or from a validated bearer token
 func NewApiRequest(url *url.URL, method string, remoteUser string) (*ApiRequest, error) {
 	tokens := strings.Split(url.Path, "/")
 	if strings.HasPrefix(url.Path, "/") {
@@ -57,10 +190,10 @@ func NewApiRequest(url *url.URL, method string, remoteUser string) (*ApiRequest,
 	// remove the /ws-storage prefix
 	tokens = tokens[1:]
 	if "" == remoteUser {
-		return nil, fmt.Errorf("remote user not specified")
+		return nil, invalidInputf("remote user not specified")
 	}
 	if len(tokens) < 2 {
-		return nil, fmt.Errorf("unable to determine verb and workspace from input path")
+		return nil, invalidInputf("unable to determine verb and workspace from input path")
 	}
 	result := &ApiRequest {
 		Verb: tokens[0],
@@ -68,19 +201,242 @@ func NewApiRequest(url *url.URL, method string, remoteUser string) (*ApiRequest,
 		Key: strings.Join(tokens[2:], "/"),
 		Cx: NewSessionContext(remoteUser),
 	}
-	if result.Verb != "list" && result.Verb != "upload" && result.Verb != "download" {
-		return nil, fmt.Errorf("invalid request verb: %v", result.Verb)
+	if _, ok := apiVerbMethods[result.Verb]; !ok {
+		return nil, invalidInputf("invalid request verb: %v", result.Verb)
 	}
 	if result.Verb == "list" && method == http.MethodDelete {
 		result.Verb = "delete"
 	}
-	if result.Workspace != "@user" {
-		return nil, fmt.Errorf("currently only support @user workspace, got %v", result.Workspace)
+	if !strings.HasPrefix(result.Workspace, "@") {
+		return nil, invalidInputf("invalid workspace, got %v", result.Workspace)
+	}
+	if result.Workspace != UserWorkspace {
+		// named workspace - $type/$name
+		if len(tokens) < 3 || "" == tokens[2] {
+			return nil, invalidInputf("invalid workspace - no name for workspace type %v", result.Workspace)
+		}
+		result.Workspace = tokens[1] + "/" + tokens[2]
+		result.Key = strings.Join(tokens[3:], "/")
+	}
+	query := url.Query()
+	if result.Verb == "delete" && query.Get("recursive") == "true" {
+		result.Verb = "deleteprefix"
+	}
+	if result.Verb == "multipart" {
+		result.UploadId = query.Get("uploadId")
+		verb, err := multipartVerb(method, result.UploadId)
+		if nil != err {
+			return nil, err
+		}
+		result.Verb = verb
+		if result.Verb == "multipart-part" {
+			partNumber, err := strconv.ParseInt(query.Get("partNumber"), 10, 64)
+			if nil != err || partNumber < 1 || partNumber > MaxPartNumber {
+				return nil, invalidInputf("invalid partNumber - must be between 1 and %v, got %v", MaxPartNumber, query.Get("partNumber"))
+			}
+			result.PartNumber = partNumber
+		}
+	}
+	if result.Verb == "trash" {
+		verb, err := trashVerb(method, result.Key)
+		if nil != err {
+			return nil, err
+		}
+		result.Verb = verb
+	}
+	result.VersionId = query.Get("versionId")
+	if result.Verb == "restore" {
+		if method != http.MethodPost {
+			return nil, invalidInputf("invalid restore request method: %v", method)
+		}
+		if "" == result.Key || "" == result.VersionId {
+			return nil, invalidInputf("invalid restore request - key and versionId are required")
+		}
+	}
+	if result.Verb == "copy" || result.Verb == "move" {
+		if method != http.MethodPost {
+			return nil, invalidInputf("invalid %v request method: %v", result.Verb, method)
+		}
+		result.Destination = query.Get("destination")
+		if "" == result.Key || "" == result.Destination {
+			return nil, invalidInputf("invalid %v request - source key and destination are required", result.Verb)
+		}
+	}
+	if contentType := query.Get("contentType"); "" != contentType {
+		if _, _, err := mime.ParseMediaType(contentType); nil != err {
+			return nil, invalidInputf("invalid contentType - %v", err)
+		}
+		result.ContentType = contentType
+	}
+	if disposition := query.Get("contentDisposition"); "" != disposition {
+		if _, _, err := mime.ParseMediaType(disposition); nil != err {
+			return nil, invalidInputf("invalid contentDisposition - %v", err)
+		}
+		result.ContentDisposition = disposition
+	}
+	if sizeStr := query.Get("size"); "" != sizeStr {
+		size, err := strconv.ParseInt(sizeStr, 10, 64)
+		if nil != err || size < 0 {
+			return nil, invalidInputf("invalid size - got %v", sizeStr)
+		}
+		result.SizeBytes = size
+	}
+	if contentMD5 := query.Get("contentMD5"); "" != contentMD5 {
+		result.Checksum = &Checksum{Algorithm: "md5", Value: contentMD5}
+	}
+	if algorithm := query.Get("checksumAlgorithm"); "" != algorithm || query.Has("checksum") {
+		if nil != result.Checksum {
+			return nil, invalidInputf("invalid checksum - set contentMD5 or checksumAlgorithm and checksum, not both")
+		}
+		result.Checksum = &Checksum{Algorithm: algorithm, Value: query.Get("checksum")}
+	}
+	if nil != result.Checksum {
+		if err := result.Checksum.validate(); nil != err {
+			return nil, err
+		}
+	}
+	if query.Get("checksums") == "true" {
+		if "list" != result.Verb {
+			return nil, invalidInputf("invalid checksums - only a list request includes checksums")
+		}
+		result.Checksums = true
+	}
+	if result.Verb == "upload" && method == http.MethodPut {
+		result.Proxy = true
+	}
+	if query.Get("proxy") == "true" {
+		if "download" != result.Verb {
+			return nil, invalidInputf("invalid proxy - only a download request sets proxy, an upload is proxied with PUT")
+		}
+		result.Proxy = true
+	}
+	if expiresStr := query.Get("expires"); "" != expiresStr {
+		expires, err := strconv.ParseInt(expiresStr, 10, 64)
+		if nil != err || expires < 1 {
+			return nil, invalidInputf("invalid expires - expected seconds, got %v", expiresStr)
+		}
+		if expires > int64(maxUrlTtl/time.Second) {
+			// clamped to the configured maximum anyway
+			expires = int64(maxUrlTtl / time.Second)
+		}
+		result.Expires = time.Duration(expires) * time.Second
+	}
+	result.Page = query.Get("page")
+	if limitStr := query.Get("limit"); "" != limitStr {
+		limit, err := strconv.Atoi(limitStr)
+		if nil != err || limit < 1 || limit > MaxListLimit {
+			return nil, invalidInputf("invalid limit - must be between 1 and %v, got %v", MaxListLimit, limitStr)
+		}
+		result.Limit = limit
 	}
 	return result, nil
 }
 
-func (self *ApiRequest) HandleApiRequest(mgr Manager) (*ApiResult) {
+// apiVerbMethods maps each path verb to the http methods
+// it serves - openapi.json must describe each
+var apiVerbMethods = map[string][]string{
+	"list":      {http.MethodGet, http.MethodDelete},
+	"upload":    {http.MethodGet, http.MethodPut},
+	"download":  {http.MethodGet},
+	"stat":      {http.MethodGet},
+	"usage":     {http.MethodGet},
+	"copy":      {http.MethodPost},
+	"move":      {http.MethodPost},
+	"multipart": {http.MethodPost, http.MethodGet, http.MethodDelete},
+	"versions":  {http.MethodGet},
+	"restore":   {http.MethodPost},
+	"trash":     {http.MethodGet, http.MethodPost, http.MethodDelete},
+}
+
+// multipartVerb maps a multipart request method to its operation:
+//   POST without uploadId - create,
+//   GET with uploadId and partNumber - presign a part upload url,
+//   POST with uploadId - complete,
+//   DELETE with uploadId - abort,
+//   GET without uploadId - list in-progress uploads
+func multipartVerb(method string, uploadId string) (string, error) {
+	switch {
+	case method == http.MethodPost && uploadId == "":
+		return "multipart-create", nil
+	case method == http.MethodPost:
+		return "multipart-complete", nil
+	case method == http.MethodGet && uploadId == "":
+		return "multipart-list", nil
+	case method == http.MethodGet:
+		return "multipart-part", nil
+	case method == http.MethodDelete && uploadId != "":
+		return "multipart-abort", nil
+	}
+	return "", invalidInputf("invalid multipart request: %v with uploadId %v", method, uploadId)
+}
+
+// trashVerb maps a trash request method to its operation:
+//   GET without a key - list the trash,
+//   POST with a trash key - restore the item,
+//   DELETE with a trash key - purge the item,
+//   DELETE without a key - purge every item
+func trashVerb(method string, trashKey string) (string, error) {
+	switch {
+	case method == http.MethodGet && trashKey == "":
+		return "trash-list", nil
+	case method == http.MethodPost && trashKey != "":
+		return "trash-restore", nil
+	case method == http.MethodDelete:
+		return "trash-purge", nil
+	}
+	return "", invalidInputf("invalid trash request: %v with key %v", method, trashKey)
+}
+
+// verbActions maps each api verb to the Authorizer actions it performs
+var verbActions = map[string][]string{
+	"list":               {ActionList},
+	"download":           {ActionRead},
+	"upload":             {ActionWrite},
+	"delete":             {ActionDelete},
+	"deleteprefix":       {ActionList, ActionDelete},
+	"stat":               {ActionRead},
+	"usage":              {ActionList},
+	"copy":               {ActionRead, ActionWrite},
+	"move":               {ActionRead, ActionWrite, ActionDelete},
+	"multipart-create":   {ActionWrite},
+	"multipart-part":     {ActionWrite},
+	"multipart-complete": {ActionWrite},
+	"multipart-abort":    {ActionWrite},
+	"multipart-list":     {ActionList},
+	"versions":           {ActionList},
+	"restore":            {ActionRead, ActionWrite},
+	"trash-list":         {ActionList},
+	"trash-restore":      {ActionRead, ActionWrite},
+	"trash-purge":        {ActionDelete},
+}
+
+// authorize checks that the request's user may perform its verb on its workspace
+func (self *ApiRequest) authorize(authz Authorizer) error {
+	actions, ok := verbActions[self.Verb]
+	if !ok {
+		return invalidInputf("invalid verb %v", self.Verb)
+	}
+	if self.Checksums {
+		// checksums are derived from object data
+		actions = append([]string{ActionRead}, actions...)
+	}
+	for _, action := range actions {
+		allowed, err := authz.Authorize(self.Cx, self.Workspace, action)
+		if nil != err {
+			return unavailablef("failed to authorize %v on %v - %v", action, self.Workspace, err)
+		}
+		if !allowed {
+			return fmt.Errorf("%w - %v may not %v workspace %v", ErrForbidden, self.Cx.User, action, self.Workspace)
+		}
+	}
+	return nil
+}
+
+// HandleApiRequest authorizes the request with authz,
+// checks uploads against quota (nil for no quotas),
+// and dispatches it to mgr - streaming proxied
+// requests through proxy (nil if not enabled)
+func (self *ApiRequest) HandleApiRequest(mgr Manager, authz Authorizer, quota *QuotaEnforcer, trash *TrashBin, proxy *ObjectProxy) (*ApiResult) {
 	result := &ApiResult{
 		Version: 1,
 		Method: self.Verb,
@@ -88,42 +444,300 @@ func (self *ApiRequest) HandleApiRequest(mgr Manager) (*ApiResult) {
 		Data: nil,
 	}
 	var data ApiResultData = nil
-	var err error = nil
+	err := self.authorize(authz)
+	if nil == err && trash.Enabled() && !strings.HasPrefix(self.Verb, "trash-") &&
+		(isTrashKey(self.Key) || isTrashKey(self.Destination)) {
+		err = invalidInputf("invalid key - %v is reserved for the trash", trashFolder)
+	}
+	if nil == err && self.Proxy && !proxy.Enabled() {
+		err = invalidInputf("invalid request - proxy mode is not enabled")
+	}
+	if nil == err {
+		err = self.reserveQuota(mgr, quota)
+	}
+	if nil == err && nil != self.recordDecision && self.isMutating() {
+		// the request does not run unless its decision is recorded
+		err = self.recordDecision()
+	}
+	if nil != err {
+		return newErrorResult(self.Verb, err)
+	}
 
 	switch self.Verb {
 	case "list": 
-	data, err = mgr.List(self.Cx, self.Workspace, self.Key, "")
+	var listing *ListResult
+	listing, err = mgr.List(self.Cx, self.Workspace, self.Key, self.Page, self.Limit)
+	if nil == err && trash.Enabled() && "" == self.Key {
+		listing.Prefixes = hideTrashFolder(listing.Prefixes)
+	}
+	if nil == err && self.Checksums {
+		err = listChecksums(mgr, self.Cx, self.Workspace, listing)
+	}
+	data = listing
 	case "upload":
-	data, err = mgr.UploadUrl(self.Cx, self.Workspace, self.Key)
+	data, err = mgr.UploadUrl(self.Cx, self.Workspace, self.Key, self.uploadOptions())
+	if nil == err && self.Proxy {
+		data, err = nil, proxy.Upload(data.(string), uploadHeaders(mgr, self.Key, self.uploadOptions()), self.Body, self.SizeBytes)
+	}
 	case "download":
-	data, err = mgr.DownloadUrl(self.Cx, self.Workspace, self.Key)
+	if "" != self.VersionId {
+		data, err = self.handleVersions(mgr)
+	} else {
+		data, err = mgr.DownloadUrl(self.Cx, self.Workspace, self.Key, self.downloadOptions())
+	}
+	if nil == err && self.Proxy {
+		data, err = proxy.Download(data.(string), self.Header)
+	}
 	case "delete":
-	err = mgr.DeleteObject(self.Cx, self.Workspace, self.Key)
+	if trash.Enabled() {
+		err = trash.Trash(self.Cx, self.Workspace, self.Key)
+	} else {
+		err = mgr.DeleteObject(self.Cx, self.Workspace, self.Key)
+	}
+	case "stat":
+	data, err = mgr.Stat(self.Cx, self.Workspace, self.Key)
+	case "usage":
+	if nil != quota {
+		data, err = quota.Usage(mgr, self.Cx, self.Workspace)
+	} else {
+		data, err = mgr.Usage(self.Cx, self.Workspace)
+	}
+	case "deleteprefix":
+	var deleted *DeletePrefixResult
+	if trash.Enabled() {
+		deleted, err = trash.TrashPrefix(self.Cx, self.Workspace, self.Key)
+	} else {
+		deleted, err = mgr.DeletePrefix(self.Cx, self.Workspace, self.Key)
+	}
+	if nil == err && len(deleted.Failures) > 0 {
+		result.Result = fmt.Sprintf("error - failed to delete %v of %v objects", len(deleted.Failures), len(deleted.Failures)+deleted.DeletedCount)
+		result.Error = &ApiError{Code: ErrorCodePartialFailure, Message: result.Result[len("error - "):]}
+	}
+	data = deleted
+	case "copy":
+	err = mgr.Copy(self.Cx, self.Workspace, self.Key, self.Destination)
+	case "move":
+	err = mgr.Move(self.Cx, self.Workspace, self.Key, self.Destination)
+	case "multipart-create", "multipart-part", "multipart-complete", "multipart-abort", "multipart-list":
+	data, err = self.handleMultipart(mgr)
+	case "versions", "restore":
+	data, err = self.handleVersions(mgr)
+	case "trash-list", "trash-restore", "trash-purge":
+	data, err = self.handleTrash(trash)
 	default:
-	err = fmt.Errorf("invalid verb %v", self.Verb)
+	err = invalidInputf("invalid verb %v", self.Verb)
 	}
 
 	if nil != err {
-		result.Result = fmt.Sprintf("error - %v", err.Error())
-	} else {
-		result.Data = data;
+		return newErrorResult(self.Verb, err)
+	}
+	result.Data = data;
+	if !self.Proxy && ("upload" == self.Verb || "download" == self.Verb || "multipart-part" == self.Verb) {
+		presignedUrlsTotal.WithLabelValues(self.Verb).Inc()
 	}
 	return result
 }
 
+// isMutating is true if the request changes stored objects -
+// issuing a presigned url does not, but a proxied upload does
+func (self *ApiRequest) isMutating() bool {
+	switch self.Verb {
+	case "delete", "deleteprefix", "copy", "move", "restore",
+		"multipart-complete", "multipart-abort", "trash-restore", "trash-purge":
+		return true
+	case "upload":
+		return self.Proxy
+	}
+	return false
+}
+
+// reserveQuota checks that the objects a request adds fit in
+// the workspace's quota - an upload must declare its size.
+// Part urls do not bind a part's size, so completing a
+// multipart upload re-checks the size of its stored parts.
+func (self *ApiRequest) reserveQuota(mgr Manager, quota *QuotaEnforcer) error {
+	if nil == quota || !quota.Enabled() {
+		return nil
+	}
+	switch self.Verb {
+	case "upload", "multipart-create":
+		if self.SizeBytes <= 0 {
+			return invalidInputf("invalid size - uploads must declare their size when quotas are enabled")
+		}
+		return quota.Reserve(mgr, self.Cx, self.Workspace, self.SizeBytes, 1)
+	case "multipart-complete":
+		mpMgr, ok := mgr.(MultipartManager)
+		if !ok {
+			return nil
+		}
+		stored, err := mpMgr.ListParts(self.Cx, self.Workspace, self.Key, self.UploadId)
+		if nil != err {
+			return err
+		}
+		return quota.Recheck(mgr, self.Cx, self.Workspace, completedPartsSize(stored, self.Parts), 1)
+	case "copy":
+		stat, err := mgr.Stat(self.Cx, self.Workspace, self.Key)
+		if nil != err {
+			return err
+		}
+		return quota.Reserve(mgr, self.Cx, self.Workspace, stat.SizeBytes, 1)
+	case "restore":
+		vMgr, ok := mgr.(VersionManager)
+		if !ok {
+			return nil
+		}
+		versions, err := vMgr.ListVersions(self.Cx, self.Workspace, self.Key)
+		if nil != err {
+			return err
+		}
+		version, err := findVersion(versions, self.VersionId)
+		if nil != err {
+			return err
+		}
+		return quota.Reserve(mgr, self.Cx, self.Workspace, version.SizeBytes, 1)
+	}
+	return nil
+}
+
+// completedPartsSize sums the stored size of the parts
+// a multipart upload completes with
+func completedPartsSize(stored []UploadedPart, parts []CompletedPart) int64 {
+	sizes := map[int64]int64{}
+	for _, part := range stored {
+		sizes[part.PartNumber] = part.SizeBytes
+	}
+	total := int64(0)
+	for _, part := range parts {
+		total += sizes[part.PartNumber]
+	}
+	return total
+}
+
+func (self *ApiRequest) handleMultipart(mgr Manager) (ApiResultData, error) {
+	mpMgr, ok := mgr.(MultipartManager)
+	if !ok {
+		return nil, invalidInputf("multipart upload not supported by the storage backend")
+	}
+	switch self.Verb {
+	case "multipart-create":
+		return mpMgr.CreateMultipartUpload(self.Cx, self.Workspace, self.Key)
+	case "multipart-part":
+		return mpMgr.UploadPartUrl(self.Cx, self.Workspace, self.Key, self.UploadId, self.PartNumber, self.partOptions())
+	case "multipart-complete":
+		return nil, mpMgr.CompleteMultipartUpload(self.Cx, self.Workspace, self.Key, self.UploadId, self.Parts)
+	case "multipart-abort":
+		return nil, mpMgr.AbortMultipartUpload(self.Cx, self.Workspace, self.Key, self.UploadId)
+	case "multipart-list":
+		return mpMgr.ListMultipartUploads(self.Cx, self.Workspace, self.Key)
+	}
+	return nil, invalidInputf("invalid verb %v", self.Verb)
+}
+
+// uploadOptions are the upload url constraints from the request
+func (self *ApiRequest) uploadOptions() UploadOptions {
+	return UploadOptions{
+		SizeBytes:   self.SizeBytes,
+		ContentType: self.ContentType,
+		Checksum:    self.Checksum,
+		Expires:     self.Expires,
+	}
+}
+
+// partOptions are the part url constraints from the request
+func (self *ApiRequest) partOptions() PartOptions {
+	return PartOptions{
+		SizeBytes: self.SizeBytes,
+		Checksum:  self.Checksum,
+		Expires:   self.Expires,
+	}
+}
+
+// setProxyBody takes the data of a proxied upload from r -
+// the upload is bound to the request's content length,
+// and its content type if the query does not set one
+func (self *ApiRequest) setProxyBody(r *http.Request) error {
+	self.Header = r.Header
+	if "upload" != self.Verb {
+		return nil
+	}
+	if r.ContentLength < 0 {
+		return invalidInputf("invalid request - a proxied upload must set Content-Length")
+	}
+	if self.SizeBytes > 0 && self.SizeBytes != r.ContentLength {
+		return invalidInputf("invalid size - declared %v, but Content-Length is %v", self.SizeBytes, r.ContentLength)
+	}
+	self.SizeBytes = r.ContentLength
+	if contentType := r.Header.Get("Content-Type"); "" == self.ContentType && "" != contentType {
+		if _, _, err := mime.ParseMediaType(contentType); nil != err {
+			return invalidInputf("invalid Content-Type - %v", err)
+		}
+		self.ContentType = contentType
+	}
+	self.Body = r.Body
+	return nil
+}
+
+// downloadOptions are the download url options from the request
+func (self *ApiRequest) downloadOptions() DownloadOptions {
+	return DownloadOptions{ContentDisposition: self.ContentDisposition, Expires: self.Expires}
+}
+
+// handleVersions lists, downloads, or restores object versions
+func (self *ApiRequest) handleVersions(mgr Manager) (ApiResultData, error) {
+	vMgr, ok := mgr.(VersionManager)
+	if !ok {
+		return nil, invalidInputf("object versions not supported by the storage backend")
+	}
+	switch self.Verb {
+	case "versions":
+		return vMgr.ListVersions(self.Cx, self.Workspace, self.Key)
+	case "download":
+		return vMgr.DownloadVersionUrl(self.Cx, self.Workspace, self.Key, self.VersionId, self.downloadOptions())
+	case "restore":
+		return nil, vMgr.RestoreVersion(self.Cx, self.Workspace, self.Key, self.VersionId)
+	}
+	return nil, invalidInputf("invalid verb %v", self.Verb)
+}
+
+// handleTrash lists, restores, or purges trash items
+func (self *ApiRequest) handleTrash(trash *TrashBin) (ApiResultData, error) {
+	if !trash.Enabled() {
+		return nil, invalidInputf("trash not enabled")
+	}
+	switch self.Verb {
+	case "trash-list":
+		return trash.List(self.Cx, self.Workspace, self.Page, self.Limit)
+	case "trash-restore":
+		return nil, trash.Restore(self.Cx, self.Workspace, self.Key)
+	case "trash-purge":
+		return trash.Purge(self.Cx, self.Workspace, self.Key)
+	}
+	return nil, invalidInputf("invalid verb %v", self.Verb)
+}
+
+// bearerToken extracts the token from an Authorization: Bearer header
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// infoHandler lists the endpoints described by the OpenAPI spec
 func infoHandler(w http.ResponseWriter, r *http.Request) {
-	index := `
-	{
-		"endpoints": [
-			"/ws-storage/list/$workspace/$key",
-			"/ws-storage/download/$workspace/$key",
-			"/ws-storage/upload/$workspace/$key",
-			"/ws-storage/healthy",
-			"/ws-storage/info"		
-		]
-	}`
-	w.Header().Add("ContentType", "application/json")
-	fmt.Fprintf(w, index)
+	paths, err := openApiPaths()
+	if nil != err {
+		writeApiResult(w, newErrorResult("info", err))
+		return
+	}
+	bytes, err := json.Marshal(map[string][]string{"endpoints": paths})
+	if nil != err {
+		writeApiResult(w, newErrorResult("info", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
 }
 
 func healthyHandler(w http.ResponseWriter, r *http.Request) {
@@ -131,32 +745,88 @@ func healthyHandler(w http.ResponseWriter, r *http.Request) {
 	{
 		"status": "awesome"
 	}`
-	w.Header().Add("ContentType", "application/json")
+	w.Header().Set("Content-Type", "application/json")
 	fmt.Fprintf(w, index)
 }
 
 
 func apiHandler(w http.ResponseWriter, r *http.Request) {
-	apiReq, err := NewApiRequest(r.URL, r.Method, r.Header.Get("REMOTE_USER"))
 	start := time.Now()
+	reqId := requestId(r)
 	sublog := log.Info().
-		Str("request", fmt.Sprintf("%v", r.URL))
+		Str("request", fmt.Sprintf("%v", r.URL)).
+		Str("requestid", reqId)
+	verb, workspace, statusCode := "", "", 500
+	defer func() {
+		observeApiRequest(verb, workspace, statusCode, time.Since(start))
+	}()
 
-	w.Header().Add("ContentType", "application/json")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(requestIdHeader, reqId)
+	var apiReq *ApiRequest
+	var result *ApiResult
+	user, err := authnSingleton.Authenticate(r)
 	if nil != err {
-		http.Error(w, "{ \"Result\": \"invalid input\" }", 400)
-		sublog.Int("statuscode", 400).Dur("durationms", time.Since(start)).Send()
-		return
+		log.Debug().Str("Func", "apiHandler").Msgf("authentication failed - %v", err)
+		result = newUnauthorizedResult()
+	} else {
+		apiReq, err = NewApiRequest(r.URL, r.Method, user)
+		if nil == err {
+			apiReq.Cx.Token = bearerToken(r)
+			if nil != auditSingleton {
+				apiReq.recordDecision = func() error {
+					return auditDecision(r, reqId, user, apiReq)
+				}
+			}
+		}
+		if nil == err && "multipart-complete" == apiReq.Verb {
+			err = json.NewDecoder(io.LimitReader(r.Body, maxRequestBodyBytes)).Decode(&apiReq.Parts)
+			if nil != err {
+				// the multipart-complete body failed to parse
+				err = invalidInputf("invalid request body - %v", err)
+			}
+		}
+		if nil == err && apiReq.Proxy {
+			err = apiReq.setProxyBody(r)
+		}
+		if nil != err {
+			result = newErrorResult("", err)
+		} else {
+			verb, workspace = apiReq.Verb, apiReq.Workspace
+			result = apiReq.HandleApiRequest(mgrSingleton, authzSingleton, quotaSingleton, trashSingleton, proxySingleton)
+		}
 	}
+	stream, _ := result.Data.(*ObjectStream)
+	result = auditApiRequest(r, reqId, user, apiReq, result)
+	if nil != stream && result.Data == stream {
+		statusCode = stream.StatusCode
+		if err := stream.Serve(w); nil != err {
+			log.Debug().Str("Func", "apiHandler").Msgf("proxied download interrupted - %v", err)
+		}
+	} else {
+		if nil != stream {
+			// the audit record failed to store
+			stream.Close()
+		}
+		statusCode = writeApiResult(w, result)
+	}
+	sublog.Int("statuscode", statusCode).Dur("durationms", time.Since(start)).Send()
+}
 
-	result := apiReq.HandleApiRequest(mgrSingleton)
-	
+// writeApiResult writes the json result with its status code,
+// and returns the status code
+func writeApiResult(w http.ResponseWriter, result *ApiResult) int {
 	bytes, err := json.Marshal(result)
 	if nil != err {
-		sublog.Int("statuscode", 500).Dur("durationms", time.Since(start)).Msg("failed json marshall")
-		http.Error(w, "error marshaling result", 500)
-		return
+		log.Error().Str("Func", "writeApiResult").Msgf("failed json marshal - %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(500)
+		w.Write([]byte("{ \"Result\": \"error marshaling result\", \"Error\": { \"Code\": \"InternalError\" } }"))
+		return 500
 	}
+	statusCode := result.StatusCode()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
 	w.Write(bytes)
-	sublog.Int("statuscode", 200).Dur("durationms", time.Since(start)).Send()
+	return statusCode
 }
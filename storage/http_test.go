@@ -71,6 +71,114 @@ func TestNewApiRequest(t *testing.T) {
 	}
 }
 
+func TestNewApiRequestNamedWorkspace(t *testing.T) {
+	testUrl, _ := url.Parse("https://whatever/ws-storage/download/@group/lab1/abc/def")
+	req, err := NewApiRequest(testUrl, http.MethodGet, testUser)
+	if nil != err {
+		t.Error(fmt.Sprintf("unexpected path %v failed validation, got: %v", testUrl.Path, err))
+		return
+	}
+	if "@group/lab1" != req.Workspace || "abc/def" != req.Key {
+		t.Error(fmt.Sprintf("unexpected workspace %v and key %v", req.Workspace, req.Key))
+		return
+	}
+	testUrl, _ = url.Parse("https://whatever/ws-storage/list/@group")
+	if _, err := NewApiRequest(testUrl, http.MethodGet, testUser); nil == err {
+		t.Error(fmt.Sprintf("path should have failed validation: %v", testUrl.Path))
+		return
+	}
+}
+
+func TestHandleApiRequestNamedWorkspace(t *testing.T) {
+	mgr, err := getTestMgr(t)
+	if nil != err {
+		return
+	}
+	authz, err := getTestAuthz(t)
+	if nil != err {
+		return
+	}
+	if allowed, _ := authz.Authorize(testSession, "@group/goTestGroup", ActionWrite); !allowed {
+		t.Skip("test config does not give goTestUser access to @group/goTestGroup")
+	}
+	testCases := []struct {
+		user     string
+		method   string
+		path     string
+		expected bool
+	}{
+		{testUser, http.MethodGet, "upload/@group/goTestGroup/testObject.txt", true},
+		{"goTestReader", http.MethodGet, "list/@group/goTestGroup/", true},
+		{"goTestReader", http.MethodGet, "download/@group/goTestGroup/testObject.txt", true},
+		{"goTestReader", http.MethodGet, "upload/@group/goTestGroup/testObject.txt", false},
+		{"goTestReader", http.MethodDelete, "list/@group/goTestGroup/testObject.txt", false},
+		{"goTestStranger", http.MethodGet, "list/@group/goTestGroup/", false},
+		{testUser, http.MethodGet, "list/@group/goTestGroup2/", false},
+		{testUser, http.MethodDelete, "list/@group/goTestGroup/testObject.txt", true},
+	}
+	for _, it := range testCases {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it.path)
+		req, err := NewApiRequest(testUrl, it.method, it.user)
+		if nil != err {
+			t.Error(fmt.Sprintf("unexpected path %v failed validation, got: %v", it.path, err))
+			return
+		}
+		result := req.HandleApiRequest(mgr, authz, nil, nil, nil)
+		if ("ok" == result.Result) != it.expected {
+			t.Error(fmt.Sprintf("unexpected result for %v %v %v, got: %v", it.user, it.method, it.path, result.Result))
+			return
+		}
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	testCases := [][]string{
+		{"Bearer abc.def.ghi", "abc.def.ghi"},
+		{"bearer  abc", "abc"},
+		{"Basic abc", ""},
+		{"", ""},
+	}
+	for _, it := range testCases {
+		req, _ := http.NewRequest(http.MethodGet, "https://whatever/ws-storage/list/@user/", nil)
+		req.Header.Set("Authorization", it[0])
+		if token := bearerToken(req); token != it[1] {
+			t.Error(fmt.Sprintf("unexpected token from %v, got: %v", it[0], token))
+			return
+		}
+	}
+}
+
+func TestNewApiRequestPaging(t *testing.T) {
+	testUrl, err := url.Parse("https://whatever/ws-storage/list/@user/abc/?page=token123&limit=10")
+	if nil != err {
+		t.Error(fmt.Sprintf("failed url construction, got: %v", err))
+		return
+	}
+	req, err := NewApiRequest(testUrl, http.MethodGet, testUser)
+	if nil != err {
+		t.Error(fmt.Sprintf("unexpected path %v failed validation, got: %v", testUrl, err))
+		return
+	}
+	if "token123" != req.Page || 10 != req.Limit || "abc/" != req.Key {
+		t.Error(fmt.Sprintf("unexpected paging parameters, got page %v, limit %v, key %v", req.Page, req.Limit, req.Key))
+		return
+	}
+
+	invalidLimits := []string { "0", "-1", "1001", "frickjack" }
+	for _, it := range invalidLimits {
+		testUrl, err := url.Parse("https://whatever/ws-storage/list/@user/?limit=" + it)
+		if nil != err {
+			t.Error(fmt.Sprintf("failed url construction for %v, got: %v", it, err))
+			return
+		}
+		_, err = NewApiRequest(testUrl, http.MethodGet, testUser)
+		if nil == err {
+			t.Error(fmt.Sprintf("limit should have failed validation: %v", it))
+			return
+		}
+	}
+}
+
 
 func doApiRequest(t *testing.T, verb string) (*ApiResult, error) {
 	key := "testObject.txt"
@@ -78,6 +186,10 @@ func doApiRequest(t *testing.T, verb string) (*ApiResult, error) {
 	if nil != err {
 		return nil, err
 	}
+	authz, err := getTestAuthz(t)
+	if nil != err {
+		return nil, err
+	}
 
 	urlStr := "https://whatever/ws-storage/" + verb + "/@user/" + key
 	urlMethod := http.MethodGet
@@ -95,9 +207,10 @@ func doApiRequest(t *testing.T, verb string) (*ApiResult, error) {
 		t.Error(fmt.Sprintf("unexpected path %v failed validation, got: %v", testUrl.Path, err))
 		return nil, err
 	}
-	result := req.HandleApiRequest(mgr)
+	result := req.HandleApiRequest(mgr, authz, nil, nil, nil)
 	if "ok" != result.Result {
-		t.Error(fmt.Sprintf("unexpected path %v failed handling, got: %v", testUrl.Path, err))
+		err = fmt.Errorf("unexpected path %v failed handling, got: %v", testUrl.Path, result.Result)
+		t.Error(err.Error())
 		return nil, err
 	}
 	json, err := json.MarshalIndent(result, "", "  ")
@@ -123,6 +236,7 @@ func doUploadApiRequest(t *testing.T) (bool) {
 	testBytes := bytes.NewBufferString(testMessage)		
 	req, err := http.NewRequest(http.MethodPut, uploadUrl, testBytes)
 	req.ContentLength = int64(testBytes.Len())
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
 	if nil != err {
 		t.Error(fmt.Sprintf("failed to setup upload request, got: %v", err))
 		return false
@@ -184,6 +298,19 @@ func doListApiRequest(t *testing.T) (bool) {
 	return true
 }
 
+func doStatApiRequest(t *testing.T) (bool) {
+	result, err := doApiRequest(t, "stat")
+	if nil != err {
+		return false
+	}
+	stat := result.Data.(*ObjectStat)
+	if stat.WorkspaceKey != "testObject.txt" || stat.SizeBytes != int64(len("this is a test")) || "" == stat.ETag {
+		t.Error(fmt.Sprintf("unexpected stat, got: %v", stat))
+		return false
+	}
+	return true
+}
+
 func doDeleteApiRequest(t *testing.T) (bool) {
 	_, err := doApiRequest(t, "delete")
 	if nil != err {
@@ -197,5 +324,54 @@ func TestHandleApiRequest(t *testing.T) {
 	_ = doUploadApiRequest(t) && 
 			doDownloadApiRequest(t) && 
 			doListApiRequest(t) && 
-			doDeleteApiRequest(t)
+			doStatApiRequest(t) &&
+			doDeleteApiRequest(t) &&
+			doStatNotFoundApiRequest(t)
+}
+
+func doStatNotFoundApiRequest(t *testing.T) (bool) {
+	mgr, err := getTestMgr(t)
+	if nil != err {
+		return false
+	}
+	authz, err := getTestAuthz(t)
+	if nil != err {
+		return false
+	}
+	testUrl, _ := url.Parse("https://whatever/ws-storage/stat/@user/testObject.txt")
+	req, err := NewApiRequest(testUrl, http.MethodGet, testUser)
+	if nil != err {
+		t.Error(fmt.Sprintf("unexpected path %v failed validation, got: %v", testUrl.Path, err))
+		return false
+	}
+	if result := req.HandleApiRequest(mgr, authz, nil, nil, nil); resultNotFound != result.Result || nil != result.Data {
+		t.Error(fmt.Sprintf("expected not found for deleted object, got: %v", result))
+		return false
+	}
+	return true
+}
+
+func TestNewApiRequestContentHeaders(t *testing.T) {
+	testCases := []struct {
+		path        string
+		valid       bool
+		contentType string
+		disposition string
+	}{
+		{"upload/@user/x?contentType=text%2Fcsv", true, "text/csv", ""},
+		{"download/@user/x?contentDisposition=attachment%3B%20filename%3D%22x.csv%22", true, "", `attachment; filename="x.csv"`},
+		{"upload/@user/x?contentType=text%2F", false, "", ""},
+		{"download/@user/x?contentDisposition=%3Bfilename", false, "", ""},
+	}
+	for _, it := range testCases {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it.path)
+		req, err := NewApiRequest(testUrl, http.MethodGet, testUser)
+		if (nil == err) != it.valid {
+			t.Error(fmt.Sprintf("unexpected validation of %v, got: %v", it.path, err))
+			continue
+		}
+		if nil == err && (req.ContentType != it.contentType || req.ContentDisposition != it.disposition) {
+			t.Error(fmt.Sprintf("unexpected content headers for %v, got: %v, %v", it.path, req.ContentType, req.ContentDisposition))
+		}
+	}
 }
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Authenticator identifies the user making a request
+type Authenticator interface {
+	// Authenticate returns the user making the request -
+	// an error rejects the request
+	Authenticate(r *http.Request) (string, error)
+}
+
+// NewAuthenticator makes the authenticator selected by the given configuration
+func NewAuthenticator(config *Config) (Authenticator, error) {
+	switch config.Authentication {
+	case "", "remoteuser":
+		return &RemoteUserAuthenticator{}, nil
+	case "jwt":
+		return NewJwtAuthenticator(config.Jwt)
+	default:
+		return nil, fmt.Errorf("unsupported authentication: %v", config.Authentication)
+	}
+}
+
+// RemoteUserAuthenticator trusts the REMOTE_USER header
+// that the API gateway (revproxy) sets after verifying
+// the request's access token
+type RemoteUserAuthenticator struct{}
+
+// Authenticate returns the REMOTE_USER header
+func (self *RemoteUserAuthenticator) Authenticate(r *http.Request) (string, error) {
+	user := r.Header.Get("REMOTE_USER")
+	if "" == user {
+		return "", fmt.Errorf("remote user not specified")
+	}
+	return user, nil
+}
+
+// minJwksRefresh limits how often an unknown key id
+// triggers a reload of a JWKS url
+const minJwksRefresh = 30 * time.Second
+
+// JwtAuthenticator validates an RS256 signed bearer JWT
+// against a JSON web key set, and takes the user from a
+// configurable claim - so ws-storage can authenticate
+// requests without relying on an API gateway
+type JwtAuthenticator struct {
+	config     *JwtConfig
+	httpClient *http.Client
+	lock       sync.Mutex
+	keys       map[string]*rsa.PublicKey
+	loaded     time.Time
+}
+
+// jwkSet is the subset of a JSON web key set we use
+type jwkSet struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// NewJwtAuthenticator makes an authenticator with the given
+// configuration, and loads its key set
+func NewJwtAuthenticator(config *JwtConfig) (*JwtAuthenticator, error) {
+	if nil == config || "" == config.Jwks {
+		return nil, fmt.Errorf("jwt authentication requires jwt.jwks in config")
+	}
+	authn := &JwtAuthenticator{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	if err := authn.loadKeys(); nil != err {
+		return nil, err
+	}
+	return authn, nil
+}
+
+// loadKeys reads the RSA keys from the configured JWKS file or url
+func (self *JwtAuthenticator) loadKeys() error {
+	var jwksBytes []byte
+	var err error
+	if strings.HasPrefix(self.config.Jwks, "https://") || strings.HasPrefix(self.config.Jwks, "http://") {
+		var resp *http.Response
+		resp, err = self.httpClient.Get(self.config.Jwks)
+		if nil != err {
+			return fmt.Errorf("failed to fetch jwks %v - %v", self.config.Jwks, err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to fetch jwks %v - status %v", self.config.Jwks, resp.StatusCode)
+		}
+		jwksBytes, err = ioutil.ReadAll(resp.Body)
+	} else {
+		jwksBytes, err = ioutil.ReadFile(self.config.Jwks)
+	}
+	if nil != err {
+		return fmt.Errorf("failed to read jwks %v - %v", self.config.Jwks, err)
+	}
+	jwks := &jwkSet{}
+	if err := json.Unmarshal(jwksBytes, jwks); nil != err {
+		return fmt.Errorf("failed to parse jwks %v - %v", self.config.Jwks, err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, it := range jwks.Keys {
+		if "RSA" != it.Kty {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(it.N)
+		if nil != err {
+			return fmt.Errorf("invalid jwks key %v - %v", it.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(it.E)
+		if nil != err {
+			return fmt.Errorf("invalid jwks key %v - %v", it.Kid, err)
+		}
+		keys[it.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no RSA keys in jwks %v", self.config.Jwks)
+	}
+	self.lock.Lock()
+	self.keys = keys
+	self.loaded = time.Now()
+	self.lock.Unlock()
+	return nil
+}
+
+// key returns the public key with the given id -
+// reloading the key set at most every minJwksRefresh
+// to pick up rotated keys
+func (self *JwtAuthenticator) key(kid string) (*rsa.PublicKey, error) {
+	self.lock.Lock()
+	key, ok := self.keys[kid]
+	reload := !ok && time.Since(self.loaded) > minJwksRefresh
+	if reload {
+		self.loaded = time.Now()
+	}
+	self.lock.Unlock()
+	if ok {
+		return key, nil
+	}
+	if reload {
+		if err := self.loadKeys(); nil != err {
+			log.Warn().Str("Func", "JwtAuthenticator.key").Msgf("failed to reload jwks - %v", err)
+		}
+		self.lock.Lock()
+		key, ok = self.keys[kid]
+		self.lock.Unlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown jwt key id: %v", kid)
+}
+
+// Authenticate validates the bearer token in the Authorization header,
+// and returns the user claim
+func (self *JwtAuthenticator) Authenticate(r *http.Request) (string, error) {
+	token := bearerToken(r)
+	if "" == token {
+		return "", fmt.Errorf("no bearer token")
+	}
+	claims, err := self.Validate(token)
+	if nil != err {
+		return "", err
+	}
+	user, ok := claimValue(claims, self.userClaim()).(string)
+	if !ok || "" == user {
+		return "", fmt.Errorf("no %v claim in token", self.userClaim())
+	}
+	return user, nil
+}
+
+func (self *JwtAuthenticator) userClaim() string {
+	if "" == self.config.UserClaim {
+		return "sub"
+	}
+	return self.config.UserClaim
+}
+
+// claimValue looks up a possibly nested claim with
+// a dotted path - ex: context.user.name
+func claimValue(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, name := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// Validate verifies the token's signature, expiration,
+// and configured issuer and audience, and returns its claims
+func (self *JwtAuthenticator) Validate(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed jwt")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeJwtPart(parts[0], &header); nil != err {
+		return nil, err
+	}
+	if "RS256" != header.Alg {
+		return nil, fmt.Errorf("unsupported jwt algorithm: %v", header.Alg)
+	}
+	key, err := self.key(header.Kid)
+	if nil != err {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if nil != err {
+		return nil, fmt.Errorf("malformed jwt signature")
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); nil != err {
+		return nil, fmt.Errorf("invalid jwt signature")
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeJwtPart(parts[1], &claims); nil != err {
+		return nil, err
+	}
+	now := float64(time.Now().Unix())
+	exp, ok := claims["exp"].(float64)
+	if !ok || now > exp {
+		return nil, fmt.Errorf("jwt expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return nil, fmt.Errorf("jwt not yet valid")
+	}
+	if "" != self.config.Issuer && claims["iss"] != self.config.Issuer {
+		return nil, fmt.Errorf("unexpected jwt issuer: %v", claims["iss"])
+	}
+	if "" != self.config.Audience && !hasAudience(claims["aud"], self.config.Audience) {
+		return nil, fmt.Errorf("jwt audience does not include %v", self.config.Audience)
+	}
+	return claims, nil
+}
+
+// hasAudience checks a string or list aud claim for the given audience
+func hasAudience(aud interface{}, audience string) bool {
+	switch value := aud.(type) {
+	case string:
+		return value == audience
+	case []interface{}:
+		for _, it := range value {
+			if it == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeJwtPart(part string, result interface{}) error {
+	partBytes, err := base64.RawURLEncoding.DecodeString(part)
+	if nil != err {
+		return fmt.Errorf("malformed jwt - %v", err)
+	}
+	if err := json.Unmarshal(partBytes, result); nil != err {
+		return fmt.Errorf("malformed jwt - %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testJwks renders the public key as a JSON web key set
+func testJwks(key *rsa.PrivateKey, kid string) []byte {
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "EC", "kid": "ignored"},
+			{
+				"kty": "RSA",
+				"kid": kid,
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			},
+		},
+	}
+	jwksBytes, _ := json.Marshal(jwks)
+	return jwksBytes
+}
+
+// testJwt signs the given claims with RS256
+func testJwt(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	headerBytes, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	claimsBytes, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(headerBytes) + "." + base64.RawURLEncoding.EncodeToString(claimsBytes)
+	hash := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJwtAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to generate key, got: %v", err))
+		return
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to generate key, got: %v", err))
+		return
+	}
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	if err := ioutil.WriteFile(jwksPath, testJwks(key, "key1"), 0600); nil != err {
+		t.Error(fmt.Sprintf("failed to write jwks, got: %v", err))
+		return
+	}
+	authn, err := NewAuthenticator(&Config{
+		Authentication: "jwt",
+		Jwt: &JwtConfig{
+			Jwks:      jwksPath,
+			UserClaim: "context.user.name",
+			Issuer:    "https://commons.example/user",
+			Audience:  "openid",
+		},
+	})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize jwt authenticator, got: %v", err))
+		return
+	}
+
+	claims := func(exp time.Duration, iss string, aud interface{}, user interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"exp":     time.Now().Add(exp).Unix(),
+			"iss":     iss,
+			"aud":     aud,
+			"context": map[string]interface{}{"user": map[string]interface{}{"name": user}},
+		}
+	}
+	iss := "https://commons.example/user"
+	testCases := []struct {
+		token    string
+		expected string
+	}{
+		{testJwt(key, "key1", claims(time.Hour, iss, []string{"openid", "user"}, testUser)), testUser},
+		{testJwt(key, "key1", claims(time.Hour, iss, "openid", testUser)), testUser},
+		{testJwt(key, "key1", claims(-time.Minute, iss, "openid", testUser)), ""},
+		{testJwt(key, "key1", claims(time.Hour, "https://other.example/user", "openid", testUser)), ""},
+		{testJwt(key, "key1", claims(time.Hour, iss, "user", testUser)), ""},
+		{testJwt(key, "key1", claims(time.Hour, iss, "openid", nil)), ""},
+		{testJwt(key, "key2", claims(time.Hour, iss, "openid", testUser)), ""},
+		{testJwt(otherKey, "key1", claims(time.Hour, iss, "openid", testUser)), ""},
+		{"not.a.jwt", ""},
+		{"", ""},
+	}
+	for ix, it := range testCases {
+		req := httptest.NewRequest(http.MethodGet, "/ws-storage/list/@user/", nil)
+		if "" != it.token {
+			req.Header.Set("Authorization", "Bearer "+it.token)
+		}
+		req.Header.Set("REMOTE_USER", "spoofed")
+		user, err := authn.Authenticate(req)
+		if it.expected != user || ("" == it.expected) != (nil != err) {
+			t.Error(fmt.Sprintf("unexpected authentication for case %v, got: %v, %v", ix, user, err))
+		}
+	}
+}
+
+func TestJwtAuthenticatorJwksUrl(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to generate key, got: %v", err))
+		return
+	}
+	requestCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount += 1
+		w.Write(testJwks(key, "key1"))
+	}))
+	defer server.Close()
+
+	authn, err := NewJwtAuthenticator(&JwtConfig{Jwks: server.URL + "/.well-known/jwks"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize jwt authenticator, got: %v", err))
+		return
+	}
+	claims := map[string]interface{}{"sub": testUser, "exp": time.Now().Add(time.Hour).Unix()}
+	req := httptest.NewRequest(http.MethodGet, "/ws-storage/list/@user/", nil)
+	req.Header.Set("Authorization", "Bearer "+testJwt(key, "key1", claims))
+	if user, err := authn.Authenticate(req); nil != err || testUser != user {
+		t.Error(fmt.Sprintf("unexpected authentication, got: %v, %v", user, err))
+	}
+	// an unknown key id does not reload the key set more than every minJwksRefresh
+	req.Header.Set("Authorization", "Bearer "+testJwt(key, "key2", claims))
+	if _, err := authn.Authenticate(req); nil == err {
+		t.Error("authenticated a token with an unknown key id")
+	}
+	if requestCount != 1 {
+		t.Error(fmt.Sprintf("expected 1 jwks request, got: %v", requestCount))
+	}
+}
+
+func TestRemoteUserAuthenticator(t *testing.T) {
+	authn, err := NewAuthenticator(&Config{})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize authenticator, got: %v", err))
+		return
+	}
+	req := httptest.NewRequest(http.MethodGet, "/ws-storage/list/@user/", nil)
+	if _, err := authn.Authenticate(req); nil == err {
+		t.Error("authenticated a request without REMOTE_USER")
+	}
+	req.Header.Set("REMOTE_USER", testUser)
+	if user, err := authn.Authenticate(req); nil != err || testUser != user {
+		t.Error(fmt.Sprintf("unexpected authentication, got: %v, %v", user, err))
+	}
+	if _, err := NewAuthenticator(&Config{Authentication: "jwt"}); nil == err {
+		t.Error("jwt authentication without jwks should fail")
+	}
+	if _, err := NewAuthenticator(&Config{Authentication: "bogus"}); nil == err {
+		t.Error("unsupported authentication should fail")
+	}
+}
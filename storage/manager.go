@@ -2,10 +2,19 @@ package storage
 
 import (
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,6 +32,59 @@ type ObjectInfo struct {
 	WorkspaceKey  string
 	SizeBytes     int64
 	LastModified  time.Time
+	// Checksum is the checksum declared by the object's upload -
+	// nil if none was declared, or the listing does not include it
+	Checksum      *Checksum
+}
+
+// ObjectStat is the metadata of a single object
+type ObjectStat struct {
+	ObjectInfo
+	ETag          string
+	ContentType   string
+	StorageClass  string
+	// Metadata is the user metadata (x-amz-meta-* and friends)
+	// attached to the object
+	Metadata      map[string]string
+}
+
+// UploadOptions constrain a presigned upload
+type UploadOptions struct {
+	// SizeBytes is the declared content length of the upload -
+	// when set it is signed into the url, so the upload must
+	// match it - 0 if unknown
+	SizeBytes     int64
+	// ContentType is signed into the url, so the upload
+	// must send it as its Content-Type header -
+	// detected from the key's extension if empty
+	ContentType   string
+	// Checksum is the declared digest of the upload - when set
+	// the url is bound to it, so the upload must match it,
+	// and it is recorded as the object's metadata
+	Checksum      *Checksum
+	// Expires is the requested lifetime of the url - clamped
+	// to the configured maximum, the configured default if 0
+	Expires       time.Duration
+}
+
+// ContentTypeOrDefault is the content type the upload of
+// the given key must carry
+func (self UploadOptions) ContentTypeOrDefault(key string) string {
+	if "" != self.ContentType {
+		return self.ContentType
+	}
+	return contentTypeByKey(key)
+}
+
+// DownloadOptions customize a presigned download
+type DownloadOptions struct {
+	// ContentDisposition overrides the Content-Disposition
+	// header of the download response if set -
+	// ex: attachment; filename="data.csv"
+	ContentDisposition string
+	// Expires is the requested lifetime of the url - clamped
+	// to the configured maximum, the configured default if 0
+	Expires            time.Duration
 }
 
 type ListResult struct {
@@ -30,25 +92,83 @@ type ListResult struct {
 	Prefix     string
 	Objects    []ObjectInfo
 	Prefixes   []string
+	// NextPage is an opaque token to pass back to List
+	// to fetch the next page of results - empty on the last page
+	NextPage   string
 }
 
+// MaxListLimit is the largest page size List supports
+const MaxListLimit = 1000
+
 type Manager interface {
-	List(cx *SessionContext, workspaceIn string, prefix string, page string) (*ListResult, error)
-	UploadUrl(cx *SessionContext, workspaceIn string, key string) (string, error)
-	DownloadUrl(cx *SessionContext, workspaceIn string, key string) (string, error)
+	List(cx *SessionContext, workspaceIn string, prefix string, page string, limit int) (*ListResult, error)
+	UploadUrl(cx *SessionContext, workspaceIn string, key string, options UploadOptions) (string, error)
+	DownloadUrl(cx *SessionContext, workspaceIn string, key string, options DownloadOptions) (string, error)
 	DeleteObject(cx *SessionContext, workspaceIn string, key string) (error)
+	Stat(cx *SessionContext, workspaceIn string, key string) (*ObjectStat, error)
+	Copy(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) (error)
+	Move(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) (error)
+	DeletePrefix(cx *SessionContext, workspaceIn string, prefix string) (*DeletePrefixResult, error)
+	Usage(cx *SessionContext, workspaceIn string) (*WorkspaceUsage, error)
 }
 
 //---------------------------------------
 
-// NewManager makes a new manager with the given configuration
+// NewManager makes a new manager for the backend
+// selected by the given configuration
 func NewManager(config *Config)(mgr Manager, err error) {
+	switch config.Backend {
+	case "", BackendS3:
+		return NewSimpleManager(config)
+	case BackendMemory:
+		return NewMemoryManager(config)
+	case BackendFilesystem:
+		return NewFilesystemManager(config)
+	case BackendGCS:
+		return NewGCSManager(config)
+	case BackendAzure:
+		return NewAzureManager(config)
+	default:
+		return nil, fmt.Errorf("unsupported storage backend: %v", config.Backend)
+	}
+}
+
+// NewSimpleManager makes a new S3 backed manager with the given configuration.
+// The AWS SDK initializes from the environment, and the
+// endpoint, region, pathstyle, and tlsskipverify config
+// settings override the environment to support S3 stand-ins
+// like MinIO and Ceph RGW.
+// Presigned urls are generated against the same endpoint.
+func NewSimpleManager(config *Config)(mgr *SimpleManager, err error) {
+	awsConfig := aws.NewConfig()
+	if "" != config.Endpoint {
+		awsConfig = awsConfig.WithEndpoint(config.Endpoint)
+	}
+	if "" != config.Region {
+		awsConfig = awsConfig.WithRegion(config.Region)
+	}
+	if config.PathStyle {
+		awsConfig = awsConfig.WithS3ForcePathStyle(true)
+	}
+	if config.TLSSkipVerify {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		awsConfig = awsConfig.WithHTTPClient(&http.Client{Transport: transport})
+	}
 	sess, err := session.NewSessionWithOptions(session.Options{
+		Config: *awsConfig,
 		SharedConfigState: session.SharedConfigEnable,
 	})
+	if nil != err {
+		return nil, err
+	}
 
 	// Create S3 service client
 	s3client := s3.New(sess)
+	s3client.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "ws-storage.observeS3Call",
+		Fn:   observeS3Call,
+	})
 	mgr = &SimpleManager{
 		config: config,
 		s3client: s3client,
@@ -69,7 +189,7 @@ func MakeS3Path(bucketPrefix, user, userPath string) (string, error) {
 		path = bucketPrefix
 	}
 	if user == "" || strings.Contains(user, "/") {
-		return errorPath, fmt.Errorf("invalid user %v", user)
+		return errorPath, invalidInputf("invalid user %v", user)
 	}
 	path += user
 	if ! strings.HasPrefix(userPath, "/") {
@@ -77,45 +197,58 @@ func MakeS3Path(bucketPrefix, user, userPath string) (string, error) {
 	}
 	path += userPath
 	if strings.Contains(path, "//") || strings.Contains(path, "..") || strings.Contains(path, "/./") || strings.ContainsAny(path, "<>|`'\"$!#&*()\\[]{};~") {
-		return errorPath, fmt.Errorf("invalid path - // or .. or /./: %v", path)
+		return errorPath, invalidInputf("invalid path - // or .. or /./: %v", path)
 	}
 	return path, nil
 }
 
 
 // List the prefixes and objects under a given workspace and prefix.
-// Currently no paging support - limit to 1000.
-// Currently only support user workspace.
-func (self *SimpleManager) List(cx *SessionContext, workspaceIn string, prefix string, page string) (*ListResult, error) {
-	if (workspaceIn != "@user") {
-		return nil, fmt.Errorf("invalid workspace - currently only support personal workspaces")
+// page is the NextPage token from a previous List call or empty
+// for the first page, and limit caps the number of entries
+// returned (0 means MaxListLimit).
+func (self *SimpleManager) List(cx *SessionContext, workspaceIn string, prefix string, page string, limit int) (*ListResult, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
 	}
-	if (page != "") {
-		return nil, fmt.Errorf("invalid page - paging not yet implemented")
+	if limit < 0 || limit > MaxListLimit {
+		return nil, invalidInputf("invalid limit - must be between 0 and %v, got %v", MaxListLimit, limit)
 	}
-	workspace := cx.User
-	s3path, err := MakeS3Path(self.config.BucketPrefix, workspace, prefix)
+	if limit == 0 {
+		limit = MaxListLimit
+	}
+	s3path, err := workspace.Path(prefix)
 	if err != nil {
 		return nil, err
 	}
-	s3prefix, err := MakeS3Path(self.config.BucketPrefix, cx.User, "")
+	s3prefix, err := workspace.Path("")
 	if err != nil {
 		return nil, err
 	}
-	resp, err := self.s3client.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(self.config.Bucket), Delimiter: aws.String("/"), Prefix: &s3path})
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(self.config.Bucket),
+		Delimiter: aws.String("/"),
+		Prefix: &s3path,
+		MaxKeys: aws.Int64(int64(limit)),
+	}
+	if page != "" {
+		input.ContinuationToken = aws.String(page)
+	}
+	resp, err := self.s3client.ListObjectsV2(input)
 	if err != nil {
 		return nil, err
 	}
 
 	result := &ListResult{
-		Workspace: workspace,
+		Workspace: workspace.Name,
 		Prefix: prefix,
 		Objects: make([]ObjectInfo, len(resp.Contents)),
 		Prefixes: make([]string, len(resp.CommonPrefixes)),
 	}
 	for ix, item := range resp.Contents {
 		result.Objects[ix] = ObjectInfo {
-			Workspace: workspace,
+			Workspace: workspace.Name,
 			WorkspaceKey: strings.Replace(*item.Key, s3prefix, "", 1),
 			SizeBytes: *item.Size,
 			LastModified: *item.LastModified,
@@ -124,63 +257,111 @@ func (self *SimpleManager) List(cx *SessionContext, workspaceIn string, prefix s
 	for ix, item := range resp.CommonPrefixes {
 		result.Prefixes[ix] = strings.Replace(*item.Prefix, s3prefix, "", 1)
 	}
+	if aws.BoolValue(resp.IsTruncated) {
+		result.NextPage = aws.StringValue(resp.NextContinuationToken)
+	}
 	return result, nil
 }
 
-// UploadUrl generates a presigned upload url
-// TODO - support multipart upload
-func (self *SimpleManager) UploadUrl(cx *SessionContext, workspaceIn string, key string) (string, error) {
-	if (workspaceIn != "@user") {
-		return "", fmt.Errorf("invalid workspace - currently only support personal workspaces")
+// UploadUrl generates a presigned upload url -
+// a single PUT is limited to 5 GB, so larger
+// objects should use the MultipartManager methods
+func (self *SimpleManager) UploadUrl(cx *SessionContext, workspaceIn string, key string, options UploadOptions) (string, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return "", err
+	}
+	if err := validateUploadOptions(self.config, options); err != nil {
+		return "", err
 	}
-	workspace := cx.User
-	s3path, err := MakeS3Path(self.config.BucketPrefix, workspace, key)
+	s3path, err := workspace.Path(key)
 	if err != nil {
 		return "", err
 	}
-	req, _ := self.s3client.PutObjectRequest(&s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket: &self.config.Bucket,
 		Key: &s3path,
-	})
-	log.Info().Str("Func", "UploadUrl").
-		Str("Workspace", workspace).
+	}
+	if options.SizeBytes > 0 {
+		// signs the content-length header
+		input.ContentLength = aws.Int64(options.SizeBytes)
+	}
+	// signs the content-type header
+	input.ContentType = aws.String(options.ContentTypeOrDefault(key))
+	if nil != options.Checksum {
+		// signs the x-amz-meta-checksum-* header
+		input.Metadata = aws.StringMap(options.Checksum.metadata())
+		if "md5" == options.Checksum.Algorithm {
+			// signs the content-md5 header - S3 rejects data that does not match
+			input.ContentMD5 = aws.String(options.Checksum.Value)
+		}
+	}
+	req, _ := self.s3client.PutObjectRequest(input)
+	if nil != options.Checksum && "sha256" == options.Checksum.Algorithm {
+		// signs the payload hash in place of UNSIGNED-PAYLOAD -
+		// S3 rejects data that does not match
+		req.HTTPRequest.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(options.Checksum.digest()))
+	}
+	log.Debug().Str("Func", "UploadUrl").
+		Str("Workspace", workspace.Name).
 		Str("Key", key).
 		Send()
-	return req.Presign(60 * time.Minute)
+	return req.Presign(urlTtl(self.config, options.Expires))
+}
+
+// uploadHeaders are the headers UploadUrl signs - a proxied
+// upload sends them with the upload url
+func (self *SimpleManager) uploadHeaders(key string, options UploadOptions) http.Header {
+	header := http.Header{}
+	header.Set("Content-Type", options.ContentTypeOrDefault(key))
+	if nil != options.Checksum {
+		header.Set("X-Amz-Meta-"+options.Checksum.metadataKey(), options.Checksum.Value)
+		switch options.Checksum.Algorithm {
+		case "md5":
+			header.Set("Content-MD5", options.Checksum.Value)
+		case "sha256":
+			header.Set("X-Amz-Content-Sha256", hex.EncodeToString(options.Checksum.digest()))
+		}
+	}
+	return header
 }
 
 // DownloadUrl generates a presigned download url
 // Use the range HTTP header to download range of bytes -
 //   https://docs.aws.amazon.com/AmazonS3/latest/dev/GettingObjectsUsingAPIs.html
-func (self *SimpleManager) DownloadUrl(cx *SessionContext, workspaceIn string, key string) (string, error) {
-	if (workspaceIn != "@user") {
-		return "", fmt.Errorf("invalid workspace - currently only support personal workspaces")
+func (self *SimpleManager) DownloadUrl(cx *SessionContext, workspaceIn string, key string, options DownloadOptions) (string, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return "", err
 	}
-	workspace := cx.User
-	s3path, err := MakeS3Path(self.config.BucketPrefix, workspace, key)
+	s3path, err := workspace.Path(key)
 	if err != nil {
 		return "", err
 	}
-	req, _ := self.s3client.GetObjectRequest(&s3.GetObjectInput{
+	input := &s3.GetObjectInput{
 		Bucket: &self.config.Bucket,
 		Key: &s3path,
-	})
-	log.Info().Str("Func", "DownloadUrl").
-		Str("Workspace", workspace).
+	}
+	if "" != options.ContentDisposition {
+		input.ResponseContentDisposition = aws.String(options.ContentDisposition)
+	}
+	req, _ := self.s3client.GetObjectRequest(input)
+	log.Debug().Str("Func", "DownloadUrl").
+		Str("Workspace", workspace.Name).
 		Str("Key", key).
 		Send()
-	return req.Presign(60 * time.Minute)
+	return req.Presign(urlTtl(self.config, options.Expires))
 }
 
 // DownloadUrl generates a presigned download url
 // Use the range HTTP header to download range of bytes -
 //   https://docs.aws.amazon.com/AmazonS3/latest/dev/GettingObjectsUsingAPIs.html
 func (self *SimpleManager) DeleteObject(cx *SessionContext, workspaceIn string, key string) (error) {
-	if (workspaceIn != "@user") {
-		return fmt.Errorf("invalid workspace - currently only support personal workspaces")
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
 	}
-	workspace := cx.User
-	s3path, err := MakeS3Path(self.config.BucketPrefix, workspace, key)
+	s3path, err := workspace.Path(key)
 	if err != nil {
 		return err
 	}
@@ -188,9 +369,203 @@ func (self *SimpleManager) DeleteObject(cx *SessionContext, workspaceIn string,
 		Bucket: &self.config.Bucket,
 		Key: &s3path,
 	})
-	log.Info().Str("Func", "DeleteObject").
-		Str("Workspace", workspace).
+	log.Debug().Str("Func", "DeleteObject").
+		Str("Workspace", workspace.Name).
 		Str("Key", key).
 		Send()
 	return err
 }
+
+// Stat returns the metadata of the given object from HeadObject
+func (self *SimpleManager) Stat(cx *SessionContext, workspaceIn string, key string) (*ObjectStat, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := self.s3client.HeadObject(&s3.HeadObjectInput{
+		Bucket: &self.config.Bucket,
+		Key: &s3path,
+	})
+	if s3NotFound(err) {
+		return nil, fmt.Errorf("%w - %v", ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := &ObjectStat{
+		ObjectInfo: ObjectInfo{
+			Workspace: workspace.Name,
+			WorkspaceKey: key,
+			SizeBytes: aws.Int64Value(resp.ContentLength),
+			LastModified: aws.TimeValue(resp.LastModified).UTC(),
+		},
+		ETag: strings.Trim(aws.StringValue(resp.ETag), "\""),
+		ContentType: aws.StringValue(resp.ContentType),
+		// S3 omits the storage class of STANDARD objects
+		StorageClass: "STANDARD",
+		Metadata: map[string]string{},
+	}
+	if nil != resp.StorageClass {
+		result.StorageClass = *resp.StorageClass
+	}
+	for name, value := range resp.Metadata {
+		result.Metadata[strings.ToLower(name)] = aws.StringValue(value)
+	}
+	result.Checksum = checksumFromMetadata(result.Metadata)
+	return result, nil
+}
+
+// DeletePrefix deletes every object under the given folder prefix
+// with DeleteObjects batches of up to 1000 keys
+func (self *SimpleManager) DeletePrefix(cx *SessionContext, workspaceIn string, prefix string) (*DeletePrefixResult, error) {
+	return deletePrefix(self, self.config, cx, workspaceIn, prefix)
+}
+
+func (self *SimpleManager) listFolders(s3path string, page string) ([]string, string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(self.config.Bucket),
+		Prefix: &s3path,
+		Delimiter: aws.String("/"),
+		MaxKeys: aws.Int64(MaxListLimit),
+	}
+	if page != "" {
+		input.ContinuationToken = aws.String(page)
+	}
+	resp, err := self.s3client.ListObjectsV2(input)
+	if err != nil {
+		return nil, "", err
+	}
+	result := make([]string, len(resp.CommonPrefixes))
+	for ix, item := range resp.CommonPrefixes {
+		result[ix] = aws.StringValue(item.Prefix)
+	}
+	if aws.BoolValue(resp.IsTruncated) {
+		return result, aws.StringValue(resp.NextContinuationToken), nil
+	}
+	return result, "", nil
+}
+
+func (self *SimpleManager) listTree(s3path string, page string) ([]storedObject, string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(self.config.Bucket),
+		Prefix: &s3path,
+		MaxKeys: aws.Int64(MaxListLimit),
+	}
+	if page != "" {
+		input.ContinuationToken = aws.String(page)
+	}
+	resp, err := self.s3client.ListObjectsV2(input)
+	if err != nil {
+		return nil, "", err
+	}
+	result := make([]storedObject, len(resp.Contents))
+	for ix, item := range resp.Contents {
+		result[ix] = storedObject{
+			Path: aws.StringValue(item.Key),
+			SizeBytes: aws.Int64Value(item.Size),
+			LastModified: aws.TimeValue(item.LastModified),
+		}
+	}
+	if aws.BoolValue(resp.IsTruncated) {
+		return result, aws.StringValue(resp.NextContinuationToken), nil
+	}
+	return result, "", nil
+}
+
+func (self *SimpleManager) putObject(s3path string, data []byte) error {
+	_, err := self.s3client.PutObject(&s3.PutObjectInput{
+		Bucket: &self.config.Bucket,
+		Key: &s3path,
+		Body: bytes.NewReader(data),
+		ContentType: aws.String(contentTypeByKey(s3path)),
+	})
+	return err
+}
+
+func (self *SimpleManager) getObject(s3path string) ([]byte, error) {
+	resp, err := self.s3client.GetObject(&s3.GetObjectInput{
+		Bucket: &self.config.Bucket,
+		Key: &s3path,
+	})
+	if s3NotFound(err) {
+		return nil, fmt.Errorf("%w - %v", ErrNotFound, s3path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (self *SimpleManager) deletePaths(s3paths []string) (map[string]error, error) {
+	failures := map[string]error{}
+	for start := 0; start < len(s3paths); start += MaxListLimit {
+		end := start + MaxListLimit
+		if end > len(s3paths) {
+			end = len(s3paths)
+		}
+		ids := make([]*s3.ObjectIdentifier, end-start)
+		for ix, path := range s3paths[start:end] {
+			ids[ix] = &s3.ObjectIdentifier{Key: aws.String(path)}
+		}
+		resp, err := self.s3client.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: &self.config.Bucket,
+			Delete: &s3.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range resp.Errors {
+			failures[aws.StringValue(item.Key)] = fmt.Errorf("%v - %v", aws.StringValue(item.Code), aws.StringValue(item.Message))
+		}
+	}
+	return failures, nil
+}
+
+// Usage sums the size and count of the objects in the workspace
+func (self *SimpleManager) Usage(cx *SessionContext, workspaceIn string) (*WorkspaceUsage, error) {
+	return workspaceUsage(self, self.config, cx, workspaceIn)
+}
+
+// headerObjectStat builds an ObjectStat from the response
+// headers of a HEAD request to a storage service -
+// user metadata headers start with metaPrefix, and
+// storageClassHeader names the storage class header
+func headerObjectStat(workspace string, key string, header http.Header, metaPrefix string, storageClassHeader string) *ObjectStat {
+	size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	lastModified, _ := http.ParseTime(header.Get("Last-Modified"))
+	result := &ObjectStat{
+		ObjectInfo: ObjectInfo{
+			Workspace:    workspace,
+			WorkspaceKey: key,
+			SizeBytes:    size,
+			LastModified: lastModified.UTC(),
+		},
+		ETag:         strings.Trim(header.Get("ETag"), "\""),
+		ContentType:  header.Get("Content-Type"),
+		StorageClass: header.Get(storageClassHeader),
+		Metadata:     map[string]string{},
+	}
+	for name, values := range header {
+		lowerName := strings.ToLower(name)
+		if strings.HasPrefix(lowerName, metaPrefix) && len(values) > 0 {
+			result.Metadata[strings.TrimPrefix(lowerName, metaPrefix)] = values[0]
+		}
+	}
+	result.Checksum = checksumFromMetadata(result.Metadata)
+	return result
+}
+
+// contentTypeByKey guesses an object's content type
+// from its key's extension for uploads that do not
+// declare one, and blobs stored without one
+func contentTypeByKey(key string) string {
+	if contentType := mime.TypeByExtension(path.Ext(key)); "" != contentType {
+		return contentType
+	}
+	return "application/octet-stream"
+}
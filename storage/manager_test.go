@@ -2,9 +2,12 @@ package storage
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 
@@ -15,26 +18,89 @@ var testFolder = "goTestSuite"
 var testUser = "goTestUser"
 var testSession = NewSessionContext(testUser)
 var singletonMgr Manager = nil
+var singletonAuthz Authorizer = nil
 
+// getTestMgr loads the manager configured by the
+// WS_STORAGE_TEST_CONFIG environment variable -
+// defaults to the in-memory backend, so the suite
+// runs without network access or credentials.
+// Set WS_STORAGE_TEST_CONFIG=../testData/testConfig.json
+// to run against S3.
 func getTestMgr(t *testing.T) (Manager, error) {
 	if nil != singletonMgr {
 		return singletonMgr, nil
 	}
 
-	config, err := LoadConfig("../testData/testConfig.json")
+	configPath := os.Getenv("WS_STORAGE_TEST_CONFIG")
+	if "" == configPath {
+		configPath = "../testData/testMemoryConfig.json"
+	}
+	config, err := LoadConfig(configPath)
 	if nil != err {
 		t.Error(fmt.Sprintf("failed to load config, got: %v", err))
 		return nil, err
 	}
+	// serve self-signed blob urls from a local test server
+	mux := http.NewServeMux()
+	if BackendS3 != config.Backend {
+		server := httptest.NewServer(mux)
+		config.BaseUrl = server.URL
+	}
 	mgr, err := NewManager(config)
 	if nil != err {
 		t.Error(fmt.Sprintf("failed to initialize storage manager, got: %v", err))
 		return nil, err
 	}
+	if handler, ok := mgr.(http.Handler); ok {
+		mux.Handle(BlobPathPrefix, handler)
+		if err := seedTestData(mgr); nil != err {
+			t.Error(fmt.Sprintf("failed to seed test data, got: %v", err))
+			return nil, err
+		}
+	}
+	authz, err := NewAuthorizer(config)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize authorizer, got: %v", err))
+		return nil, err
+	}
 	singletonMgr = mgr
+	singletonAuthz = authz
 	return mgr, nil
 }
 
+// getTestAuthz returns the authorizer configured with getTestMgr
+func getTestAuthz(t *testing.T) (Authorizer, error) {
+	if _, err := getTestMgr(t); nil != err {
+		return nil, err
+	}
+	return singletonAuthz, nil
+}
+
+// seedTestData uploads the objects that the S3 test setup in
+// doc/howto/devTest.md creates, for backends that start empty
+func seedTestData(mgr Manager) error {
+	for _, name := range []string{"x", "y", "z", "subfolder1/x"} {
+		uploadUrl, err := mgr.UploadUrl(testSession, "@user", testFolder + "/" + name, UploadOptions{})
+		if nil != err {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPut, uploadUrl, bytes.NewBufferString("some random stuff"))
+		if nil != err {
+			return err
+		}
+		req.Header.Set("Content-Type", contentTypeByKey(name))
+		resp, err := getTestHttpClient().Do(req)
+		if nil != err {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("non-200 upload response, got: %v", resp.StatusCode)
+		}
+	}
+	return nil
+}
+
 var singletonHttpClient *http.Client = nil;
 
 func getTestHttpClient() *http.Client {
@@ -80,6 +146,142 @@ func TestMgrMakePath(t *testing.T) {
 	}
 }
 
+// newStubS3Mgr makes an S3 manager that sends requests
+// to a local test server with the given handler
+func newStubS3Mgr(t *testing.T, handler http.Handler) (*SimpleManager, *httptest.Server, error) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "frickjack")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "frickjack")
+	server := httptest.NewServer(handler)
+	mgr, err := NewSimpleManager(&Config{
+		Backend: BackendS3,
+		Bucket: "ws-storage-test",
+		BucketPrefix: "ws-storage-testsuite",
+		Endpoint: server.URL,
+		Region: "us-east-1",
+		PathStyle: true,
+	})
+	if nil != err {
+		server.Close()
+		t.Error(fmt.Sprintf("failed to initialize storage manager, got: %v", err))
+		return nil, nil, err
+	}
+	return mgr, server, nil
+}
+
+func TestMgrCustomEndpoint(t *testing.T) {
+	requestPaths := []string{}
+	mgr, server, err := newStubS3Mgr(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestPaths = append(requestPaths, r.URL.Path)
+		fmt.Fprintf(w, `<ListBucketResult><IsTruncated>false</IsTruncated>
+			<Contents><Key>ws-storage-testsuite/goTestUser/x</Key><Size>3</Size><LastModified>2021-10-01T00:00:00.000Z</LastModified></Contents>
+		</ListBucketResult>`)
+	}))
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	cx := NewSessionContext(testUser)
+	uploadUrl, err := mgr.UploadUrl(cx, "@user", "x", UploadOptions{ContentType: "text/plain"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to generate upload url, got: %v", err))
+		return
+	}
+	expected := server.URL + "/ws-storage-test/ws-storage-testsuite/goTestUser/x?"
+	if !strings.HasPrefix(uploadUrl, expected) {
+		t.Error(fmt.Sprintf("upload url does not use endpoint: %v !~ %v", uploadUrl, expected))
+		return
+	}
+	// the content type is signed
+	if !strings.Contains(uploadUrl, "X-Amz-SignedHeaders=content-type%3Bhost") {
+		t.Error(fmt.Sprintf("upload url does not sign the content type: %v", uploadUrl))
+		return
+	}
+	downloadUrl, err := mgr.DownloadUrl(cx, "@user", "x", DownloadOptions{ContentDisposition: "attachment"})
+	if nil != err || !strings.Contains(downloadUrl, "response-content-disposition=attachment") {
+		t.Error(fmt.Sprintf("download url does not set the content disposition, got: %v, %v", downloadUrl, err))
+		return
+	}
+	info, err := mgr.List(cx, "@user", "", "", 0)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to list bucket, got: %v", err))
+		return
+	}
+	if len(requestPaths) != 1 || requestPaths[0] != "/ws-storage-test" {
+		t.Error(fmt.Sprintf("list did not use path style endpoint, got: %v", requestPaths))
+		return
+	}
+	if len(info.Objects) != 1 || info.Objects[0].WorkspaceKey != "x" || info.Objects[0].SizeBytes != 3 {
+		t.Error(fmt.Sprintf("unexpected listing, got: %v", info.Objects))
+		return
+	}
+}
+
+func TestMgrDefaultContentType(t *testing.T) {
+	uploadedTypes := []string{}
+	mgr, server, err := newStubS3Mgr(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if http.MethodPut == r.Method {
+			uploadedTypes = append(uploadedTypes, r.Header.Get("Content-Type"))
+		}
+	}))
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	cx := NewSessionContext(testUser)
+	// without a declared type the upload url signs the type of the key's extension
+	uploadUrl, err := mgr.UploadUrl(cx, "@user", "x.json", UploadOptions{})
+	if nil != err || !strings.Contains(uploadUrl, "X-Amz-SignedHeaders=content-type%3Bhost") {
+		t.Error(fmt.Sprintf("upload url does not sign the default content type, got: %v, %v", uploadUrl, err))
+		return
+	}
+	header := mgr.uploadHeaders("x.json", UploadOptions{})
+	if "application/json" != header.Get("Content-Type") {
+		t.Error(fmt.Sprintf("expected the signed content type to be application/json, got: %v", header))
+		return
+	}
+	proxy := NewObjectProxy(mgr, mgr.config)
+	if err := proxy.Upload(uploadUrl, header, strings.NewReader("{}"), 2); nil != err {
+		t.Error(fmt.Sprintf("failed upload, got: %v", err))
+		return
+	}
+	if len(uploadedTypes) != 1 || "application/json" != uploadedTypes[0] {
+		t.Error(fmt.Sprintf("expected the upload to send Content-Type: application/json, got: %v", uploadedTypes))
+	}
+}
+
+
+func TestMgrStat(t *testing.T) {
+	mgr, server, err := newStubS3Mgr(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || r.URL.Path != "/ws-storage-test/ws-storage-testsuite/goTestUser/x" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Length", "3")
+		w.Header().Set("Last-Modified", "Fri, 01 Oct 2021 00:00:00 GMT")
+		w.Header().Set("ETag", `"acbd18db4cc2f85cedef654fccc4a4d8"`)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("x-amz-storage-class", "GLACIER")
+		w.Header().Set("x-amz-meta-project", "lab1")
+	}))
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	cx := NewSessionContext(testUser)
+	stat, err := mgr.Stat(cx, "@user", "x")
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to stat object, got: %v", err))
+		return
+	}
+	if stat.SizeBytes != 3 || stat.ETag != "acbd18db4cc2f85cedef654fccc4a4d8" || stat.ContentType != "text/plain" ||
+		stat.StorageClass != "GLACIER" || stat.Metadata["project"] != "lab1" || stat.LastModified.Year() != 2021 {
+		t.Error(fmt.Sprintf("unexpected stat, got: %v", stat))
+		return
+	}
+	if _, err := mgr.Stat(cx, "@user", "y"); !errors.Is(err, ErrNotFound) {
+		t.Error(fmt.Sprintf("expected not found, got: %v", err))
+	}
+}
 
 func TestMgrList(t *testing.T) {
 	mgr, err := getTestMgr(t)
@@ -87,7 +289,7 @@ func TestMgrList(t *testing.T) {
 		return
 	}
 	cx := NewSessionContext(testUser)
-	info, err := mgr.List(cx, "@user", "", "")
+	info, err := mgr.List(cx, "@user", "", "", 0)
 	if nil != err {
 		t.Error(fmt.Sprintf("failed to list bucket, got: %v", err))
 		return
@@ -109,6 +311,85 @@ func TestMgrList(t *testing.T) {
 	}
 }
 
+func TestMgrListPaging(t *testing.T) {
+	mgr, err := getTestMgr(t)
+	if nil != err {
+		return
+	}
+	cx := NewSessionContext(testUser)
+	prefix := testFolder + "/"
+	page := ""
+	count := 0
+	for i := 0; i < 100; i += 1 {
+		info, err := mgr.List(cx, "@user", prefix, page, 1)
+		if nil != err {
+			t.Error(fmt.Sprintf("failed to list page %v, got: %v", i, err))
+			return
+		}
+		if len(info.Objects) + len(info.Prefixes) > 1 {
+			t.Error(fmt.Sprintf("page %v exceeds limit, got: %v, %v", i, info.Objects, info.Prefixes))
+			return
+		}
+		count += len(info.Objects) + len(info.Prefixes)
+		page = info.NextPage
+		if "" == page {
+			break
+		}
+	}
+	if count < 2 {
+		t.Error(fmt.Sprintf("expected multiple pages under %v, got: %v entries", prefix, count))
+		return
+	}
+	_, err = mgr.List(cx, "@user", prefix, "", MaxListLimit + 1)
+	if nil == err {
+		t.Error("list should reject a limit over MaxListLimit")
+		return
+	}
+}
+
+func TestMgrListPagingS3(t *testing.T) {
+	tokens := []string{}
+	mgr, server, err := newStubS3Mgr(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		tokens = append(tokens, query.Get("continuation-token"))
+		if "1" != query.Get("max-keys") {
+			http.Error(w, "unexpected max-keys "+query.Get("max-keys"), http.StatusBadRequest)
+			return
+		}
+		switch query.Get("continuation-token") {
+		case "":
+			fmt.Fprintf(w, `<ListBucketResult><IsTruncated>true</IsTruncated><NextContinuationToken>token-2</NextContinuationToken>
+				<Contents><Key>ws-storage-testsuite/goTestUser/x</Key><Size>3</Size><LastModified>2021-10-01T00:00:00.000Z</LastModified></Contents>
+			</ListBucketResult>`)
+		case "token-2":
+			fmt.Fprintf(w, `<ListBucketResult><IsTruncated>false</IsTruncated>
+				<Contents><Key>ws-storage-testsuite/goTestUser/y</Key><Size>3</Size><LastModified>2021-10-01T00:00:00.000Z</LastModified></Contents>
+			</ListBucketResult>`)
+		default:
+			http.Error(w, "unexpected continuation token", http.StatusBadRequest)
+		}
+	}))
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	cx := NewSessionContext(testUser)
+	first, err := mgr.List(cx, "@user", "", "", 1)
+	if nil != err || 1 != len(first.Objects) || "x" != first.Objects[0].WorkspaceKey || "token-2" != first.NextPage {
+		t.Error(fmt.Sprintf("unexpected first page, got: %v, %v", first, err))
+		return
+	}
+	// the next page token is sent back as the continuation token
+	second, err := mgr.List(cx, "@user", "", first.NextPage, 1)
+	if nil != err || 1 != len(second.Objects) || "y" != second.Objects[0].WorkspaceKey || "" != second.NextPage {
+		t.Error(fmt.Sprintf("unexpected second page, got: %v, %v", second, err))
+		return
+	}
+	if 2 != len(tokens) || "" != tokens[0] || "token-2" != tokens[1] {
+		t.Error(fmt.Sprintf("unexpected continuation tokens, got: %v", tokens))
+	}
+}
+
 
 func TestMgrUpDown(t *testing.T) {
 	key := "testObject.txt"
@@ -117,7 +398,7 @@ func TestMgrUpDown(t *testing.T) {
 		return
 	}
 	cx := NewSessionContext(testUser)
-	uploadUrl, err := mgr.UploadUrl(cx, "@user", key)
+	uploadUrl, err := mgr.UploadUrl(cx, "@user", key, UploadOptions{})
 	if nil != err {
 		t.Error(fmt.Sprintf("failed to generate upload url, got: %v", err))
 		return
@@ -129,6 +410,7 @@ func TestMgrUpDown(t *testing.T) {
 		testBytes := bytes.NewBufferString(testMessage)		
 		req, err := http.NewRequest(http.MethodPut, uploadUrl, testBytes)
 		req.ContentLength = int64(testBytes.Len())
+		req.Header.Set("Content-Type", contentTypeByKey(key))
 		if nil != err {
 			t.Error(fmt.Sprintf("%v failed to setup upload request, got: %v", i, err))
 			return
@@ -145,7 +427,7 @@ func TestMgrUpDown(t *testing.T) {
 		}
 	}
 	
-	downloadUrl, err := mgr.DownloadUrl(cx, "@user", key)
+	downloadUrl, err := mgr.DownloadUrl(cx, "@user", key, DownloadOptions{})
 	if nil != err {
 		t.Error(fmt.Sprintf("failed to generate download url, got: %v", err))
 		return
@@ -170,7 +452,7 @@ func TestMgrUpDown(t *testing.T) {
 	}
 
 	// List the test object
-	info, err := mgr.List(cx, "@user", key, "")
+	info, err := mgr.List(cx, "@user", key, "", 0)
 	if nil != err {
 		t.Error(fmt.Sprintf("failed to list object, got: %v", err))
 		return
@@ -188,7 +470,7 @@ func TestMgrUpDown(t *testing.T) {
 	}
 
 	// List again
-	info, err = mgr.List(cx, "@user", key, "")
+	info, err = mgr.List(cx, "@user", key, "", 0)
 	if nil != err {
 		t.Error(fmt.Sprintf("failed to list object, got: %v", err))
 		return
@@ -0,0 +1,385 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type memoryObject struct {
+	data         []byte
+	lastModified time.Time
+	metadata     map[string]string
+}
+
+// MemoryManager is a Manager that keeps objects in process memory.
+// It is intended for tests and local development - objects do not
+// survive a restart.
+// Upload and download urls are signed urls served by
+// the MemoryManager's own http handler under BlobPathPrefix.
+type MemoryManager struct {
+	config  *Config
+	signer  *blobSigner
+	lock    sync.RWMutex
+	objects map[string]*memoryObject
+}
+
+// NewMemoryManager makes a new in-memory manager with the given configuration
+func NewMemoryManager(config *Config) (*MemoryManager, error) {
+	signer, err := newBlobSigner(nil, config.BaseUrl)
+	if nil != err {
+		return nil, err
+	}
+	return &MemoryManager{
+		config:  config,
+		signer:  signer,
+		objects: map[string]*memoryObject{},
+	}, nil
+}
+
+// listEntries implements delimiter and paging semantics
+// like S3 ListObjectsV2 over a sorted list of keys.
+// Returns the objects and common prefixes on the page,
+// and the token for the next page.
+func listEntries(keys []string, s3path string, page string, limit int) (objects []string, prefixes []string, nextPage string) {
+	sort.Strings(keys)
+	lastPrefix := ""
+	count := 0
+	for _, key := range keys {
+		if !strings.HasPrefix(key, s3path) {
+			continue
+		}
+		entry := key
+		rest := key[len(s3path):]
+		isPrefix := false
+		if ix := strings.Index(rest, "/"); ix >= 0 {
+			entry = s3path + rest[:ix+1]
+			isPrefix = true
+			if entry == lastPrefix {
+				continue
+			}
+		}
+		if page != "" && entry <= page {
+			continue
+		}
+		if count == limit {
+			return objects, prefixes, lastEntry(objects, prefixes)
+		}
+		count += 1
+		if isPrefix {
+			prefixes = append(prefixes, entry)
+			lastPrefix = entry
+		} else {
+			objects = append(objects, entry)
+		}
+	}
+	return objects, prefixes, ""
+}
+
+func lastEntry(objects []string, prefixes []string) string {
+	last := ""
+	if len(objects) > 0 {
+		last = objects[len(objects)-1]
+	}
+	if len(prefixes) > 0 && prefixes[len(prefixes)-1] > last {
+		last = prefixes[len(prefixes)-1]
+	}
+	return last
+}
+
+// List the prefixes and objects under a given workspace and prefix
+func (self *MemoryManager) List(cx *SessionContext, workspaceIn string, prefix string, page string, limit int) (*ListResult, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 0 || limit > MaxListLimit {
+		return nil, invalidInputf("invalid limit - must be between 0 and %v, got %v", MaxListLimit, limit)
+	}
+	if limit == 0 {
+		limit = MaxListLimit
+	}
+	s3path, err := workspace.Path(prefix)
+	if err != nil {
+		return nil, err
+	}
+	s3prefix, err := workspace.Path("")
+	if err != nil {
+		return nil, err
+	}
+
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	keys := make([]string, 0, len(self.objects))
+	for key := range self.objects {
+		keys = append(keys, key)
+	}
+	objects, prefixes, nextPage := listEntries(keys, s3path, page, limit)
+
+	result := &ListResult{
+		Workspace: workspace.Name,
+		Prefix:    prefix,
+		Objects:   make([]ObjectInfo, len(objects)),
+		Prefixes:  make([]string, len(prefixes)),
+		NextPage:  nextPage,
+	}
+	for ix, key := range objects {
+		obj := self.objects[key]
+		result.Objects[ix] = ObjectInfo{
+			Workspace:    workspace.Name,
+			WorkspaceKey: strings.Replace(key, s3prefix, "", 1),
+			SizeBytes:    int64(len(obj.data)),
+			LastModified: obj.lastModified,
+		}
+	}
+	for ix, item := range prefixes {
+		result.Prefixes[ix] = strings.Replace(item, s3prefix, "", 1)
+	}
+	return result, nil
+}
+
+// UploadUrl generates a signed upload url served by ServeHTTP
+func (self *MemoryManager) UploadUrl(cx *SessionContext, workspaceIn string, key string, options UploadOptions) (string, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return "", err
+	}
+	if err := validateUploadOptions(self.config, options); err != nil {
+		return "", err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return "", err
+	}
+	log.Debug().Str("Func", "UploadUrl").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Send()
+	return self.signer.SignUrl(http.MethodPut, s3path, urlTtl(self.config, options.Expires), uploadConstraints(key, options)), nil
+}
+
+// DownloadUrl generates a signed download url served by ServeHTTP
+func (self *MemoryManager) DownloadUrl(cx *SessionContext, workspaceIn string, key string, options DownloadOptions) (string, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return "", err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return "", err
+	}
+	log.Debug().Str("Func", "DownloadUrl").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Send()
+	return self.signer.SignUrl(http.MethodGet, s3path, urlTtl(self.config, options.Expires), downloadConstraints(options)), nil
+}
+
+// DeleteObject removes the given object - deleting
+// an object that does not exist is not an error
+func (self *MemoryManager) DeleteObject(cx *SessionContext, workspaceIn string, key string) error {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return err
+	}
+	self.lock.Lock()
+	delete(self.objects, s3path)
+	self.lock.Unlock()
+	log.Debug().Str("Func", "DeleteObject").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Send()
+	return nil
+}
+
+// Stat returns the metadata of the given object -
+// the ETag is the MD5 of the object like a simple S3 upload
+func (self *MemoryManager) Stat(cx *SessionContext, workspaceIn string, key string) (*ObjectStat, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return nil, err
+	}
+	self.lock.RLock()
+	obj, ok := self.objects[s3path]
+	self.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w - %v", ErrNotFound, key)
+	}
+	sum := md5.Sum(obj.data)
+	contentType, metadata := splitBlobMetadata(key, obj.metadata)
+	return &ObjectStat{
+		ObjectInfo: ObjectInfo{
+			Workspace:    workspace.Name,
+			WorkspaceKey: key,
+			SizeBytes:    int64(len(obj.data)),
+			LastModified: obj.lastModified,
+			Checksum:     checksumFromMetadata(metadata),
+		},
+		ETag:         hex.EncodeToString(sum[:]),
+		ContentType:  contentType,
+		StorageClass: "STANDARD",
+		Metadata:     metadata,
+	}, nil
+}
+
+// Copy copies the source object to the destination key
+func (self *MemoryManager) Copy(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	return self.copyOrMove(cx, workspaceIn, srcKey, dstKey, false, false)
+}
+
+// Move renames the source object to the destination key
+func (self *MemoryManager) Move(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	return self.copyOrMove(cx, workspaceIn, srcKey, dstKey, true, false)
+}
+
+// moveExclusive renames the source object if the destination does not exist
+func (self *MemoryManager) moveExclusive(cx *SessionContext, workspaceIn string, srcKey string, dstKey string) error {
+	return self.copyOrMove(cx, workspaceIn, srcKey, dstKey, true, true)
+}
+
+func (self *MemoryManager) copyOrMove(cx *SessionContext, workspaceIn string, srcKey string, dstKey string, move bool, exclusive bool) error {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
+	}
+	if err := validateCopyKeys(srcKey, dstKey); nil != err {
+		return err
+	}
+	srcPath, err := workspace.Path(srcKey)
+	if err != nil {
+		return err
+	}
+	dstPath, err := workspace.Path(dstKey)
+	if err != nil {
+		return err
+	}
+	self.lock.Lock()
+	obj, ok := self.objects[srcPath]
+	if _, exists := self.objects[dstPath]; ok && exclusive && exists {
+		self.lock.Unlock()
+		return fmt.Errorf("%w - %v exists", ErrConflict, dstKey)
+	}
+	if ok {
+		// object data is never modified in place, so copies may share it
+		self.objects[dstPath] = &memoryObject{data: obj.data, lastModified: time.Now().UTC(), metadata: obj.metadata}
+		if move {
+			delete(self.objects, srcPath)
+		}
+	}
+	self.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("%w - %v", ErrNotFound, srcKey)
+	}
+	log.Debug().Str("Func", "Copy").
+		Str("Workspace", workspace.Name).
+		Str("Key", srcKey).
+		Str("Destination", dstKey).
+		Bool("Move", move).
+		Send()
+	return nil
+}
+
+// Usage sums the size and count of the objects in the workspace
+func (self *MemoryManager) Usage(cx *SessionContext, workspaceIn string) (*WorkspaceUsage, error) {
+	return workspaceUsage(self, self.config, cx, workspaceIn)
+}
+
+// DeletePrefix deletes every object under the given folder prefix
+func (self *MemoryManager) DeletePrefix(cx *SessionContext, workspaceIn string, prefix string) (*DeletePrefixResult, error) {
+	return deletePrefix(self, self.config, cx, workspaceIn, prefix)
+}
+
+// listTree returns every object under the path in one page
+func (self *MemoryManager) listTree(s3path string, page string) ([]storedObject, string, error) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	result := []storedObject{}
+	for key, obj := range self.objects {
+		if strings.HasPrefix(key, s3path) {
+			result = append(result, storedObject{Path: key, SizeBytes: int64(len(obj.data)), LastModified: obj.lastModified})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, "", nil
+}
+
+func (self *MemoryManager) listFolders(s3path string, page string) ([]string, string, error) {
+	self.lock.RLock()
+	keys := make([]string, 0, len(self.objects))
+	for key := range self.objects {
+		keys = append(keys, key)
+	}
+	self.lock.RUnlock()
+	_, prefixes, nextPage := listEntries(keys, s3path, page, MaxListLimit)
+	return prefixes, nextPage, nil
+}
+
+func (self *MemoryManager) putObject(s3path string, data []byte) error {
+	return self.writeBlob(s3path, bytes.NewReader(data), nil)
+}
+
+func (self *MemoryManager) getObject(s3path string) ([]byte, error) {
+	return readBlob(self, s3path)
+}
+
+func (self *MemoryManager) deletePaths(s3paths []string) (map[string]error, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, path := range s3paths {
+		delete(self.objects, path)
+	}
+	return map[string]error{}, nil
+}
+
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (self memoryReader) Close() error {
+	return nil
+}
+
+func (self *MemoryManager) openBlob(s3path string) (io.ReadSeekCloser, time.Time, map[string]string, error) {
+	self.lock.RLock()
+	obj, ok := self.objects[s3path]
+	self.lock.RUnlock()
+	if !ok {
+		return nil, time.Time{}, nil, os.ErrNotExist
+	}
+	return memoryReader{bytes.NewReader(obj.data)}, obj.lastModified, obj.metadata, nil
+}
+
+func (self *MemoryManager) writeBlob(s3path string, body io.Reader, metadata map[string]string) error {
+	data, err := ioutil.ReadAll(body)
+	if nil != err {
+		return err
+	}
+	self.lock.Lock()
+	self.objects[s3path] = &memoryObject{data: data, lastModified: time.Now().UTC(), metadata: metadata}
+	self.lock.Unlock()
+	return nil
+}
+
+// ServeHTTP serves the signed urls generated by UploadUrl and DownloadUrl
+func (self *MemoryManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveBlob(w, r, self.signer, self)
+}
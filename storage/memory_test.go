@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestListEntries(t *testing.T) {
+	keys := []string{
+		"p/u/b", "p/u/a", "p/u/c/1", "p/u/c/2", "p/u/d/", "p/u/d/1", "p/u/e", "p/v/a",
+	}
+	objects, prefixes, nextPage := listEntries(keys, "p/u/", "", 1000)
+	if !reflect.DeepEqual(objects, []string{"p/u/a", "p/u/b", "p/u/e"}) ||
+		!reflect.DeepEqual(prefixes, []string{"p/u/c/", "p/u/d/"}) || "" != nextPage {
+		t.Error(fmt.Sprintf("unexpected listing, got: %v, %v, %v", objects, prefixes, nextPage))
+		return
+	}
+
+	// page through two entries at a time
+	var pagedObjects, pagedPrefixes []string
+	page := ""
+	for i := 0; i < 10; i += 1 {
+		objects, prefixes, nextPage := listEntries(keys, "p/u/", page, 2)
+		if len(objects) + len(prefixes) > 2 {
+			t.Error(fmt.Sprintf("page %v exceeds limit, got: %v, %v", i, objects, prefixes))
+			return
+		}
+		pagedObjects = append(pagedObjects, objects...)
+		pagedPrefixes = append(pagedPrefixes, prefixes...)
+		page = nextPage
+		if "" == page {
+			break
+		}
+	}
+	if !reflect.DeepEqual(pagedObjects, []string{"p/u/a", "p/u/b", "p/u/e"}) ||
+		!reflect.DeepEqual(pagedPrefixes, []string{"p/u/c/", "p/u/d/"}) {
+		t.Error(fmt.Sprintf("unexpected paged listing, got: %v, %v", pagedObjects, pagedPrefixes))
+		return
+	}
+}
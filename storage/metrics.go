@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// unknownLabel labels requests rejected before their verb
+// or workspace is known, so label values stay bounded
+const unknownLabel = "unknown"
+
+var (
+	apiRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_storage_api_requests_total",
+		Help: "API requests by verb, workspace type, and status code",
+	}, []string{"verb", "workspace_type", "code"})
+
+	apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ws_storage_api_request_duration_seconds",
+		Help:    "API request latency by verb, workspace type, and status code",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"verb", "workspace_type", "code"})
+
+	presignedUrlsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_storage_presigned_urls_total",
+		Help: "Upload and download urls issued by verb",
+	}, []string{"verb"})
+
+	s3CallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_storage_s3_calls_total",
+		Help: "S3 api calls by operation",
+	}, []string{"operation"})
+
+	s3CallErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_storage_s3_call_errors_total",
+		Help: "Failed S3 api calls by operation and error code",
+	}, []string{"operation", "code"})
+
+	s3CallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ws_storage_s3_call_duration_seconds",
+		Help:    "S3 api call latency - including retries - by operation",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	trashPurgedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ws_storage_trash_purged_objects_total",
+		Help: "Expired trash objects deleted by the trash sweeper",
+	})
+
+	auditFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ws_storage_audit_failures_total",
+		Help: "API requests failed because their audit record could not be stored",
+	})
+
+	proxyBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ws_storage_proxy_bytes_total",
+		Help: "Object bytes streamed through the service by direction - upload or download",
+	}, []string{"direction"})
+
+	proxyTransfersInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ws_storage_proxy_transfers_in_flight",
+		Help: "Proxied uploads and downloads in progress",
+	})
+)
+
+// workspaceTypeLabels are the workspace_type label values -
+// other workspace types label as unknown, so clients cannot
+// grow the label set without bound
+var workspaceTypeLabels = map[string]bool{UserWorkspace: true}
+
+// RegisterMetricWorkspaceTypes adds the configured named workspace
+// types to the workspace_type label values -
+// call before serving requests
+func RegisterMetricWorkspaceTypes(config *Config) {
+	for wsType := range config.WorkspacePrefixes {
+		workspaceTypeLabels[wsType] = true
+	}
+}
+
+// observeApiRequest records an api request's outcome -
+// verb and workspace are empty if the request did not parse
+func observeApiRequest(verb string, workspace string, statusCode int, duration time.Duration) {
+	workspaceType, _ := SplitWorkspace(workspace)
+	if !workspaceTypeLabels[workspaceType] {
+		workspaceType = unknownLabel
+	}
+	if "" == verb {
+		verb = unknownLabel
+	}
+	code := strconv.Itoa(statusCode)
+	apiRequestsTotal.WithLabelValues(verb, workspaceType, code).Inc()
+	apiRequestDuration.WithLabelValues(verb, workspaceType, code).Observe(duration.Seconds())
+}
+
+// observeS3Call is an aws request Complete handler that records
+// each S3 call - presigning a url makes no call, so is not counted
+func observeS3Call(r *request.Request) {
+	operation := r.Operation.Name
+	s3CallsTotal.WithLabelValues(operation).Inc()
+	s3CallDuration.WithLabelValues(operation).Observe(time.Since(r.Time).Seconds())
+	if nil != r.Error {
+		code := unknownLabel
+		if awsErr, ok := r.Error.(awserr.Error); ok {
+			code = awsErr.Code()
+		}
+		s3CallErrorsTotal.WithLabelValues(operation, code).Inc()
+	}
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveApiRequest(t *testing.T) {
+	RegisterMetricWorkspaceTypes(&Config{WorkspacePrefixes: map[string]string{"@group": "ws-storage-groups"}})
+	testCases := []struct {
+		verb      string
+		workspace string
+		labels    []string
+	}{
+		{"list", "@user", []string{"list", "@user", "200"}},
+		{"stat", "@group/lab1", []string{"stat", "@group", "200"}},
+		{"stat", "@bogus/lab1", []string{"stat", unknownLabel, "200"}},
+		{"", "", []string{unknownLabel, unknownLabel, "200"}},
+	}
+	for _, it := range testCases {
+		before := testutil.ToFloat64(apiRequestsTotal.WithLabelValues(it.labels...))
+		observeApiRequest(it.verb, it.workspace, 200, time.Millisecond)
+		if after := testutil.ToFloat64(apiRequestsTotal.WithLabelValues(it.labels...)); after != before+1 {
+			t.Error(fmt.Sprintf("expected request count %v for %v, got: %v", before+1, it.labels, after))
+		}
+	}
+}
+
+func TestPresignedUrlMetric(t *testing.T) {
+	mgr, err := NewMemoryManager(&Config{BucketPrefix: "ws-storage-testsuite"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	before := testutil.ToFloat64(presignedUrlsTotal.WithLabelValues("upload"))
+	testUrl, _ := url.Parse("https://whatever/ws-storage/upload/@user/x")
+	req, _ := NewApiRequest(testUrl, http.MethodGet, testUser)
+	if result := req.HandleApiRequest(mgr, NewStaticAuthorizer(nil), nil, nil, nil); "ok" != result.Result {
+		t.Error(fmt.Sprintf("upload failed, got: %v", result.Result))
+		return
+	}
+	if after := testutil.ToFloat64(presignedUrlsTotal.WithLabelValues("upload")); after != before+1 {
+		t.Error(fmt.Sprintf("expected presigned url count %v, got: %v", before+1, after))
+	}
+}
+
+func TestS3CallMetrics(t *testing.T) {
+	mgr, server, err := newStubS3Mgr(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `<ListBucketResult><IsTruncated>false</IsTruncated></ListBucketResult>`)
+	}))
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	listBefore := testutil.ToFloat64(s3CallsTotal.WithLabelValues("ListObjectsV2"))
+	headErrorsBefore := testutil.ToFloat64(s3CallErrorsTotal.WithLabelValues("HeadObject", "NotFound"))
+	putBefore := testutil.ToFloat64(s3CallsTotal.WithLabelValues("PutObject"))
+	cx := NewSessionContext(testUser)
+	if _, err := mgr.List(cx, "@user", "", "", 0); nil != err {
+		t.Error(fmt.Sprintf("failed to list bucket, got: %v", err))
+		return
+	}
+	mgr.Stat(cx, "@user", "x")
+	mgr.UploadUrl(cx, "@user", "x", UploadOptions{})
+	if after := testutil.ToFloat64(s3CallsTotal.WithLabelValues("ListObjectsV2")); after != listBefore+1 {
+		t.Error(fmt.Sprintf("expected list call count %v, got: %v", listBefore+1, after))
+	}
+	if after := testutil.ToFloat64(s3CallErrorsTotal.WithLabelValues("HeadObject", "NotFound")); after != headErrorsBefore+1 {
+		t.Error(fmt.Sprintf("expected head error count %v, got: %v", headErrorsBefore+1, after))
+	}
+	// presigning an upload url makes no s3 call
+	if after := testutil.ToFloat64(s3CallsTotal.WithLabelValues("PutObject")); after != putBefore {
+		t.Error(fmt.Sprintf("expected put call count %v, got: %v", putBefore, after))
+	}
+}
@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/rs/zerolog/log"
+)
+
+// MaxPartNumber is the largest part number of a multipart upload
+const MaxPartNumber = 10000
+
+// MultipartUpload identifies an in-progress multipart upload
+type MultipartUpload struct {
+	Workspace    string
+	WorkspaceKey string
+	UploadId     string
+	Initiated    time.Time
+}
+
+// CompletedPart is the part number and ETag (from the part
+// upload response header) of an uploaded part
+type CompletedPart struct {
+	PartNumber int64
+	ETag       string
+}
+
+// UploadedPart is a part stored in an in-progress upload
+type UploadedPart struct {
+	PartNumber int64
+	ETag       string
+	SizeBytes  int64
+}
+
+// PartOptions are the constraints of a part upload url
+type PartOptions struct {
+	// SizeBytes is the declared size of the part - when set
+	// it is signed into the url, so the part must match it
+	SizeBytes int64
+	// Checksum is the declared md5 or sha256 digest of the part -
+	// when set it is signed into the url, so S3 rejects a part
+	// that does not match it
+	Checksum  *Checksum
+	// Expires is the requested url lifetime - 0 for the configured default
+	Expires   time.Duration
+}
+
+// MultipartManager is implemented by managers that support
+// uploading large objects in parts - each part is uploaded with
+// its own presigned url, then the upload is completed with
+// the list of part ETags.
+type MultipartManager interface {
+	CreateMultipartUpload(cx *SessionContext, workspaceIn string, key string) (*MultipartUpload, error)
+	UploadPartUrl(cx *SessionContext, workspaceIn string, key string, uploadId string, partNumber int64, options PartOptions) (string, error)
+	CompleteMultipartUpload(cx *SessionContext, workspaceIn string, key string, uploadId string, parts []CompletedPart) error
+	AbortMultipartUpload(cx *SessionContext, workspaceIn string, key string, uploadId string) error
+	ListParts(cx *SessionContext, workspaceIn string, key string, uploadId string) ([]UploadedPart, error)
+	ListMultipartUploads(cx *SessionContext, workspaceIn string, prefix string) ([]MultipartUpload, error)
+}
+
+// CreateMultipartUpload starts a multipart upload to the given key
+func (self *SimpleManager) CreateMultipartUpload(cx *SessionContext, workspaceIn string, key string) (*MultipartUpload, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := self.s3client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: &self.config.Bucket,
+		Key:    &s3path,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Str("Func", "CreateMultipartUpload").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Str("UploadId", aws.StringValue(resp.UploadId)).
+		Send()
+	return &MultipartUpload{
+		Workspace:    workspace.Name,
+		WorkspaceKey: key,
+		UploadId:     aws.StringValue(resp.UploadId),
+		Initiated:    time.Now().UTC(),
+	}, nil
+}
+
+// UploadPartUrl generates a presigned url to upload the given part
+func (self *SimpleManager) UploadPartUrl(cx *SessionContext, workspaceIn string, key string, uploadId string, partNumber int64, options PartOptions) (string, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return "", err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return "", err
+	}
+	if "" == uploadId {
+		return "", invalidInputf("invalid upload id - empty")
+	}
+	if partNumber < 1 || partNumber > MaxPartNumber {
+		return "", invalidInputf("invalid part number - must be between 1 and %v, got %v", MaxPartNumber, partNumber)
+	}
+	if err := validatePartOptions(self.config, options); err != nil {
+		return "", err
+	}
+	input := &s3.UploadPartInput{
+		Bucket:     &self.config.Bucket,
+		Key:        &s3path,
+		UploadId:   &uploadId,
+		PartNumber: &partNumber,
+	}
+	if options.SizeBytes > 0 {
+		// signs the content-length header
+		input.ContentLength = aws.Int64(options.SizeBytes)
+	}
+	if nil != options.Checksum && "md5" == options.Checksum.Algorithm {
+		// signs the content-md5 header - S3 rejects a part that does not match
+		input.ContentMD5 = aws.String(options.Checksum.Value)
+	}
+	req, _ := self.s3client.UploadPartRequest(input)
+	if nil != options.Checksum && "sha256" == options.Checksum.Algorithm {
+		// signs the payload hash in place of UNSIGNED-PAYLOAD
+		req.HTTPRequest.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(options.Checksum.digest()))
+	}
+	log.Debug().Str("Func", "UploadPartUrl").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Str("UploadId", uploadId).
+		Int64("PartNumber", partNumber).
+		Send()
+	return req.Presign(urlTtl(self.config, options.Expires))
+}
+
+// CompleteMultipartUpload assembles the given parts into the object
+func (self *SimpleManager) CompleteMultipartUpload(cx *SessionContext, workspaceIn string, key string, uploadId string, parts []CompletedPart) error {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return err
+	}
+	if "" == uploadId {
+		return invalidInputf("invalid upload id - empty")
+	}
+	if len(parts) == 0 {
+		return invalidInputf("invalid parts - no parts to complete")
+	}
+	// s3 requires parts in ascending order
+	sorted := make([]CompletedPart, len(parts))
+	copy(sorted, parts)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].PartNumber < sorted[j].PartNumber })
+	s3parts := make([]*s3.CompletedPart, len(sorted))
+	for ix, part := range sorted {
+		if part.PartNumber < 1 || part.PartNumber > MaxPartNumber || "" == part.ETag {
+			return invalidInputf("invalid part - %v", part)
+		}
+		s3parts[ix] = &s3.CompletedPart{
+			PartNumber: aws.Int64(part.PartNumber),
+			ETag:       aws.String(part.ETag),
+		}
+	}
+	_, err = self.s3client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          &self.config.Bucket,
+		Key:             &s3path,
+		UploadId:        &uploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: s3parts},
+	})
+	if s3NotFound(err) {
+		return fmt.Errorf("%w - upload %v", ErrNotFound, uploadId)
+	}
+	if err != nil {
+		return err
+	}
+	log.Debug().Str("Func", "CompleteMultipartUpload").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Str("UploadId", uploadId).
+		Int("Parts", len(parts)).
+		Send()
+	return nil
+}
+
+// AbortMultipartUpload discards an in-progress upload and its parts
+func (self *SimpleManager) AbortMultipartUpload(cx *SessionContext, workspaceIn string, key string, uploadId string) error {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return err
+	}
+	if "" == uploadId {
+		return invalidInputf("invalid upload id - empty")
+	}
+	_, err = self.s3client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   &self.config.Bucket,
+		Key:      &s3path,
+		UploadId: &uploadId,
+	})
+	if s3NotFound(err) {
+		return fmt.Errorf("%w - upload %v", ErrNotFound, uploadId)
+	}
+	if err != nil {
+		return err
+	}
+	log.Debug().Str("Func", "AbortMultipartUpload").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Str("UploadId", uploadId).
+		Send()
+	return nil
+}
+
+// ListParts lists the parts stored in an in-progress upload
+func (self *SimpleManager) ListParts(cx *SessionContext, workspaceIn string, key string, uploadId string) ([]UploadedPart, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return nil, err
+	}
+	if "" == uploadId {
+		return nil, invalidInputf("invalid upload id - empty")
+	}
+	result := []UploadedPart{}
+	err = self.s3client.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   &self.config.Bucket,
+		Key:      &s3path,
+		UploadId: &uploadId,
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, part := range page.Parts {
+			result = append(result, UploadedPart{
+				PartNumber: aws.Int64Value(part.PartNumber),
+				ETag:       aws.StringValue(part.ETag),
+				SizeBytes:  aws.Int64Value(part.Size),
+			})
+		}
+		return true
+	})
+	if s3NotFound(err) {
+		return nil, fmt.Errorf("%w - upload %v", ErrNotFound, uploadId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ListMultipartUploads lists the in-progress uploads under
+// the given prefix of the workspace - up to 1000
+func (self *SimpleManager) ListMultipartUploads(cx *SessionContext, workspaceIn string, prefix string) ([]MultipartUpload, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	s3path, err := workspace.Path(prefix)
+	if err != nil {
+		return nil, err
+	}
+	s3prefix, err := workspace.Path("")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := self.s3client.ListMultipartUploads(&s3.ListMultipartUploadsInput{
+		Bucket: &self.config.Bucket,
+		Prefix: &s3path,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]MultipartUpload, len(resp.Uploads))
+	for ix, item := range resp.Uploads {
+		result[ix] = MultipartUpload{
+			Workspace:    workspace.Name,
+			WorkspaceKey: strings.Replace(aws.StringValue(item.Key), s3prefix, "", 1),
+			UploadId:     aws.StringValue(item.UploadId),
+			Initiated:    aws.TimeValue(item.Initiated),
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultipartApiRequest(t *testing.T) {
+	testCases := [][]string{
+		{http.MethodPost, "multipart/@user/big.bam", "multipart-create"},
+		{http.MethodGet, "multipart/@user/big.bam?uploadId=abc&partNumber=2", "multipart-part"},
+		{http.MethodPost, "multipart/@user/big.bam?uploadId=abc", "multipart-complete"},
+		{http.MethodDelete, "multipart/@user/big.bam?uploadId=abc", "multipart-abort"},
+		{http.MethodGet, "multipart/@user/folder/", "multipart-list"},
+	}
+	for _, it := range testCases {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it[1])
+		req, err := NewApiRequest(testUrl, it[0], testUser)
+		if nil != err {
+			t.Error(fmt.Sprintf("unexpected %v %v failed validation, got: %v", it[0], it[1], err))
+			return
+		}
+		if it[2] != req.Verb {
+			t.Error(fmt.Sprintf("unexpected %v %v verb, got: %v", it[0], it[1], req.Verb))
+			return
+		}
+	}
+	invalidTests := [][]string{
+		{http.MethodDelete, "multipart/@user/big.bam"},
+		{http.MethodGet, "multipart/@user/big.bam?uploadId=abc"},
+		{http.MethodGet, "multipart/@user/big.bam?uploadId=abc&partNumber=10001"},
+		{http.MethodPut, "multipart/@user/big.bam?uploadId=abc"},
+	}
+	for _, it := range invalidTests {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it[1])
+		if _, err := NewApiRequest(testUrl, it[0], testUser); nil == err {
+			t.Error(fmt.Sprintf("%v %v should have failed validation", it[0], it[1]))
+			return
+		}
+	}
+}
+
+func TestMultipartMgr(t *testing.T) {
+	objectPath := "/ws-storage-test/ws-storage-testsuite/goTestUser/big.bam"
+	completeBody := ""
+	aborted := false
+	mgr, server, err := newStubS3Mgr(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		_, isUploads := query["uploads"]
+		switch {
+		case r.Method == http.MethodPost && isUploads && r.URL.Path == objectPath:
+			fmt.Fprintf(w, `<InitiateMultipartUploadResult><UploadId>abc</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPost && query.Get("uploadId") == "abc" && r.URL.Path == objectPath:
+			body, _ := ioutil.ReadAll(r.Body)
+			completeBody = string(body)
+			fmt.Fprintf(w, `<CompleteMultipartUploadResult><ETag>"xyz"</ETag></CompleteMultipartUploadResult>`)
+		case r.Method == http.MethodDelete && query.Get("uploadId") == "abc" && r.URL.Path == objectPath:
+			aborted = true
+			w.WriteHeader(http.StatusNoContent)
+		case query.Get("uploadId") == "gone":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `<Error><Code>NoSuchUpload</Code><Message>The specified upload does not exist.</Message></Error>`)
+		case r.Method == http.MethodGet && isUploads && query.Get("prefix") == "ws-storage-testsuite/goTestUser/":
+			fmt.Fprintf(w, `<ListMultipartUploadsResult><Upload><Key>ws-storage-testsuite/goTestUser/big.bam</Key><UploadId>abc</UploadId><Initiated>2021-10-01T00:00:00.000Z</Initiated></Upload></ListMultipartUploadsResult>`)
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	cx := NewSessionContext(testUser)
+
+	upload, err := mgr.CreateMultipartUpload(cx, "@user", "big.bam")
+	if nil != err || upload.UploadId != "abc" || upload.WorkspaceKey != "big.bam" {
+		t.Error(fmt.Sprintf("failed to create multipart upload, got: %v, %v", upload, err))
+		return
+	}
+	partUrl, err := mgr.UploadPartUrl(cx, "@user", "big.bam", "abc", 2, PartOptions{Expires: 5 * time.Minute})
+	if nil != err || !strings.HasPrefix(partUrl, server.URL+objectPath+"?") ||
+		!strings.Contains(partUrl, "partNumber=2") || !strings.Contains(partUrl, "uploadId=abc") ||
+		!strings.Contains(partUrl, "X-Amz-Expires=300") {
+		t.Error(fmt.Sprintf("unexpected part url, got: %v, %v", partUrl, err))
+		return
+	}
+	if _, err := mgr.UploadPartUrl(cx, "@user", "../big.bam", "abc", 2, PartOptions{}); nil == err {
+		t.Error("part url should validate the key")
+		return
+	}
+	err = mgr.CompleteMultipartUpload(cx, "@user", "big.bam", "abc", []CompletedPart{
+		{PartNumber: 2, ETag: `"e2"`}, {PartNumber: 1, ETag: `"e1"`},
+	})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to complete multipart upload, got: %v", err))
+		return
+	}
+	if ix1, ix2 := strings.Index(completeBody, "e1"), strings.Index(completeBody, "e2"); ix1 < 0 || ix2 < ix1 {
+		t.Error(fmt.Sprintf("complete should send sorted parts, got: %v", completeBody))
+		return
+	}
+	if err := mgr.CompleteMultipartUpload(cx, "@user", "big.bam", "abc", nil); nil == err {
+		t.Error("complete should require parts")
+		return
+	}
+	err = mgr.CompleteMultipartUpload(cx, "@user", "big.bam", "gone", []CompletedPart{{PartNumber: 1, ETag: `"e1"`}})
+	if !errors.Is(err, ErrNotFound) {
+		t.Error(fmt.Sprintf("complete of an unknown upload should be not found, got: %v", err))
+		return
+	}
+	if err := mgr.AbortMultipartUpload(cx, "@user", "big.bam", "gone"); !errors.Is(err, ErrNotFound) {
+		t.Error(fmt.Sprintf("abort of an unknown upload should be not found, got: %v", err))
+		return
+	}
+	uploads, err := mgr.ListMultipartUploads(cx, "@user", "")
+	if nil != err || len(uploads) != 1 || uploads[0].WorkspaceKey != "big.bam" || uploads[0].UploadId != "abc" {
+		t.Error(fmt.Sprintf("unexpected upload listing, got: %v, %v", uploads, err))
+		return
+	}
+	if err := mgr.AbortMultipartUpload(cx, "@user", "big.bam", "abc"); nil != err || !aborted {
+		t.Error(fmt.Sprintf("failed to abort multipart upload, got: %v", err))
+		return
+	}
+
+	// the api layer routes to the multipart manager
+	testUrl, _ := url.Parse("https://whatever/ws-storage/multipart/@user/big.bam")
+	req, err := NewApiRequest(testUrl, http.MethodPost, testUser)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to parse api request, got: %v", err))
+		return
+	}
+	authz := NewStaticAuthorizer(nil)
+	result := req.HandleApiRequest(mgr, authz, nil, nil, nil)
+	if "ok" != result.Result || result.Data.(*MultipartUpload).UploadId != "abc" {
+		t.Error(fmt.Sprintf("unexpected api result, got: %v", result))
+		return
+	}
+	memMgr, _ := NewMemoryManager(&Config{Backend: BackendMemory})
+	if result := req.HandleApiRequest(memMgr, authz, nil, nil, nil); "ok" == result.Result {
+		t.Error("multipart should fail on backends without multipart support")
+		return
+	}
+}
+
+// TestMultipartQuota declares a small size when it starts an
+// upload, then completes it with larger parts
+func TestMultipartQuota(t *testing.T) {
+	objectPath := "/ws-storage-test/ws-storage-testsuite/goTestUser/big.bam"
+	partSize := int64(60)
+	completed := false
+	mgr, server, err := newStubS3Mgr(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		_, isUploads := query["uploads"]
+		switch {
+		case r.Method == http.MethodGet && query.Get("list-type") == "2":
+			fmt.Fprintf(w, `<ListBucketResult><IsTruncated>false</IsTruncated></ListBucketResult>`)
+		case r.Method == http.MethodPost && isUploads && r.URL.Path == objectPath:
+			fmt.Fprintf(w, `<InitiateMultipartUploadResult><UploadId>abc</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodGet && query.Get("uploadId") == "abc" && r.URL.Path == objectPath:
+			fmt.Fprintf(w, `<ListPartsResult><IsTruncated>false</IsTruncated>`+
+				`<Part><PartNumber>1</PartNumber><ETag>"e1"</ETag><Size>%v</Size></Part>`+
+				`<Part><PartNumber>2</PartNumber><ETag>"e2"</ETag><Size>%v</Size></Part></ListPartsResult>`, partSize, partSize)
+		case r.Method == http.MethodPost && query.Get("uploadId") == "abc" && r.URL.Path == objectPath:
+			completed = true
+			fmt.Fprintf(w, `<CompleteMultipartUploadResult><ETag>"xyz"</ETag></CompleteMultipartUploadResult>`)
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	quota := NewQuotaEnforcer(&QuotaConfig{MaxBytes: 100})
+	authz := NewStaticAuthorizer(nil)
+	parts := []CompletedPart{{PartNumber: 1, ETag: `"e1"`}, {PartNumber: 2, ETag: `"e2"`}}
+
+	testUrl, _ := url.Parse("https://whatever/ws-storage/multipart/@user/big.bam?size=10")
+	req, _ := NewApiRequest(testUrl, http.MethodPost, testUser)
+	if result := req.HandleApiRequest(mgr, authz, quota, nil, nil); "ok" != result.Result {
+		t.Error(fmt.Sprintf("failed to create multipart upload, got: %v", result.Result))
+		return
+	}
+	testUrl, _ = url.Parse("https://whatever/ws-storage/multipart/@user/big.bam?uploadId=abc")
+	req, _ = NewApiRequest(testUrl, http.MethodPost, testUser)
+	req.Parts = parts
+	result := req.HandleApiRequest(mgr, authz, quota, nil, nil)
+	if !strings.HasPrefix(result.Result, "error - quota exceeded") || completed {
+		t.Error(fmt.Sprintf("completing parts over the quota should fail, got: %v, %v", result.Result, completed))
+		return
+	}
+	// parts that fit complete - the reservation made at create does not count twice
+	partSize = 45
+	req, _ = NewApiRequest(testUrl, http.MethodPost, testUser)
+	req.Parts = parts
+	if result := req.HandleApiRequest(mgr, authz, quota, nil, nil); "ok" != result.Result || !completed {
+		t.Error(fmt.Sprintf("failed to complete multipart upload within the quota, got: %v", result.Result))
+	}
+}
+
+func TestMultipartUrlConstraints(t *testing.T) {
+	mgr, server, err := newStubS3Mgr(t, http.NotFoundHandler())
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	mgr.config.Urls = &UrlConfig{RequireSize: true}
+	cx := NewSessionContext(testUser)
+	if _, err := mgr.UploadPartUrl(cx, "@user", "big.bam", "abc", 1, PartOptions{}); !errors.Is(err, ErrInvalidInput) {
+		t.Error(fmt.Sprintf("part url without a size should be invalid, got: %v", err))
+		return
+	}
+	partUrl, err := mgr.UploadPartUrl(cx, "@user", "big.bam", "abc", 1, PartOptions{SizeBytes: 5})
+	if nil != err || !strings.Contains(partUrl, "X-Amz-SignedHeaders=content-length%3Bhost&") {
+		t.Error(fmt.Sprintf("part url does not sign the size, got: %v, %v", partUrl, err))
+		return
+	}
+
+	mgr.config.Urls = &UrlConfig{RequireSize: true, RequireChecksum: true}
+	testCases := []struct {
+		checksum      *Checksum
+		signedHeaders string
+	}{
+		{testChecksum("md5", "a,b,c"), "content-length%3Bcontent-md5%3Bhost"},
+		{testChecksum("sha256", "a,b,c"), "content-length%3Bhost%3Bx-amz-content-sha256"},
+	}
+	for _, it := range testCases {
+		partUrl, err := mgr.UploadPartUrl(cx, "@user", "big.bam", "abc", 1, PartOptions{SizeBytes: 5, Checksum: it.checksum})
+		if nil != err || !strings.Contains(partUrl, "X-Amz-SignedHeaders="+it.signedHeaders+"&") {
+			t.Error(fmt.Sprintf("part url does not sign the %v checksum, got: %v, %v", it.checksum.Algorithm, partUrl, err))
+			return
+		}
+	}
+	if _, err := mgr.UploadPartUrl(cx, "@user", "big.bam", "abc", 1, PartOptions{SizeBytes: 5}); !errors.Is(err, ErrInvalidInput) {
+		t.Error(fmt.Sprintf("part url without a checksum should be invalid, got: %v", err))
+		return
+	}
+	if _, err := mgr.UploadPartUrl(cx, "@user", "big.bam", "abc", 1, PartOptions{SizeBytes: 5, Checksum: testChecksum("crc32c", "a,b,c")}); !errors.Is(err, ErrInvalidInput) {
+		t.Error(fmt.Sprintf("part url with a checksum S3 cannot verify should be invalid, got: %v", err))
+		return
+	}
+
+	// the api layer passes the part's size and checksum
+	testUrl, _ := url.Parse("https://whatever/ws-storage/multipart/@user/big.bam?uploadId=abc&partNumber=1&size=5&contentMD5=" + url.QueryEscape(testChecksum("md5", "a,b,c").Value))
+	req, err := NewApiRequest(testUrl, http.MethodGet, testUser)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to parse api request, got: %v", err))
+		return
+	}
+	authz := NewStaticAuthorizer(nil)
+	if result := req.HandleApiRequest(mgr, authz, nil, nil, nil); "ok" != result.Result || !strings.Contains(result.Data.(string), "content-md5") {
+		t.Error(fmt.Sprintf("unexpected api result, got: %v", result))
+		return
+	}
+	req.SizeBytes = 0
+	if result := req.HandleApiRequest(mgr, authz, nil, nil, nil); nil == result.Error || ErrorCodeInvalidInput != result.Error.Code {
+		t.Error(fmt.Sprintf("expected a part request without a size to be invalid, got: %v", result))
+	}
+}
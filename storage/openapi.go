@@ -0,0 +1,37 @@
+package storage
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// OpenApiPath is the endpoint that serves the OpenAPI 3 description of the api
+const OpenApiPath = "/ws-storage/openapi.json"
+
+// openApiSpec describes every endpoint - keep it in sync
+// with httpRoutes and apiVerbMethods (openapi_test checks)
+//go:embed openapi.json
+var openApiSpec []byte
+
+// openApiPaths lists the paths described by the spec
+func openApiPaths() ([]string, error) {
+	spec := struct {
+		Paths map[string]interface{} `json:"paths"`
+	}{}
+	if err := json.Unmarshal(openApiSpec, &spec); nil != err {
+		return nil, err
+	}
+	result := make([]string, 0, len(spec.Paths))
+	for path := range spec.Paths {
+		result = append(result, path)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func openApiHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openApiSpec)
+}
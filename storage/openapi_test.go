@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type openApiDoc struct {
+	Paths      map[string]map[string]interface{}            `json:"paths"`
+	Components map[string]map[string]map[string]interface{} `json:"components"`
+}
+
+func loadOpenApiDoc(t *testing.T) *openApiDoc {
+	doc := &openApiDoc{}
+	if err := json.Unmarshal(openApiSpec, doc); nil != err {
+		t.Fatal(fmt.Sprintf("failed to parse openapi.json, got: %v", err))
+	}
+	return doc
+}
+
+// specPath finds the spec path for the given endpoint prefix
+func (self *openApiDoc) specPath(prefix string) (string, bool) {
+	for path := range self.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func TestOpenApiRoutes(t *testing.T) {
+	doc := loadOpenApiDoc(t)
+	mgr, err := NewMemoryManager(&Config{BucketPrefix: "ws-storage-testsuite"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to create memory manager, got: %v", err))
+		return
+	}
+	patterns := []string{UsageReportPath, OpenApiPath}
+	for pattern := range httpRoutes(mgr) {
+		patterns = append(patterns, pattern)
+	}
+	for _, pattern := range patterns {
+		if "/ws-storage/" == pattern {
+			continue
+		}
+		if _, ok := doc.specPath(pattern); !ok {
+			t.Error(fmt.Sprintf("route %v is missing from openapi.json", pattern))
+		}
+	}
+	for verb, methods := range apiVerbMethods {
+		path, ok := doc.specPath("/ws-storage/" + verb + "/")
+		if !ok {
+			t.Error(fmt.Sprintf("verb %v is missing from openapi.json", verb))
+			continue
+		}
+		for _, method := range methods {
+			if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+				t.Error(fmt.Sprintf("%v %v is missing from openapi.json", method, path))
+			}
+		}
+	}
+}
+
+// collectRefs gathers every $ref in the given json value
+func collectRefs(value interface{}, refs map[string]bool) {
+	switch it := value.(type) {
+	case map[string]interface{}:
+		for key, child := range it {
+			if ref, ok := child.(string); ok && "$ref" == key {
+				refs[ref] = true
+			}
+			collectRefs(child, refs)
+		}
+	case []interface{}:
+		for _, child := range it {
+			collectRefs(child, refs)
+		}
+	}
+}
+
+func TestOpenApiRefs(t *testing.T) {
+	doc := loadOpenApiDoc(t)
+	var spec interface{}
+	json.Unmarshal(openApiSpec, &spec)
+	refs := map[string]bool{}
+	collectRefs(spec, refs)
+	for ref := range refs {
+		tokens := strings.Split(ref, "/")
+		if len(tokens) != 4 || "#" != tokens[0] || "components" != tokens[1] {
+			t.Error(fmt.Sprintf("unexpected $ref %v", ref))
+			continue
+		}
+		if _, ok := doc.Components[tokens[2]][tokens[3]]; !ok {
+			t.Error(fmt.Sprintf("unresolved $ref %v", ref))
+		}
+	}
+}
+
+// jsonFields lists the json field names of a struct type
+func jsonFields(structType reflect.Type) []string {
+	result := []string{}
+	for i := 0; i < structType.NumField(); i += 1 {
+		field := structType.Field(i)
+		if field.Anonymous {
+			result = append(result, jsonFields(field.Type)...)
+			continue
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; "" != tag {
+			name = tag
+		}
+		if "-" != name {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// schemaFields lists the properties of a component schema
+// including those of the schemas it extends with allOf
+func (self *openApiDoc) schemaFields(schema map[string]interface{}) []string {
+	result := []string{}
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		for name := range properties {
+			result = append(result, name)
+		}
+	}
+	if parts, ok := schema["allOf"].([]interface{}); ok {
+		for _, part := range parts {
+			partSchema := part.(map[string]interface{})
+			if ref, ok := partSchema["$ref"].(string); ok {
+				partSchema = self.Components["schemas"][strings.TrimPrefix(ref, "#/components/schemas/")]
+			}
+			result = append(result, self.schemaFields(partSchema)...)
+		}
+	}
+	return result
+}
+
+func TestOpenApiSchemas(t *testing.T) {
+	doc := loadOpenApiDoc(t)
+	testCases := map[string]interface{}{
+		"ApiResult":          ApiResult{},
+		"ApiError":           ApiError{},
+		"ObjectInfo":         ObjectInfo{},
+		"ObjectStat":         ObjectStat{},
+		"Checksum":           Checksum{},
+		"ObjectVersion":      ObjectVersion{},
+		"ListResult":         ListResult{},
+		"DeleteFailure":      DeleteFailure{},
+		"DeletePrefixResult": DeletePrefixResult{},
+		"TrashItem":          TrashItem{},
+		"TrashListResult":    TrashListResult{},
+		"WorkspaceUsage":     WorkspaceUsage{},
+		"MultipartUpload":    MultipartUpload{},
+		"CompletedPart":      CompletedPart{},
+		"UserUsage":          UserUsage{},
+		"UsageReport":        UsageReport{},
+	}
+	for name, value := range testCases {
+		schema, ok := doc.Components["schemas"][name]
+		if !ok {
+			t.Error(fmt.Sprintf("schema %v is missing from openapi.json", name))
+			continue
+		}
+		expected := jsonFields(reflect.TypeOf(value))
+		actual := doc.schemaFields(schema)
+		sort.Strings(expected)
+		sort.Strings(actual)
+		if !reflect.DeepEqual(expected, actual) {
+			t.Error(fmt.Sprintf("schema %v does not match its type, expected: %v, got: %v", name, expected, actual))
+		}
+	}
+}
+
+func TestOpenApiHandlers(t *testing.T) {
+	rec := httptest.NewRecorder()
+	openApiHandler(rec, httptest.NewRequest(http.MethodGet, OpenApiPath, nil))
+	if "application/json" != rec.Header().Get("Content-Type") || !json.Valid(rec.Body.Bytes()) {
+		t.Error(fmt.Sprintf("unexpected openapi response, got: %v", rec.Header().Get("Content-Type")))
+		return
+	}
+
+	rec = httptest.NewRecorder()
+	infoHandler(rec, httptest.NewRequest(http.MethodGet, "/ws-storage/info", nil))
+	info := map[string][]string{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); nil != err {
+		t.Error(fmt.Sprintf("failed to parse info response, got: %v", err))
+		return
+	}
+	found := map[string]bool{}
+	for _, endpoint := range info["endpoints"] {
+		found[endpoint] = true
+	}
+	for _, endpoint := range []string{OpenApiPath, "/ws-storage/list/{workspace}/{prefix}", "/ws-storage/healthy"} {
+		if !found[endpoint] {
+			t.Error(fmt.Sprintf("info is missing endpoint %v, got: %v", endpoint, info["endpoints"]))
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// storedObject is an object's full path in the bucket
+// with its size and modification time
+type storedObject struct {
+	Path         string
+	SizeBytes    int64
+	LastModified time.Time
+}
+
+// treeStore is implemented by backends to list every object
+// under a path - without a delimiter - and delete objects in bulk
+type treeStore interface {
+	// listTree returns a page of the objects under the given
+	// path, and the token for the next page - empty on the last page
+	listTree(s3path string, page string) ([]storedObject, string, error)
+	// listFolders returns a page of the folder paths directly under
+	// the given folder path, and the token for the next page
+	listFolders(s3path string, page string) ([]string, string, error)
+	// deletePaths deletes the given objects, and returns
+	// the per-path failures
+	deletePaths(s3paths []string) (map[string]error, error)
+	// putObject creates or replaces the object at the given path
+	putObject(s3path string, data []byte) error
+	// getObject reads the object at the given path - fails
+	// with ErrNotFound if it does not exist
+	getObject(s3path string) ([]byte, error)
+}
+
+// DeleteFailure is an object that a prefix delete failed to delete
+type DeleteFailure struct {
+	WorkspaceKey string
+	Error        string
+}
+
+// DeletePrefixResult reports the outcome of a recursive prefix delete
+type DeletePrefixResult struct {
+	Workspace    string
+	Prefix       string
+	DeletedCount int
+	Failures     []DeleteFailure
+}
+
+// deletePrefix deletes every object under the given folder
+// prefix of the workspace a page at a time
+func deletePrefix(store treeStore, config *Config, cx *SessionContext, workspaceIn string, prefix string) (*DeletePrefixResult, error) {
+	workspace, err := resolveWorkspace(config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	// a recursive delete must name a folder - never the whole workspace
+	if "" == prefix || !strings.HasSuffix(prefix, "/") {
+		return nil, invalidInputf("invalid prefix - a recursive delete requires a folder prefix ending in /, got %v", prefix)
+	}
+	s3path, err := workspace.Path(prefix)
+	if err != nil {
+		return nil, err
+	}
+	s3prefix, err := workspace.Path("")
+	if err != nil {
+		return nil, err
+	}
+	result := &DeletePrefixResult{
+		Workspace: workspace.Name,
+		Prefix:    prefix,
+		Failures:  []DeleteFailure{},
+	}
+	page := ""
+	for {
+		objects, nextPage, err := store.listTree(s3path, page)
+		if err != nil {
+			return nil, err
+		}
+		paths := make([]string, len(objects))
+		for ix, item := range objects {
+			paths[ix] = item.Path
+		}
+		if len(paths) > 0 {
+			failures, err := store.deletePaths(paths)
+			if err != nil {
+				return nil, err
+			}
+			for _, path := range paths {
+				if failure, ok := failures[path]; ok {
+					result.Failures = append(result.Failures, DeleteFailure{
+						WorkspaceKey: strings.Replace(path, s3prefix, "", 1),
+						Error:        failure.Error(),
+					})
+				} else {
+					result.DeletedCount += 1
+				}
+			}
+		}
+		if "" == nextPage {
+			break
+		}
+		page = nextPage
+	}
+	log.Debug().Str("Func", "DeletePrefix").
+		Str("Workspace", workspace.Name).
+		Str("Prefix", prefix).
+		Int("DeletedCount", result.DeletedCount).
+		Int("FailedCount", len(result.Failures)).
+		Send()
+	return result, nil
+}
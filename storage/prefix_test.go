@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDeletePrefixApiRequest(t *testing.T) {
+	testUrl, _ := url.Parse("https://whatever/ws-storage/list/@user/outputs/?recursive=true")
+	req, err := NewApiRequest(testUrl, http.MethodDelete, testUser)
+	if nil != err || "deleteprefix" != req.Verb || "outputs/" != req.Key {
+		t.Error(fmt.Sprintf("unexpected recursive delete request, got: %v, %v", req, err))
+		return
+	}
+	req, err = NewApiRequest(testUrl, http.MethodGet, testUser)
+	if nil != err || "list" != req.Verb {
+		t.Error(fmt.Sprintf("recursive should not change a list request, got: %v, %v", req, err))
+		return
+	}
+}
+
+func TestHandleApiRequestDeletePrefix(t *testing.T) {
+	mgr, err := getTestMgr(t)
+	if nil != err {
+		return
+	}
+	authz, err := getTestAuthz(t)
+	if nil != err {
+		return
+	}
+	cx := NewSessionContext(testUser)
+	keys := []string{"deleteTest/a", "deleteTest/sub/b", "deleteTest/sub/deeper/c", "deleteTestSibling/d"}
+	for _, key := range keys {
+		if err := mgr.Copy(cx, "@user", testFolder+"/x", key); nil != err {
+			t.Error(fmt.Sprintf("failed to copy seed object, got: %v", err))
+			return
+		}
+	}
+	defer mgr.DeleteObject(cx, "@user", "deleteTestSibling/d")
+	testCases := []struct {
+		path     string
+		expected string
+		count    int
+	}{
+		{"list/@user/deleteTest?recursive=true", "error", 0},
+		{"list/@user/?recursive=true", "error", 0},
+		{"list/@user/deleteTest/?recursive=true", "ok", 3},
+		{"list/@user/deleteTest/?recursive=true", "ok", 0},
+	}
+	for _, it := range testCases {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it.path)
+		req, err := NewApiRequest(testUrl, http.MethodDelete, testUser)
+		if nil != err {
+			t.Error(fmt.Sprintf("unexpected path %v failed validation, got: %v", it.path, err))
+			return
+		}
+		result := req.HandleApiRequest(mgr, authz, nil, nil, nil)
+		if !strings.HasPrefix(result.Result, it.expected) {
+			t.Error(fmt.Sprintf("unexpected result for %v, got: %v", it.path, result.Result))
+			return
+		}
+		if deleted, ok := result.Data.(*DeletePrefixResult); "ok" == it.expected && (!ok || deleted.DeletedCount != it.count || len(deleted.Failures) != 0) {
+			t.Error(fmt.Sprintf("unexpected delete result for %v, got: %v", it.path, result.Data))
+			return
+		}
+	}
+	if _, err := mgr.Stat(cx, "@user", "deleteTestSibling/d"); nil != err {
+		t.Error(fmt.Sprintf("recursive delete removed an object outside the prefix, got: %v", err))
+	}
+}
+
+func TestMgrDeletePrefix(t *testing.T) {
+	deleteBodies := []string{}
+	mgr, server, err := newStubS3Mgr(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		_, isDelete := query["delete"]
+		switch {
+		case r.Method == http.MethodGet && query.Get("prefix") == "ws-storage-testsuite/goTestUser/out/" && "" == query.Get("delimiter"):
+			if "" == query.Get("continuation-token") {
+				fmt.Fprintf(w, `<ListBucketResult><IsTruncated>true</IsTruncated><NextContinuationToken>page2</NextContinuationToken>
+					<Contents><Key>ws-storage-testsuite/goTestUser/out/a</Key><Size>3</Size></Contents>
+					<Contents><Key>ws-storage-testsuite/goTestUser/out/sub/b</Key><Size>3</Size></Contents>
+				</ListBucketResult>`)
+				return
+			}
+			fmt.Fprintf(w, `<ListBucketResult><IsTruncated>false</IsTruncated>
+				<Contents><Key>ws-storage-testsuite/goTestUser/out/c</Key><Size>3</Size></Contents>
+			</ListBucketResult>`)
+		case r.Method == http.MethodPost && isDelete:
+			body, _ := ioutil.ReadAll(r.Body)
+			deleteBodies = append(deleteBodies, string(body))
+			if strings.Contains(string(body), "out/sub/b") {
+				fmt.Fprintf(w, `<DeleteResult><Error><Key>ws-storage-testsuite/goTestUser/out/sub/b</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error></DeleteResult>`)
+				return
+			}
+			fmt.Fprintf(w, `<DeleteResult></DeleteResult>`)
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	result, err := mgr.DeletePrefix(NewSessionContext(testUser), "@user", "out/")
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to delete prefix, got: %v", err))
+		return
+	}
+	if result.DeletedCount != 2 || len(result.Failures) != 1 || result.Failures[0].WorkspaceKey != "out/sub/b" ||
+		!strings.Contains(result.Failures[0].Error, "AccessDenied") {
+		t.Error(fmt.Sprintf("unexpected delete result, got: %v", result))
+		return
+	}
+	if len(deleteBodies) != 2 || !strings.Contains(deleteBodies[0], "<Quiet>true</Quiet>") {
+		t.Error(fmt.Sprintf("expected a quiet DeleteObjects batch per page, got: %v", deleteBodies))
+	}
+}
@@ -0,0 +1,427 @@
+package storage
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultProxyMaxTransfers limits the concurrent proxied
+// transfers if the proxy config does not set a limit
+const defaultProxyMaxTransfers = 64
+
+// defaultProxyIdleTimeout fails a transfer whose backend sends
+// nothing for this long if the proxy config does not set a timeout
+const defaultProxyIdleTimeout = 60 * time.Second
+
+// proxyBufferBytes is the buffer each proxied download
+// copies through - with backpressure from the client,
+// a transfer holds at most one buffer of object data
+const proxyBufferBytes = 32 * 1024
+
+// proxyRequestHeaders are the download request headers
+// forwarded to the storage backend
+var proxyRequestHeaders = []string{
+	"Range",
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+	"If-Range",
+}
+
+// proxyResponseHeaders are the backend download response
+// headers forwarded to the client - backend specific
+// headers (ex: x-amz-*) are dropped
+var proxyResponseHeaders = []string{
+	"Content-Type",
+	"Content-Length",
+	"Content-Range",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Accept-Ranges",
+	"ETag",
+	"Last-Modified",
+	"Cache-Control",
+	"Expires",
+}
+
+// proxyStreamStatus lists the download statuses streamed to the
+// client as they are - other statuses are errors
+var proxyStreamStatus = map[int]bool{
+	http.StatusOK:                           true,
+	http.StatusPartialContent:               true,
+	http.StatusNotModified:                  true,
+	http.StatusPreconditionFailed:           true,
+	http.StatusRequestedRangeNotSatisfiable: true,
+}
+
+// ObjectProxy streams object data between api clients and
+// the storage backend through the backend's presigned urls,
+// for clients that cannot reach the backend themselves
+type ObjectProxy struct {
+	client      *http.Client
+	// transfers holds a token for each transfer in flight
+	transfers   chan struct{}
+	// idleTimeout fails a transfer that makes no progress
+	idleTimeout time.Duration
+}
+
+// NewObjectProxy makes the proxy for the given manager -
+// backends that ws-storage serves itself are called in
+// process rather than through the network
+func NewObjectProxy(mgr Manager, config *Config) *ObjectProxy {
+	proxyConfig := config.Proxy
+	if nil == proxyConfig {
+		proxyConfig = &ProxyConfig{}
+	}
+	maxTransfers := defaultProxyMaxTransfers
+	if proxyConfig.MaxTransfers > 0 {
+		maxTransfers = proxyConfig.MaxTransfers
+	}
+	idleTimeout := defaultProxyIdleTimeout
+	if proxyConfig.IdleTimeoutSeconds > 0 {
+		idleTimeout = time.Duration(proxyConfig.IdleTimeoutSeconds) * time.Second
+	}
+	client := &http.Client{}
+	if handler, ok := mgr.(http.Handler); ok {
+		client.Transport = &handlerTransport{handler: handler}
+	} else {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.ResponseHeaderTimeout = idleTimeout
+		if config.TLSSkipVerify {
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		client.Transport = transport
+	}
+	return &ObjectProxy{
+		client:      client,
+		transfers:   make(chan struct{}, maxTransfers),
+		idleTimeout: idleTimeout,
+	}
+}
+
+// Enabled is false if the proxy is nil - proxy mode is not configured
+func (self *ObjectProxy) Enabled() bool {
+	return nil != self
+}
+
+// acquire reserves a transfer slot - fails
+// if the proxy is at its transfer limit
+func (self *ObjectProxy) acquire() error {
+	select {
+	case self.transfers <- struct{}{}:
+		proxyTransfersInFlight.Inc()
+		return nil
+	default:
+		return unavailablef("too many proxied transfers - try again later")
+	}
+}
+
+// release frees a transfer slot
+func (self *ObjectProxy) release() {
+	<-self.transfers
+	proxyTransfersInFlight.Dec()
+}
+
+// proxyStatusError classifies a failed backend response
+func proxyStatusError(statusCode int) error {
+	switch statusCode {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusBadRequest:
+		return invalidInputf("upload rejected by the storage backend - the data does not match its declared size or checksum")
+	}
+	return statusErrorf(statusCode, "storage backend failed proxied transfer with status %v", statusCode)
+}
+
+// Upload PUTs the sizeBytes of body to the given upload url
+// with the headers the url was signed with
+func (self *ObjectProxy) Upload(signedUrl string, headers http.Header, body io.Reader, sizeBytes int64) error {
+	if err := self.acquire(); nil != err {
+		return err
+	}
+	defer self.release()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deadline := newIdleDeadline(self.idleTimeout, cancel)
+	defer deadline.stop()
+	counter := &countingReader{body: body, deadline: deadline}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, signedUrl, counter)
+	if nil != err {
+		return err
+	}
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+	req.ContentLength = sizeBytes
+	if 0 == sizeBytes {
+		req.Body = http.NoBody
+	}
+	resp, err := self.client.Do(req)
+	proxyBytesTotal.WithLabelValues("upload").Add(float64(counter.count))
+	if nil != err {
+		return deadline.err("proxied upload failed", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, proxyBufferBytes))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return proxyStatusError(resp.StatusCode)
+	}
+	return nil
+}
+
+// Download GETs the given download url, forwarding the range and
+// conditional headers of the client request -
+// the caller must Serve or Close the stream
+func (self *ObjectProxy) Download(signedUrl string, header http.Header) (*ObjectStream, error) {
+	if err := self.acquire(); nil != err {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	deadline := newIdleDeadline(self.idleTimeout, cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, signedUrl, nil)
+	if nil != err {
+		deadline.stop()
+		cancel()
+		self.release()
+		return nil, err
+	}
+	for _, key := range proxyRequestHeaders {
+		if value := header.Get(key); "" != value {
+			req.Header.Set(key, value)
+		}
+	}
+	resp, err := self.client.Do(req)
+	if nil != err {
+		deadline.stop()
+		cancel()
+		self.release()
+		return nil, deadline.err("proxied download failed", err)
+	}
+	if !proxyStreamStatus[resp.StatusCode] {
+		resp.Body.Close()
+		deadline.stop()
+		cancel()
+		self.release()
+		return nil, proxyStatusError(resp.StatusCode)
+	}
+	deadline.touch()
+	stream := &ObjectStream{
+		StatusCode: resp.StatusCode,
+		Header:     http.Header{},
+		body:       resp.Body,
+		proxy:      self,
+		deadline:   deadline,
+		cancel:     cancel,
+	}
+	for _, key := range proxyResponseHeaders {
+		if values := resp.Header.Values(key); len(values) > 0 {
+			stream.Header[key] = values
+		}
+	}
+	return stream, nil
+}
+
+// ObjectStream is a proxied download response -
+// it holds a transfer slot until closed
+type ObjectStream struct {
+	StatusCode int
+	Header     http.Header
+	body       io.ReadCloser
+	proxy      *ObjectProxy
+	deadline   *idleDeadline
+	cancel     context.CancelFunc
+	closeOnce  sync.Once
+}
+
+// Serve writes the response to the client, and closes the stream
+func (self *ObjectStream) Serve(w http.ResponseWriter) error {
+	defer self.Close()
+	w.Header().Del("Content-Type")
+	for key, values := range self.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(self.StatusCode)
+	// hide w's ReadFrom, so the copy goes through the bounded buffer
+	body := &countingReader{body: self.body, deadline: self.deadline}
+	count, err := io.CopyBuffer(struct{ io.Writer }{w}, body, make([]byte, proxyBufferBytes))
+	proxyBytesTotal.WithLabelValues("download").Add(float64(count))
+	if nil != err {
+		return self.deadline.err("proxied download interrupted", err)
+	}
+	return nil
+}
+
+// Close releases the backend response and the transfer slot
+func (self *ObjectStream) Close() error {
+	var err error
+	self.closeOnce.Do(func() {
+		self.deadline.stop()
+		err = self.body.Close()
+		self.cancel()
+		self.proxy.release()
+	})
+	return err
+}
+
+// countingReader counts the bytes read through it,
+// and pushes back the transfer's idle deadline as data flows
+type countingReader struct {
+	body     io.Reader
+	count    int64
+	deadline *idleDeadline
+}
+
+func (self *countingReader) Read(buf []byte) (int, error) {
+	count, err := self.body.Read(buf)
+	self.count += int64(count)
+	if count > 0 {
+		self.deadline.touch()
+	}
+	return count, err
+}
+
+// idleDeadline cancels a transfer that goes idleTimeout
+// without progress - waiting on the backend's response
+// headers, or on the next data in either direction
+type idleDeadline struct {
+	timeout time.Duration
+	timer   *time.Timer
+	expired int32
+}
+
+func newIdleDeadline(timeout time.Duration, cancel context.CancelFunc) *idleDeadline {
+	deadline := &idleDeadline{timeout: timeout}
+	deadline.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&deadline.expired, 1)
+		cancel()
+	})
+	return deadline
+}
+
+// touch restarts the idle timeout
+func (self *idleDeadline) touch() {
+	if 0 == atomic.LoadInt32(&self.expired) {
+		self.timer.Reset(self.timeout)
+	}
+}
+
+func (self *idleDeadline) stop() {
+	self.timer.Stop()
+}
+
+// err is the unavailable error of a failed transfer -
+// noting the timeout if the deadline cancelled it
+func (self *idleDeadline) err(msg string, err error) error {
+	if 1 == atomic.LoadInt32(&self.expired) {
+		return unavailablef("%v - storage backend idle for %v", msg, self.timeout)
+	}
+	return unavailablef("%v - %v", msg, err)
+}
+
+// uploadHeaderer is implemented by backends whose upload urls
+// are signed with headers beyond Content-Type
+type uploadHeaderer interface {
+	// uploadHeaders are the headers an upload of the given
+	// key with the given options must send
+	uploadHeaders(key string, options UploadOptions) http.Header
+}
+
+// uploadHeaders are the headers a proxied upload
+// sends with the upload url mgr signed
+func uploadHeaders(mgr Manager, key string, options UploadOptions) http.Header {
+	if headerer, ok := mgr.(uploadHeaderer); ok {
+		return headerer.uploadHeaders(key, options)
+	}
+	return http.Header{"Content-Type": []string{options.ContentTypeOrDefault(key)}}
+}
+
+// handlerTransport is a RoundTripper that serves requests with
+// an in-process handler - the response body is a pipe,
+// so the handler writes no faster than the body is read,
+// and the request's context cancels the response
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (self *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reader, writer := io.Pipe()
+	rw := &pipeResponseWriter{header: http.Header{}, writer: writer, ready: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer writer.Close()
+		if nil != req.Body {
+			defer req.Body.Close()
+		}
+		self.handler.ServeHTTP(rw, req)
+		// a handler that writes nothing responds 200
+		rw.WriteHeader(http.StatusOK)
+	}()
+	// a cancelled request fails the wait for the
+	// response, and any read of the body
+	go func() {
+		select {
+		case <-req.Context().Done():
+			reader.CloseWithError(req.Context().Err())
+		case <-done:
+		}
+	}()
+	select {
+	case <-rw.ready:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	contentLength := int64(-1)
+	if value := rw.snapshot.Get("Content-Length"); "" != value {
+		if parsed, err := strconv.ParseInt(value, 10, 64); nil == err {
+			contentLength = parsed
+		}
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(rw.status) + " " + http.StatusText(rw.status),
+		StatusCode:    rw.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rw.snapshot,
+		Body:          reader,
+		ContentLength: contentLength,
+		Request:       req,
+	}, nil
+}
+
+// pipeResponseWriter is the ResponseWriter of a handlerTransport
+// handler - it writes the body to a pipe
+type pipeResponseWriter struct {
+	header   http.Header
+	writer   *io.PipeWriter
+	// snapshot is the header when the status was written
+	snapshot http.Header
+	status   int
+	ready    chan struct{}
+}
+
+func (self *pipeResponseWriter) Header() http.Header {
+	return self.header
+}
+
+func (self *pipeResponseWriter) WriteHeader(statusCode int) {
+	if nil != self.snapshot {
+		return
+	}
+	self.status = statusCode
+	self.snapshot = self.header.Clone()
+	close(self.ready)
+}
+
+func (self *pipeResponseWriter) Write(buf []byte) (int, error) {
+	self.WriteHeader(http.StatusOK)
+	return self.writer.Write(buf)
+}
@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewApiRequestProxy(t *testing.T) {
+	testCases := []struct {
+		method string
+		path   string
+		proxy  bool
+	}{
+		{http.MethodPut, "upload/@user/x", true},
+		{http.MethodGet, "upload/@user/x", false},
+		{http.MethodGet, "download/@user/x?proxy=true", true},
+		{http.MethodGet, "download/@user/x", false},
+	}
+	for _, it := range testCases {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it.path)
+		req, err := NewApiRequest(testUrl, it.method, testUser)
+		if nil != err || req.Proxy != it.proxy {
+			t.Error(fmt.Sprintf("unexpected proxy for %v %v, got: %v, %v", it.method, it.path, req, err))
+		}
+	}
+	for _, it := range []string{"upload/@user/x?proxy=true", "stat/@user/x?proxy=true"} {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it)
+		if _, err := NewApiRequest(testUrl, http.MethodGet, testUser); nil == err {
+			t.Error(fmt.Sprintf("%v should have failed validation", it))
+		}
+	}
+
+	bodyTests := []struct {
+		path        string
+		contentType string
+		chunked     bool
+		valid       bool
+	}{
+		{"upload/@user/x.csv", "text/csv", false, true},
+		{"upload/@user/x.csv?size=5", "", false, true},
+		{"upload/@user/x.csv?size=6", "", false, false},
+		{"upload/@user/x.csv", "", true, false},
+		{"upload/@user/x.csv", "text/csv; charset", false, false},
+	}
+	for _, it := range bodyTests {
+		r := httptest.NewRequest(http.MethodPut, "https://whatever/ws-storage/"+it.path, strings.NewReader("a,b,c"))
+		if "" != it.contentType {
+			r.Header.Set("Content-Type", it.contentType)
+		}
+		if it.chunked {
+			r.ContentLength = -1
+		}
+		req, err := NewApiRequest(r.URL, r.Method, testUser)
+		if nil == err {
+			err = req.setProxyBody(r)
+		}
+		if (nil == err) != it.valid {
+			t.Error(fmt.Sprintf("unexpected validation of %v, got: %v", it.path, err))
+			continue
+		}
+		if nil == err && (5 != req.SizeBytes || it.contentType != req.ContentType) {
+			t.Error(fmt.Sprintf("unexpected upload options for %v, got: %v", it.path, req.uploadOptions()))
+		}
+	}
+}
+
+// proxyRequest handles an api request with the given method,
+// path, headers, and body through proxy
+func proxyRequest(mgr Manager, proxy *ObjectProxy, method string, path string, header http.Header, body string) *ApiResult {
+	r := httptest.NewRequest(method, "https://whatever/ws-storage/"+path, strings.NewReader(body))
+	for key, values := range header {
+		r.Header[key] = values
+	}
+	req, err := NewApiRequest(r.URL, r.Method, testUser)
+	if nil == err && req.Proxy {
+		err = req.setProxyBody(r)
+	}
+	if nil != err {
+		return newErrorResult("", err)
+	}
+	return req.HandleApiRequest(mgr, NewStaticAuthorizer(nil), nil, nil, proxy)
+}
+
+func TestProxyBlob(t *testing.T) {
+	mgr, err := NewMemoryManager(&Config{BucketPrefix: "ws-storage-testsuite"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	if result := proxyRequest(mgr, nil, http.MethodPut, "upload/@user/data.csv", nil, "a,b,c"); nil == result.Error || ErrorCodeInvalidInput != result.Error.Code {
+		t.Error(fmt.Sprintf("expected proxy mode to be disabled, got: %v", result.Result))
+		return
+	}
+	proxy := NewObjectProxy(mgr, &Config{Proxy: &ProxyConfig{}})
+	crc := testChecksum("crc32c", "a,b,c")
+	checksumPath := "upload/@user/data.csv?checksumAlgorithm=crc32c&checksum=" + url.QueryEscape(crc.Value)
+	if result := proxyRequest(mgr, proxy, http.MethodPut, checksumPath, nil, "x,y,z"); nil == result.Error || ErrorCodeInvalidInput != result.Error.Code {
+		t.Error(fmt.Sprintf("expected a mismatched upload to fail, got: %v", result.Result))
+		return
+	}
+	if result := proxyRequest(mgr, proxy, http.MethodPut, checksumPath, nil, "a,b,c"); nil != result.Error {
+		t.Error(fmt.Sprintf("failed proxied upload, got: %v", result.Result))
+		return
+	}
+	cx := NewSessionContext(testUser)
+	if stat, err := mgr.Stat(cx, "@user", "data.csv"); nil != err || 5 != stat.SizeBytes || nil == stat.Checksum || *crc != *stat.Checksum {
+		t.Error(fmt.Sprintf("unexpected stat after proxied upload, got: %v, %v", stat, err))
+		return
+	}
+
+	testCases := []struct {
+		header http.Header
+		status int
+		body   string
+	}{
+		{http.Header{}, http.StatusOK, "a,b,c"},
+		{http.Header{"Range": []string{"bytes=2-"}}, http.StatusPartialContent, "b,c"},
+		{http.Header{"If-Modified-Since": []string{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}}, http.StatusNotModified, ""},
+		{http.Header{"Range": []string{"bytes=10-"}}, http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	for _, it := range testCases {
+		result := proxyRequest(mgr, proxy, http.MethodGet, "download/@user/data.csv?proxy=true", it.header, "")
+		stream, ok := result.Data.(*ObjectStream)
+		if !ok || it.status != result.StatusCode() {
+			t.Error(fmt.Sprintf("unexpected proxied download for %v, got: %v, %v", it.header, result.Result, result.StatusCode()))
+			continue
+		}
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		if err := stream.Serve(rec); nil != err {
+			t.Error(fmt.Sprintf("failed to serve stream for %v, got: %v", it.header, err))
+			continue
+		}
+		if it.status != rec.Code || (http.StatusRequestedRangeNotSatisfiable != it.status && it.body != rec.Body.String()) {
+			t.Error(fmt.Sprintf("unexpected response for %v, got: %v, %v", it.header, rec.Code, rec.Body.String()))
+		}
+		if http.StatusOK == it.status && "text/csv; charset=utf-8" != rec.Header().Get("Content-Type") {
+			t.Error(fmt.Sprintf("unexpected content type, got: %v", rec.Header().Get("Content-Type")))
+		}
+	}
+	if result := proxyRequest(mgr, proxy, http.MethodGet, "download/@user/missing.csv?proxy=true", nil, ""); nil == result.Error || ErrorCodeNotFound != result.Error.Code {
+		t.Error(fmt.Sprintf("expected a missing object to be not found, got: %v", result.Result))
+	}
+}
+
+func TestProxyS3(t *testing.T) {
+	mgr, server, err := newStubS3Mgr(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "unexpected request", http.StatusMethodNotAllowed)
+			return
+		}
+		// S3 rejects a request without each signed header
+		for _, name := range strings.Split(r.URL.Query().Get("X-Amz-SignedHeaders"), ";") {
+			if "" == r.Header.Get(name) && "host" != name && "content-length" != name {
+				http.Error(w, "missing signed header "+name, http.StatusForbidden)
+				return
+			}
+		}
+		body, _ := io.ReadAll(r.Body)
+		if "a,b,c" != string(body) || 5 != r.ContentLength {
+			http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+		}
+	}))
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	proxy := NewObjectProxy(mgr, mgr.config)
+	checksumPath := "upload/@user/data.csv?checksumAlgorithm=sha256&checksum=" + url.QueryEscape(testChecksum("sha256", "a,b,c").Value)
+	if result := proxyRequest(mgr, proxy, http.MethodPut, checksumPath, nil, "a,b,c"); nil != result.Error {
+		t.Error(fmt.Sprintf("failed proxied upload, got: %v", result.Result))
+		return
+	}
+	if result := proxyRequest(mgr, proxy, http.MethodPut, checksumPath, nil, "x,y,z"); nil == result.Error || ErrorCodeInvalidInput != result.Error.Code {
+		t.Error(fmt.Sprintf("expected a rejected upload to be invalid, got: %v", result.Result))
+	}
+}
+
+func TestProxyMaxTransfers(t *testing.T) {
+	mgr, err := NewMemoryManager(&Config{BucketPrefix: "ws-storage-testsuite"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	proxy := NewObjectProxy(mgr, &Config{Proxy: &ProxyConfig{MaxTransfers: 1}})
+	proxyRequest(mgr, proxy, http.MethodPut, "upload/@user/data.csv", nil, "a,b,c")
+	first := proxyRequest(mgr, proxy, http.MethodGet, "download/@user/data.csv?proxy=true", nil, "")
+	stream, ok := first.Data.(*ObjectStream)
+	if !ok {
+		t.Error(fmt.Sprintf("failed proxied download, got: %v", first.Result))
+		return
+	}
+	second := proxyRequest(mgr, proxy, http.MethodGet, "download/@user/data.csv?proxy=true", nil, "")
+	if nil == second.Error || http.StatusServiceUnavailable != second.StatusCode() {
+		t.Error(fmt.Sprintf("expected a transfer over the limit to be unavailable, got: %v", second.Result))
+	}
+	stream.Close()
+	stream.Close()
+	third := proxyRequest(mgr, proxy, http.MethodGet, "download/@user/data.csv?proxy=true", nil, "")
+	if stream, ok := third.Data.(*ObjectStream); !ok {
+		t.Error(fmt.Sprintf("expected a transfer after close to succeed, got: %v", third.Result))
+	} else {
+		stream.Close()
+	}
+}
+
+// hangingHandler stalls before its response headers on
+// /headers, after the first bytes of its body on /body,
+// and without reading the request body otherwise
+type hangingHandler struct {
+	release chan struct{}
+}
+
+func (self *hangingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if "/body" == r.URL.Path {
+		w.Header().Set("Content-Length", "10")
+		w.Write([]byte("a,b"))
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	select {
+	case <-self.release:
+	case <-r.Context().Done():
+	}
+}
+
+// hangingBlobManager is a backend that ws-storage
+// serves itself, and that never responds
+type hangingBlobManager struct {
+	*MemoryManager
+	hang *hangingHandler
+}
+
+func (self *hangingBlobManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	self.hang.ServeHTTP(w, r)
+}
+
+func TestProxyIdleTimeout(t *testing.T) {
+	handler := &hangingHandler{release: make(chan struct{})}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	defer close(handler.release)
+	proxy := NewObjectProxy(nil, &Config{Proxy: &ProxyConfig{MaxTransfers: 1}})
+	proxy.idleTimeout = 100 * time.Millisecond
+	proxy.client.Transport.(*http.Transport).ResponseHeaderTimeout = proxy.idleTimeout
+
+	start := time.Now()
+	if _, err := proxy.Download(server.URL+"/headers", http.Header{}); !errors.Is(err, ErrUnavailable) {
+		t.Error(fmt.Sprintf("expected a download without response headers to be unavailable, got: %v", err))
+		return
+	}
+	stream, err := proxy.Download(server.URL+"/body", http.Header{})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed proxied download, got: %v", err))
+		return
+	}
+	rec := httptest.NewRecorder()
+	if err := stream.Serve(rec); !errors.Is(err, ErrUnavailable) || "a,b" != rec.Body.String() {
+		t.Error(fmt.Sprintf("expected a stalled download to be unavailable after its first bytes, got: %v - %v", err, rec.Body.String()))
+	}
+	if err := proxy.Upload(server.URL+"/upload", http.Header{}, strings.NewReader("a,b,c"), 5); !errors.Is(err, ErrUnavailable) {
+		t.Error(fmt.Sprintf("expected an upload without a response to be unavailable, got: %v", err))
+	}
+	// a backend that stops reading stalls the upload once the socket buffers fill
+	large := strings.Repeat("a", 32*1024*1024)
+	if err := proxy.Upload(server.URL+"/upload", http.Header{}, strings.NewReader(large), int64(len(large))); !errors.Is(err, ErrUnavailable) {
+		t.Error(fmt.Sprintf("expected a stalled upload to be unavailable, got: %v", err))
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Error(fmt.Sprintf("expected stalled transfers to time out quickly, took: %v", elapsed))
+	}
+
+	mem, err := NewMemoryManager(&Config{BucketPrefix: "ws-storage-testsuite"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	blobProxy := NewObjectProxy(&hangingBlobManager{MemoryManager: mem, hang: handler}, &Config{Proxy: &ProxyConfig{MaxTransfers: 1}})
+	if _, ok := blobProxy.client.Transport.(*handlerTransport); !ok {
+		t.Error(fmt.Sprintf("expected an in-process transport, got: %T", blobProxy.client.Transport))
+		return
+	}
+	blobProxy.idleTimeout = 100 * time.Millisecond
+	if _, err := blobProxy.Download("http://ws-storage/headers", http.Header{}); !errors.Is(err, ErrUnavailable) {
+		t.Error(fmt.Sprintf("expected an in-process download without response headers to be unavailable, got: %v", err))
+	}
+	if stream, err := blobProxy.Download("http://ws-storage/body", http.Header{}); nil != err {
+		t.Error(fmt.Sprintf("failed in-process proxied download, got: %v", err))
+	} else if err := stream.Serve(httptest.NewRecorder()); !errors.Is(err, ErrUnavailable) {
+		t.Error(fmt.Sprintf("expected a stalled in-process download to be unavailable, got: %v", err))
+	}
+	// each failed transfer released its slot
+	if err := proxy.acquire(); nil != err {
+		t.Error(fmt.Sprintf("expected timed out transfers to release their slots, got: %v", err))
+	}
+	if err := blobProxy.acquire(); nil != err {
+		t.Error(fmt.Sprintf("expected timed out in-process transfers to release their slots, got: %v", err))
+	}
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WorkspaceUsage is the size and count of the objects in a
+// workspace, and the workspace's quota - 0 is no limit
+type WorkspaceUsage struct {
+	Workspace   string
+	SizeBytes   int64
+	ObjectCount int64
+	MaxBytes    int64
+	MaxObjects  int64
+	// Computed is when the usage was last calculated
+	// by listing the workspace
+	Computed    time.Time
+}
+
+// workspaceUsage lists every object in the workspace to sum its usage
+func workspaceUsage(store treeStore, config *Config, cx *SessionContext, workspaceIn string) (*WorkspaceUsage, error) {
+	workspace, err := resolveWorkspace(config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	s3prefix, err := workspace.Path("")
+	if err != nil {
+		return nil, err
+	}
+	result := &WorkspaceUsage{Workspace: workspace.Name, Computed: time.Now().UTC()}
+	page := ""
+	for {
+		objects, nextPage, err := store.listTree(s3prefix, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range objects {
+			result.SizeBytes += item.SizeBytes
+			result.ObjectCount += 1
+		}
+		if "" == nextPage {
+			break
+		}
+		page = nextPage
+	}
+	return result, nil
+}
+
+type cachedUsage struct {
+	usage   WorkspaceUsage
+	expires time.Time
+}
+
+// QuotaEnforcer checks uploads against the configured
+// workspace quotas.  Usage is cached for a time, and each
+// approved upload is added to the cached usage, so a burst
+// of uploads cannot exceed the quota before the cache expires.
+type QuotaEnforcer struct {
+	config *QuotaConfig
+	ttl    time.Duration
+	lock   sync.Mutex
+	cache  map[string]*cachedUsage
+}
+
+// NewQuotaEnforcer makes an enforcer with the given configuration -
+// a nil configuration sets no limits
+func NewQuotaEnforcer(config *QuotaConfig) *QuotaEnforcer {
+	if nil == config {
+		config = &QuotaConfig{}
+	}
+	ttl := 60 * time.Second
+	if config.CacheSeconds > 0 {
+		ttl = time.Duration(config.CacheSeconds) * time.Second
+	}
+	return &QuotaEnforcer{
+		config: config,
+		ttl:    ttl,
+		cache:  map[string]*cachedUsage{},
+	}
+}
+
+// Enabled is true if any quota is configured
+func (self *QuotaEnforcer) Enabled() bool {
+	return self.config.MaxBytes > 0 || self.config.MaxObjects > 0 || len(self.config.Workspaces) > 0
+}
+
+// limits returns the byte and object limits of the named workspace
+func (self *QuotaEnforcer) limits(workspace string) (int64, int64) {
+	if quota, ok := self.config.Workspaces[workspace]; ok {
+		return quota.MaxBytes, quota.MaxObjects
+	}
+	return self.config.MaxBytes, self.config.MaxObjects
+}
+
+// usageCacheKey distinguishes each user's @user workspace
+func usageCacheKey(cx *SessionContext, workspaceIn string) string {
+	if UserWorkspace == workspaceIn {
+		return UserWorkspace + "/" + cx.User
+	}
+	return workspaceIn
+}
+
+// Usage returns the workspace's cached usage with its limits,
+// or calculates it with mgr
+func (self *QuotaEnforcer) Usage(mgr Manager, cx *SessionContext, workspaceIn string) (*WorkspaceUsage, error) {
+	cacheKey := usageCacheKey(cx, workspaceIn)
+	now := time.Now()
+	self.lock.Lock()
+	if cached, ok := self.cache[cacheKey]; ok && now.Before(cached.expires) {
+		usage := cached.usage
+		self.lock.Unlock()
+		return &usage, nil
+	}
+	self.lock.Unlock()
+	usage, err := mgr.Usage(cx, workspaceIn)
+	if nil != err {
+		return nil, err
+	}
+	usage.MaxBytes, usage.MaxObjects = self.limits(usage.Workspace)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	// drop expired entries, so the cache does not grow without bound
+	if len(self.cache) >= maxCachedDecisions {
+		for key, it := range self.cache {
+			if now.After(it.expires) {
+				delete(self.cache, key)
+			}
+		}
+	}
+	self.cache[cacheKey] = &cachedUsage{usage: *usage, expires: now.Add(self.ttl)}
+	return usage, nil
+}
+
+// Recheck is Reserve against freshly calculated usage rather
+// than the cache - for objects whose size is only known once
+// their data is stored, so the reservation made when their
+// upload started does not count
+func (self *QuotaEnforcer) Recheck(mgr Manager, cx *SessionContext, workspaceIn string, addBytes int64, addObjects int64) error {
+	if !self.Enabled() {
+		return nil
+	}
+	self.lock.Lock()
+	delete(self.cache, usageCacheKey(cx, workspaceIn))
+	self.lock.Unlock()
+	return self.Reserve(mgr, cx, workspaceIn, addBytes, addObjects)
+}
+
+// Reserve checks that adding the given bytes and objects
+// to the workspace stays within its quota, and adds them
+// to the workspace's cached usage
+func (self *QuotaEnforcer) Reserve(mgr Manager, cx *SessionContext, workspaceIn string, addBytes int64, addObjects int64) error {
+	if !self.Enabled() {
+		return nil
+	}
+	usage, err := self.Usage(mgr, cx, workspaceIn)
+	if nil != err {
+		return fmt.Errorf("failed to calculate workspace usage - %w", err)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if cached, ok := self.cache[usageCacheKey(cx, workspaceIn)]; ok {
+		usage = &cached.usage
+	}
+	if usage.MaxBytes > 0 && usage.SizeBytes+addBytes > usage.MaxBytes {
+		return fmt.Errorf("%w - workspace %v uses %v of %v bytes, cannot add %v", ErrQuotaExceeded, usage.Workspace, usage.SizeBytes, usage.MaxBytes, addBytes)
+	}
+	if usage.MaxObjects > 0 && usage.ObjectCount+addObjects > usage.MaxObjects {
+		return fmt.Errorf("%w - workspace %v has %v of %v objects", ErrQuotaExceeded, usage.Workspace, usage.ObjectCount, usage.MaxObjects)
+	}
+	usage.SizeBytes += addBytes
+	usage.ObjectCount += addObjects
+	log.Debug().Str("Func", "Reserve").
+		Str("Workspace", usage.Workspace).
+		Int64("SizeBytes", usage.SizeBytes).
+		Int64("ObjectCount", usage.ObjectCount).
+		Send()
+	return nil
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestQuotaEnforcer(t *testing.T) {
+	mgr, err := NewMemoryManager(&Config{BucketPrefix: "ws-storage-testsuite"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	for _, path := range []string{"ws-storage-testsuite/goTestUser/a", "ws-storage-testsuite/goTestUser/sub/b", "ws-storage-testsuite/other/c"} {
+		mgr.writeBlob(path, strings.NewReader("0123456789"), nil)
+	}
+	quota := NewQuotaEnforcer(&QuotaConfig{
+		MaxBytes:   50,
+		MaxObjects: 4,
+		Workspaces: map[string]*WorkspaceQuota{"goTestReader": {MaxBytes: 5}},
+	})
+	cx := NewSessionContext(testUser)
+	usage, err := quota.Usage(mgr, cx, "@user")
+	if nil != err || usage.Workspace != testUser || usage.SizeBytes != 20 || usage.ObjectCount != 2 || usage.MaxBytes != 50 || usage.MaxObjects != 4 {
+		t.Error(fmt.Sprintf("unexpected usage, got: %v, %v", usage, err))
+		return
+	}
+	if err := quota.Reserve(mgr, cx, "@user", 20, 1); nil != err {
+		t.Error(fmt.Sprintf("reserve within quota failed, got: %v", err))
+		return
+	}
+	// the reservation counts against the cached usage
+	if err := quota.Reserve(mgr, cx, "@user", 20, 1); nil == err {
+		t.Error("reserve over the byte quota should fail")
+		return
+	}
+	if err := quota.Reserve(mgr, cx, "@user", 1, 1); nil != err {
+		t.Error(fmt.Sprintf("reserve within quota failed, got: %v", err))
+		return
+	}
+	if err := quota.Reserve(mgr, cx, "@user", 1, 1); nil == err {
+		t.Error("reserve over the object quota should fail")
+		return
+	}
+	if usage, _ := quota.Usage(mgr, cx, "@user"); usage.SizeBytes != 41 || usage.ObjectCount != 4 {
+		t.Error(fmt.Sprintf("unexpected usage after reservations, got: %v", usage))
+		return
+	}
+	if err := quota.Reserve(mgr, NewSessionContext("goTestReader"), "@user", 6, 1); nil == err {
+		t.Error("reserve over a workspace quota override should fail")
+		return
+	}
+	if err := NewQuotaEnforcer(nil).Reserve(mgr, cx, "@user", 1000, 1000); nil != err {
+		t.Error(fmt.Sprintf("no quota config should set no limits, got: %v", err))
+	}
+}
+
+func TestHandleApiRequestQuota(t *testing.T) {
+	mgr, err := NewMemoryManager(&Config{BucketPrefix: "ws-storage-testsuite"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	server := httptest.NewServer(mgr)
+	defer server.Close()
+	mgr.signer.baseUrl = server.URL
+	mgr.writeBlob("ws-storage-testsuite/goTestUser/a", strings.NewReader("0123456789"), nil)
+	quota := NewQuotaEnforcer(&QuotaConfig{MaxBytes: 20})
+	authz := NewStaticAuthorizer(nil)
+
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"upload/@user/b", "error - invalid size"},
+		{"upload/@user/b?size=11", "error - quota exceeded"},
+		{"upload/@user/b?size=10", "ok"},
+		{"copy/@user/a?destination=c", "error - quota exceeded"},
+		{"usage/@user", "ok"},
+	}
+	var result *ApiResult
+	for _, it := range testCases {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it.path)
+		method := http.MethodGet
+		if strings.HasPrefix(it.path, "copy") {
+			method = http.MethodPost
+		}
+		req, err := NewApiRequest(testUrl, method, testUser)
+		if nil != err {
+			t.Error(fmt.Sprintf("unexpected path %v failed validation, got: %v", it.path, err))
+			return
+		}
+		result = req.HandleApiRequest(mgr, authz, quota, nil, nil)
+		if !strings.HasPrefix(result.Result, it.expected) {
+			t.Error(fmt.Sprintf("unexpected result for %v, got: %v", it.path, result.Result))
+			return
+		}
+		if "upload/@user/b?size=10" == it.path {
+			uploadUrl := result.Data.(string)
+			// the signed size must match the upload
+			var err error
+			for _, body := range []string{"0123456789abc", "0123456789"} {
+				req, _ := http.NewRequest(http.MethodPut, uploadUrl, bytes.NewBufferString(body))
+				req.Header.Set("Content-Type", "application/octet-stream")
+				var resp *http.Response
+				resp, err = http.DefaultClient.Do(req)
+				if nil != err {
+					break
+				}
+				resp.Body.Close()
+				if (len(body) == 10) != (resp.StatusCode == http.StatusOK) {
+					t.Error(fmt.Sprintf("unexpected status for a %v byte upload, got: %v", len(body), resp.StatusCode))
+					return
+				}
+			}
+			if nil != err {
+				t.Error(fmt.Sprintf("failed to upload, got: %v", err))
+				return
+			}
+		}
+	}
+	usage := result.Data.(*WorkspaceUsage)
+	if usage.SizeBytes != 20 || usage.ObjectCount != 2 || usage.MaxBytes != 20 {
+		t.Error(fmt.Sprintf("unexpected usage, got: %v", usage))
+	}
+}
@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// UsageReportPath is the endpoint that serves the JSON usage report
+const UsageReportPath = "/ws-storage/admin/usage"
+
+// UserUsage is the size and count of the objects in a user's workspace
+type UserUsage struct {
+	User        string
+	SizeBytes   int64
+	ObjectCount int64
+}
+
+// UsageReport is the per-user usage under the bucket prefix
+type UsageReport struct {
+	// Generated is when the walk of the bucket prefix finished
+	Generated    time.Time
+	// Duration is how long the walk took
+	Duration     string
+	SizeBytes    int64
+	ObjectCount  int64
+	// Users is sorted by user name
+	Users        []UserUsage
+}
+
+// buildUsageReport walks every object under the bucket prefix,
+// and sums each user's objects - objects under a named workspace
+// prefix nested in the bucket prefix are not a user's
+func buildUsageReport(store treeStore, config *Config) (*UsageReport, error) {
+	start := time.Now()
+	root := strings.TrimSuffix(config.BucketPrefix, "/")
+	if "" != root {
+		root += "/"
+	}
+	skip := []string{}
+	for _, prefix := range config.WorkspacePrefixes {
+		skip = append(skip, strings.TrimSuffix(prefix, "/")+"/")
+	}
+	users := map[string]*UserUsage{}
+	page := ""
+	for {
+		objects, nextPage, err := store.listTree(root, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range objects {
+			userPath := strings.TrimPrefix(item.Path, root)
+			ix := strings.Index(userPath, "/")
+			if ix < 1 || hasAnyPrefix(item.Path, skip) {
+				continue
+			}
+			user := userPath[:ix]
+			usage, ok := users[user]
+			if !ok {
+				usage = &UserUsage{User: user}
+				users[user] = usage
+			}
+			usage.SizeBytes += item.SizeBytes
+			usage.ObjectCount += 1
+		}
+		if "" == nextPage {
+			break
+		}
+		page = nextPage
+	}
+	result := &UsageReport{Users: make([]UserUsage, 0, len(users))}
+	for _, usage := range users {
+		result.Users = append(result.Users, *usage)
+		result.SizeBytes += usage.SizeBytes
+		result.ObjectCount += usage.ObjectCount
+	}
+	sort.Slice(result.Users, func(i, j int) bool { return result.Users[i].User < result.Users[j].User })
+	result.Generated = time.Now().UTC()
+	result.Duration = time.Since(start).String()
+	return result, nil
+}
+
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// UsageReporter periodically walks the bucket prefix in the
+// background, publishes each user's usage as prometheus gauges,
+// and serves the latest report to admins
+type UsageReporter struct {
+	store     treeStore
+	config    *Config
+	interval  time.Duration
+	admins    map[string]bool
+	bytes     *prometheus.GaugeVec
+	objects   *prometheus.GaugeVec
+	generated prometheus.Gauge
+	lock      sync.RWMutex
+	report    *UsageReport
+}
+
+// NewUsageReporter registers the usage gauges with the given
+// registerer (ex: prometheus.DefaultRegisterer) -
+// call Start to begin walking the bucket
+func NewUsageReporter(mgr Manager, config *Config, registerer prometheus.Registerer) (*UsageReporter, error) {
+	store, ok := mgr.(treeStore)
+	if !ok {
+		return nil, fmt.Errorf("usage report not supported by backend %v", config.Backend)
+	}
+	reportConfig := config.UsageReport
+	if nil == reportConfig {
+		reportConfig = &UsageReportConfig{}
+	}
+	interval := 60 * time.Minute
+	if reportConfig.IntervalMinutes > 0 {
+		interval = time.Duration(reportConfig.IntervalMinutes) * time.Minute
+	}
+	admins := map[string]bool{}
+	for _, user := range reportConfig.Admins {
+		admins[user] = true
+	}
+	result := &UsageReporter{
+		store:    store,
+		config:   config,
+		interval: interval,
+		admins:   admins,
+		bytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ws_storage_user_bytes",
+			Help: "Bytes stored in each user's workspace",
+		}, []string{"user"}),
+		objects: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ws_storage_user_objects",
+			Help: "Objects stored in each user's workspace",
+		}, []string{"user"}),
+		generated: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ws_storage_usage_report_timestamp_seconds",
+			Help: "Unix time of the last completed usage report",
+		}),
+	}
+	for _, collector := range []prometheus.Collector{result.bytes, result.objects, result.generated} {
+		if err := registerer.Register(collector); nil != err {
+			return nil, fmt.Errorf("failed to register usage gauges - %v", err)
+		}
+	}
+	return result, nil
+}
+
+// Refresh walks the bucket prefix, and publishes the new report
+func (self *UsageReporter) Refresh() (*UsageReport, error) {
+	report, err := buildUsageReport(self.store, self.config)
+	if nil != err {
+		return nil, err
+	}
+	// reset, so users whose objects are all gone drop out
+	self.bytes.Reset()
+	self.objects.Reset()
+	for _, it := range report.Users {
+		self.bytes.WithLabelValues(it.User).Set(float64(it.SizeBytes))
+		self.objects.WithLabelValues(it.User).Set(float64(it.ObjectCount))
+	}
+	self.generated.Set(float64(report.Generated.Unix()))
+	self.lock.Lock()
+	self.report = report
+	self.lock.Unlock()
+	log.Info().Str("Func", "UsageReporter.Refresh").
+		Int("Users", len(report.Users)).
+		Int64("SizeBytes", report.SizeBytes).
+		Int64("ObjectCount", report.ObjectCount).
+		Str("Duration", report.Duration).
+		Send()
+	return report, nil
+}
+
+// Start refreshes the report now and every interval in the background
+func (self *UsageReporter) Start() {
+	go func() {
+		ticker := time.NewTicker(self.interval)
+		defer ticker.Stop()
+		for {
+			if _, err := self.Refresh(); nil != err {
+				log.Error().Str("Func", "UsageReporter.Refresh").Msgf("failed to build usage report - %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Report returns the latest report - nil before the first walk completes
+func (self *UsageReporter) Report() *UsageReport {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.report
+}
+
+// Handler serves the latest report to the configured admins
+// identified by authn
+func (self *UsageReporter) Handler(authn Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := authn.Authenticate(r)
+		if nil != err {
+			writeApiResult(w, newUnauthorizedResult())
+			return
+		}
+		if !self.admins[user] {
+			writeApiResult(w, newErrorResult("usage-report", fmt.Errorf("%w - %v may not read the usage report", ErrForbidden, user)))
+			return
+		}
+		report := self.Report()
+		if nil == report {
+			writeApiResult(w, newErrorResult("usage-report", unavailablef("usage report not ready")))
+			return
+		}
+		bytes, err := json.Marshal(report)
+		if nil != err {
+			writeApiResult(w, newErrorResult("usage-report", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes)
+	})
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUsageReporter(t *testing.T) {
+	config := &Config{
+		BucketPrefix:      "ws-storage-testsuite",
+		WorkspacePrefixes: map[string]string{"@group": "ws-storage-testsuite/groups"},
+		UsageReport:       &UsageReportConfig{Admins: []string{"goTestAdmin"}},
+	}
+	mgr, err := NewMemoryManager(config)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	for _, path := range []string{
+		"ws-storage-testsuite/goTestUser/a",
+		"ws-storage-testsuite/goTestUser/sub/b",
+		"ws-storage-testsuite/goTestReader/c",
+		"ws-storage-testsuite/groups/lab1/d",
+		"ws-storage-testsuite/e",
+		"other/goTestUser/f",
+	} {
+		mgr.writeBlob(path, strings.NewReader("0123456789"), nil)
+	}
+	reporter, err := NewUsageReporter(mgr, config, prometheus.NewRegistry())
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize usage reporter, got: %v", err))
+		return
+	}
+	handler := reporter.Handler(&RemoteUserAuthenticator{})
+	req := httptest.NewRequest(http.MethodGet, UsageReportPath, nil)
+	req.Header.Set("REMOTE_USER", "goTestAdmin")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 503 {
+		t.Error(fmt.Sprintf("expected 503 before the first report, got: %v", rec.Code))
+	}
+
+	report, err := reporter.Refresh()
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to build usage report, got: %v", err))
+		return
+	}
+	if report.SizeBytes != 30 || report.ObjectCount != 3 || len(report.Users) != 2 ||
+		report.Users[0] != (UserUsage{User: "goTestReader", SizeBytes: 10, ObjectCount: 1}) ||
+		report.Users[1] != (UserUsage{User: testUser, SizeBytes: 20, ObjectCount: 2}) {
+		t.Error(fmt.Sprintf("unexpected usage report, got: %v", report))
+		return
+	}
+	if value := testutil.ToFloat64(reporter.bytes.WithLabelValues(testUser)); value != 20 {
+		t.Error(fmt.Sprintf("unexpected bytes gauge, got: %v", value))
+	}
+	if value := testutil.ToFloat64(reporter.objects.WithLabelValues("goTestReader")); value != 1 {
+		t.Error(fmt.Sprintf("unexpected objects gauge, got: %v", value))
+	}
+	// users without objects drop out of the gauges
+	mgr.deletePaths([]string{"ws-storage-testsuite/goTestReader/c"})
+	reporter.Refresh()
+	if count := testutil.CollectAndCount(reporter.bytes); count != 1 {
+		t.Error(fmt.Sprintf("expected 1 user in the bytes gauge, got: %v", count))
+	}
+
+	testCases := []struct {
+		user     string
+		expected int
+	}{
+		{"", 401},
+		{testUser, 403},
+		{"goTestAdmin", 200},
+	}
+	for _, it := range testCases {
+		req := httptest.NewRequest(http.MethodGet, UsageReportPath, nil)
+		if "" != it.user {
+			req.Header.Set("REMOTE_USER", it.user)
+		}
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != it.expected {
+			t.Error(fmt.Sprintf("unexpected status for %v, got: %v", it.user, rec.Code))
+		}
+	}
+	served := &UsageReport{}
+	if err := json.Unmarshal(rec.Body.Bytes(), served); nil != err || len(served.Users) != 1 || served.SizeBytes != 20 {
+		t.Error(fmt.Sprintf("unexpected served report, got: %v, %v", served, err))
+	}
+}
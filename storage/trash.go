@@ -0,0 +1,556 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// trashFolder is the folder at the root of each workspace
+// that holds deleted objects when the trash is enabled
+const trashFolder = ".trash/"
+
+// trashIdFormat names the trash folder of each delete
+// by the time of the delete, so ids sort by time
+const trashIdFormat = "20060102T150405.000000000Z"
+
+// trashRecordSuffix names the deletion record of each trash id -
+// .trash/$id.json sits beside the .trash/$id/ folder of its items
+const trashRecordSuffix = ".json"
+
+// trashRecord is the deletion metadata shared by
+// the items of one delete
+type trashRecord struct {
+	// DeletedBy is the user that deleted the items
+	DeletedBy string
+}
+
+// TrashItem is a deleted object in a workspace's trash
+type TrashItem struct {
+	Workspace    string
+	// TrashKey identifies the item to restore or purge - $id/$key
+	TrashKey     string
+	// WorkspaceKey is the key the object was deleted from
+	WorkspaceKey string
+	SizeBytes    int64
+	Deleted      time.Time
+	// DeletedBy is the user that deleted the object -
+	// empty if its deletion record is missing
+	DeletedBy    string
+	// Expires is when the sweeper purges the item
+	Expires      time.Time
+}
+
+// TrashListResult is a page of a workspace's trash
+type TrashListResult struct {
+	Workspace string
+	Items     []TrashItem
+	// NextPage is an opaque token to pass back to List
+	// to fetch the next page of items - empty on the last page
+	NextPage  string
+}
+
+// TrashBin turns deletes into moves to the .trash/ folder of
+// the workspace, restores and purges trash items, and
+// sweeps expired items from every workspace in the background
+type TrashBin struct {
+	mgr       Manager
+	store     treeStore
+	mover     exclusiveMover
+	config    *Config
+	retention time.Duration
+	interval  time.Duration
+}
+
+// NewTrashBin makes the trash for the given manager -
+// call Start to begin sweeping expired items
+func NewTrashBin(mgr Manager, config *Config) (*TrashBin, error) {
+	store, ok := mgr.(treeStore)
+	if !ok {
+		return nil, fmt.Errorf("trash not supported by backend %v", config.Backend)
+	}
+	mover, ok := mgr.(exclusiveMover)
+	if !ok {
+		return nil, fmt.Errorf("trash not supported by backend %v", config.Backend)
+	}
+	trashConfig := config.Trash
+	if nil == trashConfig {
+		trashConfig = &TrashConfig{}
+	}
+	retention := 30 * 24 * time.Hour
+	if trashConfig.RetentionDays > 0 {
+		retention = time.Duration(trashConfig.RetentionDays) * 24 * time.Hour
+	}
+	interval := 60 * time.Minute
+	if trashConfig.SweepIntervalMinutes > 0 {
+		interval = time.Duration(trashConfig.SweepIntervalMinutes) * time.Minute
+	}
+	return &TrashBin{
+		mgr:       mgr,
+		store:     store,
+		mover:     mover,
+		config:    config,
+		retention: retention,
+		interval:  interval,
+	}, nil
+}
+
+// Enabled is false for a nil trash - deletes are immediate
+func (self *TrashBin) Enabled() bool {
+	return nil != self
+}
+
+// isTrashKey checks if the key is in the trash folder
+func isTrashKey(key string) bool {
+	return strings.HasPrefix(key, trashFolder) || strings.TrimSuffix(trashFolder, "/") == key
+}
+
+// hideTrashFolder removes the trash folder from
+// the prefixes at the root of a workspace
+func hideTrashFolder(prefixes []string) []string {
+	result := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if trashFolder != prefix {
+			result = append(result, prefix)
+		}
+	}
+	return result
+}
+
+// parseTrashKey splits a trash key $id/$key into
+// the time of the delete and the original key
+func parseTrashKey(trashKey string) (time.Time, string, error) {
+	ix := strings.Index(trashKey, "/")
+	if ix < 1 || ix == len(trashKey)-1 {
+		return time.Time{}, "", invalidInputf("invalid trash key - expected $id/$key, got %v", trashKey)
+	}
+	deleted, err := time.Parse(trashIdFormat, trashKey[:ix])
+	if nil != err {
+		return time.Time{}, "", invalidInputf("invalid trash key - bad id %v", trashKey[:ix])
+	}
+	return deleted, trashKey[ix+1:], nil
+}
+
+// parseTrashRecord extracts the time of the delete from
+// the name of a deletion record, $id.json
+func parseTrashRecord(name string) (time.Time, bool) {
+	if !strings.HasSuffix(name, trashRecordSuffix) || strings.Contains(name, "/") {
+		return time.Time{}, false
+	}
+	deleted, err := time.Parse(trashIdFormat, strings.TrimSuffix(name, trashRecordSuffix))
+	return deleted, nil == err
+}
+
+// writeRecord stores the deletion record of a new trash id
+// before its items are moved, so no item lacks a record -
+// the sweeper removes the record with the items
+func (self *TrashBin) writeRecord(cx *SessionContext, workspace *workspaceRef, id string) (string, error) {
+	recordPath, err := workspace.Path(trashFolder + id + trashRecordSuffix)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(&trashRecord{DeletedBy: cx.User})
+	if err != nil {
+		return "", err
+	}
+	if err := self.store.putObject(recordPath, data); nil != err {
+		return "", err
+	}
+	return recordPath, nil
+}
+
+// readRecord reads the user from the deletion record at the
+// given path - empty if the record is missing or unreadable,
+// ex: items trashed before records were kept
+func (self *TrashBin) readRecord(recordPath string) (string, error) {
+	data, err := self.store.getObject(recordPath)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	record := &trashRecord{}
+	if err := json.Unmarshal(data, record); nil != err {
+		log.Warn().Str("Func", "TrashBin.List").Str("Path", recordPath).Msgf("ignoring bad deletion record - %v", err)
+		return "", nil
+	}
+	return record.DeletedBy, nil
+}
+
+// Trash moves the object to the trash
+func (self *TrashBin) Trash(cx *SessionContext, workspaceIn string, key string) error {
+	if isTrashKey(key) {
+		return invalidInputf("invalid key - purge trash items instead: %v", key)
+	}
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
+	}
+	id := time.Now().UTC().Format(trashIdFormat)
+	recordPath, err := self.writeRecord(cx, workspace, id)
+	if err != nil {
+		return err
+	}
+	if err := self.mgr.Move(cx, workspaceIn, key, trashFolder+id+"/"+key); nil != err {
+		self.store.deletePaths([]string{recordPath})
+		return err
+	}
+	log.Debug().Str("Func", "Trash").
+		Str("Workspace", workspaceIn).
+		Str("Key", key).
+		Str("TrashId", id).
+		Send()
+	return nil
+}
+
+// TrashPrefix moves every object under the given folder
+// prefix to the trash - all with the same trash id
+func (self *TrashBin) TrashPrefix(cx *SessionContext, workspaceIn string, prefix string) (*DeletePrefixResult, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	// a recursive delete must name a folder - never the whole workspace
+	if "" == prefix || !strings.HasSuffix(prefix, "/") || isTrashKey(prefix) {
+		return nil, invalidInputf("invalid prefix - a recursive delete requires a folder prefix ending in /, got %v", prefix)
+	}
+	s3path, err := workspace.Path(prefix)
+	if err != nil {
+		return nil, err
+	}
+	s3prefix, err := workspace.Path("")
+	if err != nil {
+		return nil, err
+	}
+	id := time.Now().UTC().Format(trashIdFormat)
+	recordPath, err := self.writeRecord(cx, workspace, id)
+	if err != nil {
+		return nil, err
+	}
+	result := &DeletePrefixResult{
+		Workspace: workspace.Name,
+		Prefix:    prefix,
+		Failures:  []DeleteFailure{},
+	}
+	page := ""
+	for {
+		objects, nextPage, err := self.store.listTree(s3path, page)
+		if err != nil {
+			self.store.deletePaths([]string{recordPath})
+			return nil, err
+		}
+		for _, item := range objects {
+			key := strings.Replace(item.Path, s3prefix, "", 1)
+			if err := self.mgr.Move(cx, workspaceIn, key, trashFolder+id+"/"+key); nil != err {
+				result.Failures = append(result.Failures, DeleteFailure{WorkspaceKey: key, Error: err.Error()})
+			} else {
+				result.DeletedCount += 1
+			}
+		}
+		if "" == nextPage {
+			break
+		}
+		page = nextPage
+	}
+	if 0 == result.DeletedCount {
+		self.store.deletePaths([]string{recordPath})
+	}
+	log.Debug().Str("Func", "TrashPrefix").
+		Str("Workspace", workspace.Name).
+		Str("Prefix", prefix).
+		Str("TrashId", id).
+		Int("DeletedCount", result.DeletedCount).
+		Int("FailedCount", len(result.Failures)).
+		Send()
+	return result, nil
+}
+
+// trashBefore orders trash keys most recently deleted first,
+// then by key within a delete
+func trashBefore(a string, b string) bool {
+	idA, keyA := a[:strings.Index(a, "/")], a[strings.Index(a, "/")+1:]
+	idB, keyB := b[:strings.Index(b, "/")], b[strings.Index(b, "/")+1:]
+	if idA != idB {
+		return idA > idB
+	}
+	return keyA < keyB
+}
+
+// List lists a page of the workspace's trash, most recently
+// deleted first - page is the NextPage token from a previous
+// List call or empty for the first page, and limit caps the
+// number of items returned (0 means MaxListLimit).
+// The order spans the whole trash, so each page lists
+// the trash folder in full, but reads only its own
+// items' deletion records
+func (self *TrashBin) List(cx *SessionContext, workspaceIn string, page string, limit int) (*TrashListResult, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 0 || limit > MaxListLimit {
+		return nil, invalidInputf("invalid limit - must be between 0 and %v, got %v", MaxListLimit, limit)
+	}
+	if limit == 0 {
+		limit = MaxListLimit
+	}
+	if "" != page {
+		if _, _, err := parseTrashKey(page); nil != err {
+			return nil, invalidInputf("invalid page - expected the NextPage of a trash listing, got %v", page)
+		}
+	}
+	s3path, err := workspace.Path(trashFolder)
+	if err != nil {
+		return nil, err
+	}
+	items := []TrashItem{}
+	listPage := ""
+	for {
+		objects, nextPage, err := self.store.listTree(s3path, listPage)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range objects {
+			trashKey := strings.TrimPrefix(item.Path, s3path)
+			deleted, key, err := parseTrashKey(trashKey)
+			if nil != err {
+				// not put there by Trash - or a deletion record
+				continue
+			}
+			items = append(items, TrashItem{
+				Workspace:    workspace.Name,
+				TrashKey:     trashKey,
+				WorkspaceKey: key,
+				SizeBytes:    item.SizeBytes,
+				Deleted:      deleted,
+				Expires:      deleted.Add(self.retention),
+			})
+		}
+		if "" == nextPage {
+			break
+		}
+		listPage = nextPage
+	}
+	sort.Slice(items, func(i, j int) bool { return trashBefore(items[i].TrashKey, items[j].TrashKey) })
+	result := &TrashListResult{Workspace: workspace.Name}
+	if "" != page {
+		// start after the last item of the previous page
+		items = items[sort.Search(len(items), func(ix int) bool { return trashBefore(page, items[ix].TrashKey) }):]
+	}
+	if len(items) > limit {
+		items = items[:limit]
+		result.NextPage = items[limit-1].TrashKey
+	}
+	// the items of one delete share a record
+	deletedBy := map[string]string{}
+	for ix := range items {
+		id := items[ix].TrashKey[:strings.Index(items[ix].TrashKey, "/")]
+		user, ok := deletedBy[id]
+		if !ok {
+			user, err = self.readRecord(s3path + id + trashRecordSuffix)
+			if err != nil {
+				return nil, err
+			}
+			deletedBy[id] = user
+		}
+		items[ix].DeletedBy = user
+	}
+	result.Items = items
+	return result, nil
+}
+
+// Restore moves a trash item back to the key it was deleted from -
+// fails with ErrConflict if the key has been reused since
+func (self *TrashBin) Restore(cx *SessionContext, workspaceIn string, trashKey string) error {
+	_, key, err := parseTrashKey(trashKey)
+	if nil != err {
+		return err
+	}
+	if err := self.mover.moveExclusive(cx, workspaceIn, trashFolder+trashKey, key); errors.Is(err, ErrConflict) {
+		return fmt.Errorf("%w - move or delete it first", err)
+	} else if nil != err {
+		return err
+	}
+	log.Debug().Str("Func", "RestoreTrash").
+		Str("Workspace", workspaceIn).
+		Str("TrashKey", trashKey).
+		Send()
+	return nil
+}
+
+// Purge deletes a trash item - or every item if trashKey is empty
+func (self *TrashBin) Purge(cx *SessionContext, workspaceIn string, trashKey string) (*DeletePrefixResult, error) {
+	if "" == trashKey {
+		return self.purgeAll(cx, workspaceIn)
+	}
+	if _, _, err := parseTrashKey(trashKey); nil != err {
+		return nil, err
+	}
+	if _, err := self.mgr.Stat(cx, workspaceIn, trashFolder+trashKey); nil != err {
+		return nil, err
+	}
+	return nil, self.mgr.DeleteObject(cx, workspaceIn, trashFolder+trashKey)
+}
+
+// purgeAll deletes every item in the trash and the deletion
+// records with them - only items count as deleted
+func (self *TrashBin) purgeAll(cx *SessionContext, workspaceIn string) (*DeletePrefixResult, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	s3path, err := workspace.Path(trashFolder)
+	if err != nil {
+		return nil, err
+	}
+	s3prefix, err := workspace.Path("")
+	if err != nil {
+		return nil, err
+	}
+	result := &DeletePrefixResult{
+		Workspace: workspace.Name,
+		Prefix:    trashFolder,
+		Failures:  []DeleteFailure{},
+	}
+	page := ""
+	for {
+		objects, nextPage, err := self.store.listTree(s3path, page)
+		if err != nil {
+			return nil, err
+		}
+		paths := make([]string, len(objects))
+		for ix, item := range objects {
+			paths[ix] = item.Path
+		}
+		if len(paths) > 0 {
+			failures, err := self.store.deletePaths(paths)
+			if err != nil {
+				return nil, err
+			}
+			for _, path := range paths {
+				if failure, ok := failures[path]; ok {
+					result.Failures = append(result.Failures, DeleteFailure{
+						WorkspaceKey: strings.Replace(path, s3prefix, "", 1),
+						Error:        failure.Error(),
+					})
+				} else if _, isRecord := parseTrashRecord(strings.TrimPrefix(path, s3path)); !isRecord {
+					result.DeletedCount += 1
+				}
+			}
+		}
+		if "" == nextPage {
+			break
+		}
+		page = nextPage
+	}
+	log.Debug().Str("Func", "PurgeTrash").
+		Str("Workspace", workspace.Name).
+		Int("DeletedCount", result.DeletedCount).
+		Int("FailedCount", len(result.Failures)).
+		Send()
+	return result, nil
+}
+
+// Sweep deletes the expired items from the trash of every workspace -
+// a workspace's trash is $prefix/$name/.trash/ under the bucket prefix
+// or a named workspace type's prefix, so Sweep lists the workspace
+// folders under each prefix, then only their trash folders
+func (self *TrashBin) Sweep() (int, error) {
+	roots := []string{self.config.BucketPrefix}
+	for _, prefix := range self.config.WorkspacePrefixes {
+		roots = append(roots, prefix)
+	}
+	cutoff := time.Now().Add(-self.retention)
+	count := 0
+	for _, root := range roots {
+		root = strings.TrimSuffix(root, "/")
+		if "" != root {
+			root += "/"
+		}
+		page := ""
+		for {
+			folders, nextPage, err := self.store.listFolders(root, page)
+			if err != nil {
+				return count, err
+			}
+			for _, folder := range folders {
+				purged, err := self.sweepTrash(folder+trashFolder, cutoff)
+				count += purged
+				if err != nil {
+					return count, err
+				}
+			}
+			if "" == nextPage {
+				break
+			}
+			page = nextPage
+		}
+	}
+	trashPurgedTotal.Add(float64(count))
+	log.Info().Str("Func", "TrashBin.Sweep").Int("PurgedCount", count).Send()
+	return count, nil
+}
+
+// sweepTrash deletes the items deleted before the cutoff
+// from the trash folder at the given path, and their deletion
+// records - only items count as purged
+func (self *TrashBin) sweepTrash(trashPath string, cutoff time.Time) (int, error) {
+	count := 0
+	page := ""
+	for {
+		objects, nextPage, err := self.store.listTree(trashPath, page)
+		if err != nil {
+			return count, err
+		}
+		expired := []string{}
+		records := []string{}
+		for _, item := range objects {
+			name := strings.TrimPrefix(item.Path, trashPath)
+			if deleted, ok := parseTrashRecord(name); ok {
+				if deleted.Before(cutoff) {
+					records = append(records, item.Path)
+				}
+				continue
+			}
+			deleted, _, err := parseTrashKey(name)
+			if nil == err && deleted.Before(cutoff) {
+				expired = append(expired, item.Path)
+			}
+		}
+		if len(expired) > 0 {
+			failures, err := self.store.deletePaths(expired)
+			if err != nil {
+				return count, err
+			}
+			count += len(expired) - len(failures)
+		}
+		if len(records) > 0 {
+			if _, err := self.store.deletePaths(records); nil != err {
+				return count, err
+			}
+		}
+		if "" == nextPage {
+			return count, nil
+		}
+		page = nextPage
+	}
+}
+
+// Start sweeps now and every interval in the background
+func (self *TrashBin) Start() {
+	go func() {
+		ticker := time.NewTicker(self.interval)
+		defer ticker.Stop()
+		for {
+			if _, err := self.Sweep(); nil != err {
+				log.Error().Str("Func", "TrashBin.Sweep").Msgf("failed to sweep trash - %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrashApiRequest(t *testing.T) {
+	testCases := [][]string{
+		{http.MethodGet, "trash/@user", "trash-list", ""},
+		{http.MethodPost, "trash/@user/20261018T080654.000000000Z/a/b", "trash-restore", "20261018T080654.000000000Z/a/b"},
+		{http.MethodDelete, "trash/@user/20261018T080654.000000000Z/a/b", "trash-purge", "20261018T080654.000000000Z/a/b"},
+		{http.MethodDelete, "trash/@user", "trash-purge", ""},
+	}
+	for _, it := range testCases {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it[1])
+		req, err := NewApiRequest(testUrl, it[0], testUser)
+		if nil != err {
+			t.Error(fmt.Sprintf("unexpected %v %v failed validation, got: %v", it[0], it[1], err))
+			return
+		}
+		if it[2] != req.Verb || it[3] != req.Key {
+			t.Error(fmt.Sprintf("unexpected %v %v verb and key, got: %v, %v", it[0], it[1], req.Verb, req.Key))
+			return
+		}
+	}
+	for _, it := range [][]string{{http.MethodPost, "trash/@user"}, {http.MethodPut, "trash/@user/x"}} {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it[1])
+		if _, err := NewApiRequest(testUrl, it[0], testUser); nil == err {
+			t.Error(fmt.Sprintf("%v %v should have failed validation", it[0], it[1]))
+		}
+	}
+}
+
+func TestTrashBin(t *testing.T) {
+	config := &Config{BucketPrefix: "ws-storage-testsuite", Trash: &TrashConfig{RetentionDays: 7}}
+	mgr, err := NewMemoryManager(config)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	for _, path := range []string{"ws-storage-testsuite/goTestUser/a", "ws-storage-testsuite/goTestUser/sub/b", "ws-storage-testsuite/goTestUser/sub/c"} {
+		mgr.writeBlob(path, strings.NewReader("0123456789"), nil)
+	}
+	trash, err := NewTrashBin(mgr, config)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize trash, got: %v", err))
+		return
+	}
+	authz := NewStaticAuthorizer(nil)
+	doRequest := func(method string, path string) *ApiResult {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + path)
+		req, err := NewApiRequest(testUrl, method, testUser)
+		if nil != err {
+			return newErrorResult("", err)
+		}
+		return req.HandleApiRequest(mgr, authz, nil, trash, nil)
+	}
+
+	if result := doRequest(http.MethodDelete, "list/@user/a"); nil != result.Error {
+		t.Error(fmt.Sprintf("failed to trash a, got: %v", result.Result))
+		return
+	}
+	if result := doRequest(http.MethodDelete, "list/@user/sub/?recursive=true"); nil != result.Error ||
+		2 != result.Data.(*DeletePrefixResult).DeletedCount {
+		t.Error(fmt.Sprintf("failed to trash sub/, got: %v", result.Result))
+		return
+	}
+	listing := doRequest(http.MethodGet, "list/@user").Data.(*ListResult)
+	if 0 != len(listing.Objects) || 0 != len(listing.Prefixes) {
+		t.Error(fmt.Sprintf("expected an empty workspace with the trash hidden, got: %v", listing))
+		return
+	}
+	if result := doRequest(http.MethodGet, "download/@user/.trash/x/a"); nil == result.Error || ErrorCodeInvalidInput != result.Error.Code {
+		t.Error(fmt.Sprintf("expected the trash folder to be reserved, got: %v", result.Result))
+		return
+	}
+
+	items := doRequest(http.MethodGet, "trash/@user").Data.(*TrashListResult).Items
+	if 3 != len(items) {
+		t.Error(fmt.Sprintf("expected 3 trash items, got: %v", items))
+		return
+	}
+	// pages list most recently deleted first, sub/ before a
+	firstPage := doRequest(http.MethodGet, "trash/@user?limit=2").Data.(*TrashListResult)
+	if 2 != len(firstPage.Items) || "" == firstPage.NextPage || "sub/b" != firstPage.Items[0].WorkspaceKey || "sub/c" != firstPage.Items[1].WorkspaceKey {
+		t.Error(fmt.Sprintf("unexpected first trash page, got: %v", firstPage))
+		return
+	}
+	lastPage := doRequest(http.MethodGet, "trash/@user?limit=2&page="+url.QueryEscape(firstPage.NextPage)).Data.(*TrashListResult)
+	if 1 != len(lastPage.Items) || "" != lastPage.NextPage || "a" != lastPage.Items[0].WorkspaceKey {
+		t.Error(fmt.Sprintf("unexpected last trash page, got: %v", lastPage))
+		return
+	}
+	if result := doRequest(http.MethodGet, "trash/@user?page=bogus"); nil == result.Error || ErrorCodeInvalidInput != result.Error.Code {
+		t.Error(fmt.Sprintf("expected a bad page to fail, got: %v", result.Result))
+		return
+	}
+	var itemA *TrashItem
+	for ix, it := range items {
+		if it.Expires != it.Deleted.Add(7*24*time.Hour) || 10 != it.SizeBytes || testUser != it.DeletedBy {
+			t.Error(fmt.Sprintf("unexpected trash item, got: %v", it))
+			return
+		}
+		if "a" == it.WorkspaceKey {
+			itemA = &items[ix]
+		}
+	}
+	if result := doRequest(http.MethodPost, "trash/@user/"+itemA.TrashKey); nil != result.Error {
+		t.Error(fmt.Sprintf("failed to restore a, got: %v", result.Result))
+		return
+	}
+	if _, err := mgr.Stat(NewSessionContext(testUser), "@user", "a"); nil != err {
+		t.Error(fmt.Sprintf("restored a is missing, got: %v", err))
+		return
+	}
+	missing := strings.Replace(itemA.TrashKey, "/a", "/missing", 1)
+	if result := doRequest(http.MethodPost, "trash/@user/"+missing); nil == result.Error || ErrorCodeNotFound != result.Error.Code {
+		t.Error(fmt.Sprintf("expected restore of a missing item to fail, got: %v", result.Result))
+		return
+	}
+
+	// a restore may not overwrite a new object at the original key
+	cx := NewSessionContext(testUser)
+	if err := trash.Trash(cx, "@user", "a"); nil != err {
+		t.Error(fmt.Sprintf("failed to trash a, got: %v", err))
+		return
+	}
+	mgr.writeBlob("ws-storage-testsuite/goTestUser/a", strings.NewReader("new"), nil)
+	trashListing, _ := trash.List(cx, "@user", "", 0)
+	items = trashListing.Items
+	if err := trash.Restore(cx, "@user", items[0].TrashKey); !errors.Is(err, ErrConflict) {
+		t.Error(fmt.Sprintf("expected restore over an existing object to fail, got: %v", err))
+		return
+	}
+	if result := doRequest(http.MethodPost, "trash/@user/"+items[0].TrashKey); http.StatusConflict != result.StatusCode() {
+		t.Error(fmt.Sprintf("expected restore over an existing object to conflict, got: %v", result.Result))
+		return
+	}
+	if stat, err := mgr.Stat(cx, "@user", "a"); nil != err || 3 != stat.SizeBytes {
+		t.Error(fmt.Sprintf("a failed restore should keep the new object, got: %v, %v", stat, err))
+		return
+	}
+
+	if result := doRequest(http.MethodDelete, "trash/@user/"+items[0].TrashKey); nil != result.Error {
+		t.Error(fmt.Sprintf("failed to purge item, got: %v", result.Result))
+		return
+	}
+	if result := doRequest(http.MethodDelete, "trash/@user"); nil != result.Error || 2 != result.Data.(*DeletePrefixResult).DeletedCount {
+		t.Error(fmt.Sprintf("failed to purge trash, got: %v", result.Result))
+		return
+	}
+	if listing, _ := trash.List(cx, "@user", "", 0); 0 != len(listing.Items) {
+		t.Error(fmt.Sprintf("expected empty trash, got: %v", listing.Items))
+	}
+	for path := range mgr.objects {
+		if strings.Contains(path, "/"+trashFolder) {
+			t.Error(fmt.Sprintf("expected the purge to remove deletion records, got: %v", path))
+		}
+	}
+
+	// items trashed without a record list with no user
+	mgr.writeBlob("ws-storage-testsuite/goTestUser/.trash/"+time.Now().UTC().Format(trashIdFormat)+"/old", strings.NewReader("0"), nil)
+	if listing, err := trash.List(cx, "@user", "", 0); nil != err || 1 != len(listing.Items) || "" != listing.Items[0].DeletedBy {
+		t.Error(fmt.Sprintf("expected an item with no deleting user, got: %v, %v", listing, err))
+	}
+	trash.Purge(cx, "@user", "")
+
+	if result := doRequest(http.MethodGet, "trash/@user"); "ok" != result.Result {
+		t.Error(fmt.Sprintf("failed to list empty trash, got: %v", result.Result))
+	}
+	testUrl, _ := url.Parse("https://whatever/ws-storage/trash/@user")
+	req, _ := NewApiRequest(testUrl, http.MethodGet, testUser)
+	if result := req.HandleApiRequest(mgr, authz, nil, nil, nil); nil == result.Error || ErrorCodeInvalidInput != result.Error.Code {
+		t.Error(fmt.Sprintf("expected trash requests to fail without a trash, got: %v", result.Result))
+	}
+}
+
+func TestTrashSweep(t *testing.T) {
+	config := &Config{
+		BucketPrefix:      "ws-storage-testsuite",
+		WorkspacePrefixes: map[string]string{"@group": "ws-storage-groups"},
+		Trash:             &TrashConfig{RetentionDays: 7},
+	}
+	mgr, err := NewMemoryManager(config)
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	expired := time.Now().UTC().Add(-8 * 24 * time.Hour).Format(trashIdFormat)
+	recent := time.Now().UTC().Add(-6 * 24 * time.Hour).Format(trashIdFormat)
+	paths := map[string]bool{
+		"ws-storage-testsuite/goTestUser/.trash/" + expired + "/a":     false,
+		"ws-storage-testsuite/goTestUser/.trash/" + expired + ".json":  false,
+		"ws-storage-testsuite/goTestUser/.trash/" + recent + "/b":      true,
+		"ws-storage-testsuite/goTestUser/.trash/" + recent + ".json":   true,
+		"ws-storage-testsuite/other/.trash/" + expired + "/sub/c":      false,
+		"ws-storage-groups/lab1/.trash/" + expired + "/d":              false,
+		"ws-storage-testsuite/goTestUser/sub/.trash/" + expired + "/e": true,
+		"ws-storage-testsuite/goTestUser/f":                            true,
+	}
+	for path := range paths {
+		mgr.writeBlob(path, strings.NewReader("0123456789"), nil)
+	}
+	trash, _ := NewTrashBin(mgr, config)
+	store := &recordingTreeStore{treeStore: mgr}
+	trash.store = store
+	count, err := trash.Sweep()
+	if nil != err || 3 != count {
+		t.Error(fmt.Sprintf("expected 3 expired items swept, got: %v, %v", count, err))
+		return
+	}
+	// only the trash folders are walked - not every object
+	for _, path := range store.listed {
+		if !strings.HasSuffix(path, "/"+trashFolder) {
+			t.Error(fmt.Sprintf("sweep should only list trash folders, got: %v", store.listed))
+			return
+		}
+	}
+	for path, kept := range paths {
+		if _, ok := mgr.objects[path]; ok != kept {
+			t.Error(fmt.Sprintf("unexpected sweep of %v, expected kept %v", path, kept))
+		}
+	}
+}
+
+// recordingTreeStore records the paths listed with listTree
+type recordingTreeStore struct {
+	treeStore
+	listed []string
+}
+
+func (self *recordingTreeStore) listTree(s3path string, page string) ([]storedObject, string, error) {
+	self.listed = append(self.listed, s3path)
+	return self.treeStore.listTree(s3path, page)
+}
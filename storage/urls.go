@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"time"
+)
+
+// defaultUrlTtl is the lifetime of presigned urls
+// if the urls config does not set one
+const defaultUrlTtl = 60 * time.Minute
+
+// maxUrlTtl is the longest lifetime S3 and GCS V4
+// signatures support - no backend signs longer urls
+const maxUrlTtl = 7 * 24 * time.Hour
+
+// urlTtl is the lifetime of a presigned url - the requested
+// lifetime clamped to the configured maximum, or the
+// configured default if none was requested
+func urlTtl(config *Config, requested time.Duration) time.Duration {
+	ttl := defaultUrlTtl
+	if nil != config.Urls && config.Urls.TtlSeconds > 0 {
+		ttl = time.Duration(config.Urls.TtlSeconds) * time.Second
+	}
+	maxTtl := ttl
+	if nil != config.Urls && config.Urls.MaxTtlSeconds > 0 {
+		maxTtl = time.Duration(config.Urls.MaxTtlSeconds) * time.Second
+	}
+	if maxTtl < ttl {
+		maxTtl = ttl
+	}
+	if maxTtl > maxUrlTtl {
+		maxTtl = maxUrlTtl
+	}
+	if requested > 0 {
+		ttl = requested
+	}
+	if ttl > maxTtl {
+		ttl = maxTtl
+	}
+	return ttl
+}
+
+// validatePartOptions checks that a multipart part url is
+// bound like a single upload url, so multipart uploads cannot
+// bypass the urls config - S3 only verifies an md5 or
+// sha256 part checksum
+func validatePartOptions(config *Config, options PartOptions) error {
+	if err := validateUploadOptions(config, UploadOptions{SizeBytes: options.SizeBytes, Checksum: options.Checksum}); nil != err {
+		return err
+	}
+	if nil != options.Checksum && "md5" != options.Checksum.Algorithm && "sha256" != options.Checksum.Algorithm {
+		return invalidInputf("invalid checksumAlgorithm - a part checksum must be md5 or sha256, got %v", options.Checksum.Algorithm)
+	}
+	return nil
+}
+
+// validateUploadOptions checks that an upload declares the
+// constraints the urls config requires, and that a declared
+// checksum is valid
+func validateUploadOptions(config *Config, options UploadOptions) error {
+	if nil != options.Checksum {
+		if err := options.Checksum.validate(); nil != err {
+			return err
+		}
+	}
+	if nil == config.Urls {
+		return nil
+	}
+	if config.Urls.RequireSize && options.SizeBytes <= 0 {
+		return invalidInputf("invalid size - uploads must declare their size")
+	}
+	if config.Urls.RequireChecksum && nil == options.Checksum {
+		return invalidInputf("invalid checksum - uploads must declare their checksum")
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUrlTtl(t *testing.T) {
+	testCases := []struct {
+		urls      *UrlConfig
+		requested time.Duration
+		expected  time.Duration
+	}{
+		{nil, 0, time.Hour},
+		{nil, time.Minute, time.Minute},
+		{nil, 2 * time.Hour, time.Hour},
+		{&UrlConfig{TtlSeconds: 600}, 0, 10 * time.Minute},
+		{&UrlConfig{TtlSeconds: 600}, time.Hour, 10 * time.Minute},
+		{&UrlConfig{TtlSeconds: 600, MaxTtlSeconds: 7200}, time.Hour, time.Hour},
+		{&UrlConfig{TtlSeconds: 600, MaxTtlSeconds: 7200}, 3 * time.Hour, 2 * time.Hour},
+		{&UrlConfig{MaxTtlSeconds: 30 * 24 * 3600}, 30 * 24 * time.Hour, maxUrlTtl},
+	}
+	for ix, it := range testCases {
+		if ttl := urlTtl(&Config{Urls: it.urls}, it.requested); ttl != it.expected {
+			t.Error(fmt.Sprintf("unexpected ttl for case %v, got: %v != %v", ix, ttl, it.expected))
+		}
+	}
+}
+
+func TestValidateUploadOptions(t *testing.T) {
+	digest := md5.Sum([]byte("a,b,c"))
+	contentMD5 := base64.StdEncoding.EncodeToString(digest[:])
+	required := &Config{Urls: &UrlConfig{RequireSize: true, RequireChecksum: true}}
+	testCases := []struct {
+		config  *Config
+		options UploadOptions
+		valid   bool
+	}{
+		{&Config{}, UploadOptions{}, true},
+		{&Config{}, UploadOptions{Checksum: &Checksum{Algorithm: "md5", Value: contentMD5}}, true},
+		{&Config{}, UploadOptions{Checksum: &Checksum{Algorithm: "md5", Value: "frickjack"}}, false},
+		{&Config{}, UploadOptions{Checksum: &Checksum{Algorithm: "md5", Value: base64.StdEncoding.EncodeToString([]byte("short"))}}, false},
+		{required, UploadOptions{SizeBytes: 5, Checksum: &Checksum{Algorithm: "md5", Value: contentMD5}}, true},
+		{required, UploadOptions{Checksum: &Checksum{Algorithm: "md5", Value: contentMD5}}, false},
+		{required, UploadOptions{SizeBytes: 5}, false},
+	}
+	for ix, it := range testCases {
+		err := validateUploadOptions(it.config, it.options)
+		if (nil == err) != it.valid || (nil != err && !errors.Is(err, ErrInvalidInput)) {
+			t.Error(fmt.Sprintf("unexpected validation of case %v, got: %v", ix, err))
+		}
+	}
+}
+
+func TestNewApiRequestUrlOptions(t *testing.T) {
+	testCases := []struct {
+		path    string
+		valid   bool
+		expires time.Duration
+	}{
+		{"upload/@user/x?expires=300", true, 5 * time.Minute},
+		{"download/@user/x?expires=60", true, time.Minute},
+		{"download/@user/x?expires=99999999999999", true, maxUrlTtl},
+		{"download/@user/x?expires=0", false, 0},
+		{"upload/@user/x?expires=soon", false, 0},
+	}
+	for _, it := range testCases {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it.path)
+		req, err := NewApiRequest(testUrl, http.MethodGet, testUser)
+		if (nil == err) != it.valid {
+			t.Error(fmt.Sprintf("unexpected validation of %v, got: %v", it.path, err))
+			continue
+		}
+		if nil == err && req.Expires != it.expires {
+			t.Error(fmt.Sprintf("unexpected url lifetime for %v, got: %v", it.path, req.Expires))
+		}
+	}
+}
+
+func TestMgrUrlConstraints(t *testing.T) {
+	mgr, server, err := newStubS3Mgr(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}))
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	mgr.config.Urls = &UrlConfig{TtlSeconds: 600, MaxTtlSeconds: 7200}
+	cx := NewSessionContext(testUser)
+	digest := md5.Sum([]byte("a,b,c"))
+	contentMD5 := base64.StdEncoding.EncodeToString(digest[:])
+
+	uploadUrl, err := mgr.UploadUrl(cx, "@user", "x", UploadOptions{SizeBytes: 5, Checksum: &Checksum{Algorithm: "md5", Value: contentMD5}})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to generate upload url, got: %v", err))
+		return
+	}
+	// the size and checksum are signed
+	if !strings.Contains(uploadUrl, "X-Amz-SignedHeaders=content-length%3Bcontent-md5%3Bcontent-type%3Bhost") ||
+		!strings.Contains(uploadUrl, "X-Amz-Expires=600&") {
+		t.Error(fmt.Sprintf("upload url does not sign the expected constraints: %v", uploadUrl))
+		return
+	}
+	downloadUrl, err := mgr.DownloadUrl(cx, "@user", "x", DownloadOptions{Expires: 3 * time.Hour})
+	if nil != err || !strings.Contains(downloadUrl, "X-Amz-Expires=7200&") {
+		t.Error(fmt.Sprintf("download url does not clamp the requested ttl, got: %v, %v", downloadUrl, err))
+		return
+	}
+	if _, err := mgr.UploadUrl(cx, "@user", "x", UploadOptions{Checksum: &Checksum{Algorithm: "md5", Value: "frickjack"}}); !errors.Is(err, ErrInvalidInput) {
+		t.Error(fmt.Sprintf("expected an invalid checksum to fail, got: %v", err))
+	}
+}
+
+func TestServeBlobChecksum(t *testing.T) {
+	mgr, err := NewMemoryManager(&Config{BucketPrefix: "ws-storage-testsuite"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	cx := NewSessionContext(testUser)
+	digest := md5.Sum([]byte("a,b,c"))
+	uploadUrl, _ := mgr.UploadUrl(cx, "@user", "data.csv", UploadOptions{Checksum: &Checksum{Algorithm: "md5", Value: base64.StdEncoding.EncodeToString(digest[:])}})
+	testCases := []struct {
+		body     string
+		expected int
+	}{
+		{"x,y,z", http.StatusBadRequest},
+		{"a,b,c", http.StatusOK},
+	}
+	for _, it := range testCases {
+		req := httptest.NewRequest(http.MethodPut, uploadUrl, strings.NewReader(it.body))
+		req.Header.Set("Content-Type", contentTypeByKey("data.csv"))
+		rec := httptest.NewRecorder()
+		mgr.ServeHTTP(rec, req)
+		if rec.Code != it.expected {
+			t.Error(fmt.Sprintf("unexpected status for body %v, got: %v", it.body, rec.Code))
+			return
+		}
+		if _, err := mgr.Stat(cx, "@user", "data.csv"); (nil == err) != (http.StatusOK == it.expected) {
+			t.Error(fmt.Sprintf("unexpected object after upload of %v, got: %v", it.body, err))
+			return
+		}
+	}
+}
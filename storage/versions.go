@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/rs/zerolog/log"
+)
+
+// ObjectVersion is one version of an object in a versioned bucket -
+// versions are listed newest first
+type ObjectVersion struct {
+	Workspace      string
+	WorkspaceKey   string
+	VersionId      string
+	SizeBytes      int64
+	LastModified   time.Time
+	ETag           string
+	// IsLatest marks the current version
+	IsLatest       bool
+	// IsDeleteMarker marks a version that deleted the object -
+	// it has no data, so cannot be downloaded or restored
+	IsDeleteMarker bool
+}
+
+// VersionManager is implemented by managers whose backend keeps
+// the prior versions of overwritten and deleted objects -
+// ex: an S3 bucket with versioning enabled
+type VersionManager interface {
+	ListVersions(cx *SessionContext, workspaceIn string, key string) ([]ObjectVersion, error)
+	DownloadVersionUrl(cx *SessionContext, workspaceIn string, key string, versionId string, options DownloadOptions) (string, error)
+	// RestoreVersion copies a prior version over the object,
+	// so it becomes the current version
+	RestoreVersion(cx *SessionContext, workspaceIn string, key string, versionId string) error
+}
+
+// findVersion finds the given version of an object
+func findVersion(versions []ObjectVersion, versionId string) (*ObjectVersion, error) {
+	for ix := range versions {
+		if versions[ix].VersionId == versionId {
+			return &versions[ix], nil
+		}
+	}
+	return nil, fmt.Errorf("%w - version %v", ErrNotFound, versionId)
+}
+
+// ListVersions lists the versions and delete markers
+// of the given key with ListObjectVersions
+func (self *SimpleManager) ListVersions(cx *SessionContext, workspaceIn string, key string) ([]ObjectVersion, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return nil, err
+	}
+	if "" == key {
+		return nil, invalidInputf("invalid key - versions of an empty key")
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return nil, err
+	}
+	result := []ObjectVersion{}
+	err = self.s3client.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: &self.config.Bucket,
+		Prefix: &s3path,
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		// keys list in order, so the versions of s3path come
+		// before those of longer keys with the same prefix
+		done := false
+		for _, it := range page.Versions {
+			if aws.StringValue(it.Key) != s3path {
+				done = true
+				continue
+			}
+			result = append(result, ObjectVersion{
+				Workspace:    workspace.Name,
+				WorkspaceKey: key,
+				VersionId:    aws.StringValue(it.VersionId),
+				SizeBytes:    aws.Int64Value(it.Size),
+				LastModified: aws.TimeValue(it.LastModified),
+				ETag:         aws.StringValue(it.ETag),
+				IsLatest:     aws.BoolValue(it.IsLatest),
+			})
+		}
+		for _, it := range page.DeleteMarkers {
+			if aws.StringValue(it.Key) != s3path {
+				done = true
+				continue
+			}
+			result = append(result, ObjectVersion{
+				Workspace:      workspace.Name,
+				WorkspaceKey:   key,
+				VersionId:      aws.StringValue(it.VersionId),
+				LastModified:   aws.TimeValue(it.LastModified),
+				IsLatest:       aws.BoolValue(it.IsLatest),
+				IsDeleteMarker: true,
+			})
+		}
+		return !done
+	})
+	if err != nil {
+		return nil, err
+	}
+	if 0 == len(result) {
+		return nil, fmt.Errorf("%w - %v", ErrNotFound, key)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].LastModified.After(result[j].LastModified) })
+	return result, nil
+}
+
+// DownloadVersionUrl generates a presigned download url for the given version
+func (self *SimpleManager) DownloadVersionUrl(cx *SessionContext, workspaceIn string, key string, versionId string, options DownloadOptions) (string, error) {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return "", err
+	}
+	if "" == versionId {
+		return "", invalidInputf("invalid versionId - must not be empty")
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return "", err
+	}
+	input := &s3.GetObjectInput{
+		Bucket:    &self.config.Bucket,
+		Key:       &s3path,
+		VersionId: &versionId,
+	}
+	if "" != options.ContentDisposition {
+		input.ResponseContentDisposition = aws.String(options.ContentDisposition)
+	}
+	req, _ := self.s3client.GetObjectRequest(input)
+	log.Debug().Str("Func", "DownloadVersionUrl").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Str("VersionId", versionId).
+		Send()
+	return req.Presign(urlTtl(self.config, options.Expires))
+}
+
+// RestoreVersion copies the given version over the object with
+// CopyObject - or a multipart copy for versions over 5 GB
+func (self *SimpleManager) RestoreVersion(cx *SessionContext, workspaceIn string, key string, versionId string) error {
+	workspace, err := resolveWorkspace(self.config, cx, workspaceIn)
+	if err != nil {
+		return err
+	}
+	if "" == versionId {
+		return invalidInputf("invalid versionId - must not be empty")
+	}
+	s3path, err := workspace.Path(key)
+	if err != nil {
+		return err
+	}
+	versions, err := self.ListVersions(cx, workspaceIn, key)
+	if err != nil {
+		return err
+	}
+	version, err := findVersion(versions, versionId)
+	if err != nil {
+		return err
+	}
+	if version.IsDeleteMarker {
+		return invalidInputf("invalid version - %v is a delete marker", versionId)
+	}
+	if version.IsLatest {
+		// already current
+		return nil
+	}
+	copySource := (&url.URL{Path: self.config.Bucket + "/" + s3path}).EscapedPath() +
+		"?versionId=" + url.QueryEscape(versionId)
+	if version.SizeBytes > maxCopyObjectBytes {
+		var head *s3.HeadObjectOutput
+		head, err = self.s3client.HeadObject(&s3.HeadObjectInput{
+			Bucket:    &self.config.Bucket,
+			Key:       &s3path,
+			VersionId: &versionId,
+		})
+		if err == nil {
+			err = self.multipartCopy(copySource, s3path, head)
+		}
+	} else {
+		_, err = self.s3client.CopyObject(&s3.CopyObjectInput{
+			Bucket:     &self.config.Bucket,
+			Key:        &s3path,
+			CopySource: &copySource,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	log.Debug().Str("Func", "RestoreVersion").
+		Str("Workspace", workspace.Name).
+		Str("Key", key).
+		Str("VersionId", versionId).
+		Int64("SizeBytes", version.SizeBytes).
+		Send()
+	return nil
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestVersionsApiRequest(t *testing.T) {
+	testCases := [][]string{
+		{http.MethodGet, "versions/@user/out.csv", "versions", ""},
+		{http.MethodGet, "download/@user/out.csv?versionId=v1", "download", "v1"},
+		{http.MethodPost, "restore/@user/out.csv?versionId=v1", "restore", "v1"},
+	}
+	for _, it := range testCases {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it[1])
+		req, err := NewApiRequest(testUrl, it[0], testUser)
+		if nil != err {
+			t.Error(fmt.Sprintf("unexpected %v %v failed validation, got: %v", it[0], it[1], err))
+			return
+		}
+		if it[2] != req.Verb || it[3] != req.VersionId {
+			t.Error(fmt.Sprintf("unexpected %v %v verb and version, got: %v, %v", it[0], it[1], req.Verb, req.VersionId))
+			return
+		}
+	}
+	invalidTests := [][]string{
+		{http.MethodGet, "restore/@user/out.csv?versionId=v1"},
+		{http.MethodPost, "restore/@user/out.csv"},
+		{http.MethodPost, "restore/@user?versionId=v1"},
+	}
+	for _, it := range invalidTests {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + it[1])
+		if _, err := NewApiRequest(testUrl, it[0], testUser); nil == err {
+			t.Error(fmt.Sprintf("%v %v should have failed validation", it[0], it[1]))
+			return
+		}
+	}
+}
+
+func TestVersionsMgr(t *testing.T) {
+	objectPath := "/ws-storage-test/ws-storage-testsuite/goTestUser/out.csv"
+	copySource := ""
+	mgr, server, err := newStubS3Mgr(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		_, isVersions := query["versions"]
+		switch {
+		case r.Method == http.MethodGet && isVersions && query.Get("prefix") == "ws-storage-testsuite/goTestUser/out.csv":
+			fmt.Fprintf(w, `<ListVersionsResult><IsTruncated>false</IsTruncated>
+				<Version><Key>ws-storage-testsuite/goTestUser/out.csv</Key><VersionId>v2</VersionId><IsLatest>true</IsLatest><LastModified>2021-10-02T00:00:00.000Z</LastModified><ETag>"b"</ETag><Size>5</Size></Version>
+				<Version><Key>ws-storage-testsuite/goTestUser/out.csv</Key><VersionId>v1</VersionId><IsLatest>false</IsLatest><LastModified>2021-10-01T00:00:00.000Z</LastModified><ETag>"a"</ETag><Size>3</Size></Version>
+				<Version><Key>ws-storage-testsuite/goTestUser/out.csv.bak</Key><VersionId>v9</VersionId><IsLatest>true</IsLatest><LastModified>2021-10-03T00:00:00.000Z</LastModified><Size>1</Size></Version>
+				<DeleteMarker><Key>ws-storage-testsuite/goTestUser/out.csv</Key><VersionId>d1</VersionId><IsLatest>false</IsLatest><LastModified>2021-10-01T12:00:00.000Z</LastModified></DeleteMarker>
+			</ListVersionsResult>`)
+		case r.Method == http.MethodPut && r.URL.Path == objectPath:
+			copySource = r.Header.Get("X-Amz-Copy-Source")
+			fmt.Fprintf(w, `<CopyObjectResult><ETag>"a"</ETag></CopyObjectResult>`)
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	if nil != err {
+		return
+	}
+	defer server.Close()
+	cx := NewSessionContext(testUser)
+
+	versions, err := mgr.ListVersions(cx, "@user", "out.csv")
+	if nil != err || 3 != len(versions) {
+		t.Error(fmt.Sprintf("failed to list versions, got: %v, %v", versions, err))
+		return
+	}
+	if "v2" != versions[0].VersionId || !versions[0].IsLatest || 5 != versions[0].SizeBytes ||
+		"d1" != versions[1].VersionId || !versions[1].IsDeleteMarker ||
+		"v1" != versions[2].VersionId || "out.csv" != versions[2].WorkspaceKey {
+		t.Error(fmt.Sprintf("unexpected versions, got: %v", versions))
+		return
+	}
+
+	signedUrl, err := mgr.DownloadVersionUrl(cx, "@user", "out.csv", "v1", DownloadOptions{})
+	if nil != err || !strings.Contains(signedUrl, "versionId=v1") {
+		t.Error(fmt.Sprintf("unexpected version download url, got: %v, %v", signedUrl, err))
+		return
+	}
+
+	if err := mgr.RestoreVersion(cx, "@user", "out.csv", "v1"); nil != err ||
+		"ws-storage-test/ws-storage-testsuite/goTestUser/out.csv?versionId=v1" != copySource {
+		t.Error(fmt.Sprintf("unexpected restore, got: %v, copy source %v", err, copySource))
+		return
+	}
+	// restoring the current version is a no-op
+	copySource = ""
+	if err := mgr.RestoreVersion(cx, "@user", "out.csv", "v2"); nil != err || "" != copySource {
+		t.Error(fmt.Sprintf("unexpected restore of current version, got: %v, copy source %v", err, copySource))
+		return
+	}
+	if err := mgr.RestoreVersion(cx, "@user", "out.csv", "d1"); !errors.Is(err, ErrInvalidInput) {
+		t.Error(fmt.Sprintf("expected delete marker restore to fail, got: %v", err))
+		return
+	}
+	if err := mgr.RestoreVersion(cx, "@user", "out.csv", "v7"); !errors.Is(err, ErrNotFound) {
+		t.Error(fmt.Sprintf("expected unknown version restore to fail, got: %v", err))
+		return
+	}
+}
+
+func TestVersionsNotSupported(t *testing.T) {
+	mgr, err := NewMemoryManager(&Config{BucketPrefix: "ws-storage-testsuite"})
+	if nil != err {
+		t.Error(fmt.Sprintf("failed to initialize memory manager, got: %v", err))
+		return
+	}
+	authz := NewStaticAuthorizer(nil)
+	for _, path := range []string{"versions/@user/out.csv", "download/@user/out.csv?versionId=v1"} {
+		testUrl, _ := url.Parse("https://whatever/ws-storage/" + path)
+		req, err := NewApiRequest(testUrl, http.MethodGet, testUser)
+		if nil != err {
+			t.Error(fmt.Sprintf("unexpected path %v failed validation, got: %v", path, err))
+			return
+		}
+		result := req.HandleApiRequest(mgr, authz, nil, nil, nil)
+		if nil == result.Error || ErrorCodeInvalidInput != result.Error.Code {
+			t.Error(fmt.Sprintf("expected %v to be unsupported, got: %v", path, result))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"strings"
+)
+
+// UserWorkspace is the personal workspace of the requesting user
+const UserWorkspace = "@user"
+
+// workspaceRef locates a workspace's objects in the bucket
+type workspaceRef struct {
+	// Name identifies the workspace in results -
+	// the user name for the @user workspace,
+	// $type/$name (ex: @group/lab1) for named workspaces
+	Name   string
+	prefix string
+	owner  string
+}
+
+// Path validates the given key, and returns its bucket path
+func (self *workspaceRef) Path(key string) (string, error) {
+	return MakeS3Path(self.prefix, self.owner, key)
+}
+
+// SplitWorkspace splits a named workspace $type/$name
+// (ex: @group/lab1) into its type and name -
+// the name is empty for the @user workspace
+func SplitWorkspace(workspaceIn string) (string, string) {
+	ix := strings.Index(workspaceIn, "/")
+	if ix < 0 {
+		return workspaceIn, ""
+	}
+	return workspaceIn[:ix], workspaceIn[ix+1:]
+}
+
+// resolveWorkspace maps the workspace in an api request to
+// its location in the bucket.
+// The @user workspace is the requesting user's folder under the
+// bucket prefix, and a named workspace $type/$name is the $name
+// folder under the bucket prefix configured for its type.
+// Managers do not authorize access to named workspaces -
+// see Authorizer.
+func resolveWorkspace(config *Config, cx *SessionContext, workspaceIn string) (*workspaceRef, error) {
+	if workspaceIn == UserWorkspace {
+		return &workspaceRef{Name: cx.User, prefix: config.BucketPrefix, owner: cx.User}, nil
+	}
+	wsType, name := SplitWorkspace(workspaceIn)
+	prefix, ok := config.WorkspacePrefixes[wsType]
+	if !ok {
+		return nil, invalidInputf("invalid workspace - unknown workspace type: %v", wsType)
+	}
+	if "" == name || strings.Contains(name, "/") {
+		return nil, invalidInputf("invalid workspace name: %v", workspaceIn)
+	}
+	return &workspaceRef{Name: workspaceIn, prefix: prefix, owner: name}, nil
+}
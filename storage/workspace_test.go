@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestResolveWorkspace(t *testing.T) {
+	config := &Config{
+		BucketPrefix:      "ws-storage-testsuite",
+		WorkspacePrefixes: map[string]string{"@group": "ws-storage-groups"},
+	}
+	testCases := [][]string{
+		{"@user", "x/y", testUser, "ws-storage-testsuite/goTestUser/x/y"},
+		{"@group/lab1", "x/y", "@group/lab1", "ws-storage-groups/lab1/x/y"},
+	}
+	for _, it := range testCases {
+		workspace, err := resolveWorkspace(config, testSession, it[0])
+		if nil != err {
+			t.Error(fmt.Sprintf("failed to resolve workspace %v, got: %v", it[0], err))
+			return
+		}
+		path, err := workspace.Path(it[1])
+		if nil != err || workspace.Name != it[2] || path != it[3] {
+			t.Error(fmt.Sprintf("unexpected resolution of %v, got: %v, %v, %v", it[0], workspace.Name, path, err))
+			return
+		}
+	}
+	invalidTests := []string{"@project/lab1", "@group", "@group/", "@group/lab1/x", "frickjack"}
+	for _, it := range invalidTests {
+		if _, err := resolveWorkspace(config, testSession, it); nil == err {
+			t.Error(fmt.Sprintf("workspace should have failed validation: %v", it))
+			return
+		}
+	}
+}
+
+func TestValidateWorkspacePrefixes(t *testing.T) {
+	invalidTests := []map[string]string{
+		{"@group": "ws-storage-testsuite/"},
+		{"@group": "a", "@project": "a"},
+		{"@group": "a", "@project": "a/b"},
+		{"@group": "a/b/", "@project": "a"},
+		{"@group": "ws-storage-testsuite/groups"},
+		{"@group": "a", "@project": ""},
+		{"@user": "a"},
+		{"group": "a"},
+	}
+	for _, it := range invalidTests {
+		config := &Config{BucketPrefix: "ws-storage-testsuite", WorkspacePrefixes: it}
+		if err := validateWorkspacePrefixes(config); nil == err {
+			t.Error(fmt.Sprintf("workspace prefixes should have failed validation: %v", it))
+			return
+		}
+	}
+	config := &Config{BucketPrefix: "ws-storage-testsuite", WorkspacePrefixes: map[string]string{"@group": "a", "@project": "b"}}
+	if err := validateWorkspacePrefixes(config); nil != err {
+		t.Error(fmt.Sprintf("unexpected workspace prefix validation failure, got: %v", err))
+		return
+	}
+	// the bucket prefix nests inside a workspace type's prefix
+	config = &Config{BucketPrefix: "a/users", WorkspacePrefixes: map[string]string{"@group": "a"}}
+	if err := validateWorkspacePrefixes(config); nil == err {
+		t.Error("a bucket prefix inside a workspace type prefix should have failed validation")
+		return
+	}
+	// sibling prefixes that share leading characters do not nest
+	config = &Config{BucketPrefix: "ws", WorkspacePrefixes: map[string]string{"@group": "ws-groups", "@project": "wsp"}}
+	if err := validateWorkspacePrefixes(config); nil != err {
+		t.Error(fmt.Sprintf("unexpected workspace prefix validation failure, got: %v", err))
+		return
+	}
+}